@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	anthropic "github.com/liushuangls/go-anthropic"
+)
+
+// ClaudeClient implements the LLMClient interface for Anthropic
+type ClaudeClient struct {
+	client *anthropic.Client
+	model  string
+	params GenerationParams
+	usage  *Usage // Reported with the last response, see LastUsage
+}
+
+// defaultClaudeMaxTokens is used when no max tokens are configured, since the API requires a value
+const defaultClaudeMaxTokens = 4096
+
+func NewClaudeClient(apiKey, model string, params GenerationParams, httpClient *http.Client) *ClaudeClient {
+	client := anthropic.NewClient(apiKey, anthropic.WithHTTPClient(httpClient))
+
+	return &ClaudeClient{
+		client: client,
+		model:  model,
+		params: params,
+	}
+}
+
+// LastUsage returns the token counts Anthropic reported with the last response
+func (c *ClaudeClient) LastUsage() *Usage {
+	return c.usage
+}
+
+func (c *ClaudeClient) Complete(ctx context.Context, prompt string) (string, error) {
+	return c.CompleteConversation(ctx, UserPrompt(prompt), nil)
+}
+
+// CompleteConversation sends the conversation as alternating messages. The response is not
+// streamed, so onChunk is ignored.
+func (c *ClaudeClient) CompleteConversation(ctx context.Context, messages []Message, onChunk func(chunk string)) (string, error) {
+	c.usage = nil
+	Logf("Claude: Sending request to model %s (%d messages)", c.model, len(messages))
+
+	// Log model version info to help with debugging
+	Logf("Claude: Using client with model %s", c.model)
+
+	// Use the go-anthropic client to create a messages completion
+	mesReq := anthropic.MessagesRequest{
+		Model:     c.model,
+		MaxTokens: defaultClaudeMaxTokens,
+	}
+	for _, message := range messages {
+		switch message.Role {
+		case "system":
+			// Claude takes system instructions outside the messages
+			mesReq.System = strings.TrimPrefix(mesReq.System+"\n\n"+message.Content, "\n\n")
+		case "assistant":
+			mesReq.Messages = append(mesReq.Messages, anthropic.NewAssistantTextMessage(message.Content))
+		default:
+			mesReq.Messages = append(mesReq.Messages, anthropic.NewUserTextMessage(message.Content))
+		}
+	}
+
+	if c.params.MaxTokens > 0 {
+		mesReq.MaxTokens = c.params.MaxTokens
+	}
+	if c.params.Temperature != nil {
+		mesReq.SetTemperature(float32(*c.params.Temperature))
+	}
+	if c.params.TopP != nil {
+		mesReq.SetTopP(float32(*c.params.TopP))
+	}
+	if len(c.params.StopSequences) > 0 {
+		mesReq.StopSequences = c.params.StopSequences
+	}
+	if c.params.Seed != nil {
+		Logf("Claude: Seed isn't supported by the API, ignoring it")
+	}
+
+	Logf("Claude: Sending message to %s with max tokens: %d", c.model, mesReq.MaxTokens)
+
+	resp, err := c.client.CreateMessages(ctx, mesReq)
+	if err != nil {
+		var apiErr *anthropic.APIError
+		if errors.As(err, &apiErr) {
+			Logf("Claude ERROR: API error (type: %s): %s", apiErr.Type, apiErr.Message)
+
+			if apiErr.IsAuthenticationErr() {
+				return "", newAuthError("Claude", c.model, http.StatusUnauthorized, err)
+			}
+			if apiErr.IsPermissionErr() {
+				return "", newAuthError("Claude", c.model, http.StatusForbidden, err)
+			}
+
+			// Provide helpful guidance for model not found errors
+			if apiErr.Type == "not_found_error" && strings.Contains(apiErr.Message, "model") {
+				Logf("Claude ERROR: The specified model name '%s' was not found", c.model)
+				Logf("Claude INFO: Available Claude models typically include:")
+				Logf("  - claude-3-opus-20240229")
+				Logf("  - claude-3-sonnet-20240229")
+				Logf("  - claude-3-haiku-20240307")
+				return "", fmt.Errorf("Claude API error: Model '%s' not found. Try using claude-3-opus-20240229, claude-3-sonnet-20240229, or claude-3-haiku-20240307", c.model)
+			}
+
+			claudeErr := fmt.Errorf("Claude API error (type: %s): %s", apiErr.Type, apiErr.Message)
+			if apiErr.IsRateLimitErr() || apiErr.IsApiErr() || apiErr.IsOverloadedErr() {
+				return "", &retryableError{err: claudeErr}
+			}
+			return "", claudeErr
+		}
+		var reqErr *anthropic.RequestError
+		if errors.As(err, &reqErr) && isAuthStatus(reqErr.StatusCode) {
+			return "", newAuthError("Claude", c.model, reqErr.StatusCode, err)
+		}
+		Logf("Claude ERROR: Unknown error: %v", err)
+		return "", fmt.Errorf("Claude API error: %w", err)
+	}
+
+	Logf("Claude: Response received! ID: %s, Model: %s", resp.ID, resp.Model)
+	c.usage = &Usage{PromptTokens: resp.Usage.InputTokens, CompletionTokens: resp.Usage.OutputTokens}
+
+	// Get the response text from the content blocks
+	if len(resp.Content) > 0 {
+		for _, content := range resp.Content {
+			if content.Type == "text" {
+				return content.Text, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("Claude returned no text content")
+}
@@ -0,0 +1,407 @@
+// Package llm sends prompts to the model providers TicketDuck supports: OpenAI, Anthropic, and
+// local servers that speak Ollama's API or OpenAI's. CreateLLMClient returns the client for a
+// ModelConfig. Every client sends its requests with the *http.Client it's given, so callers
+// choose the proxy and tests can point it at an httptest.Server.
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	anthropic "github.com/liushuangls/go-anthropic"
+	"github.com/openai/openai-go"
+)
+
+// Logf records what the clients are doing. It does nothing until the application points it
+// at its log.
+var Logf = func(format string, v ...interface{}) {}
+
+// ---[ Configuration ]-------------------------------------------------------
+
+// ModelProvider represents the different AI providers supported by the application
+type ModelProvider string
+
+const (
+	ProviderOpenAI    ModelProvider = "openai"
+	ProviderAnthropic ModelProvider = "claude"
+	ProviderLocal     ModelProvider = "local"
+)
+
+// APIStyle is the request format a local server speaks
+type APIStyle string
+
+const (
+	APIStyleOllama APIStyle = "ollama" // Ollama's native /api/chat
+	APIStyleOpenAI APIStyle = "openai" // OpenAI chat completions (LM Studio, vLLM, llama.cpp server, etc.)
+)
+
+// ModelConfig holds configuration for a specific AI model
+type ModelConfig struct {
+	Provider   ModelProvider `json:"provider"`
+	ModelName  string        `json:"model_name"`
+	APIKey     string        `json:"api_key,omitempty"`
+	APIBaseURL string        `json:"api_base_url,omitempty"` // For local models or custom endpoints
+	APIStyle   APIStyle      `json:"api_style,omitempty"`    // For local models: "ollama" or "openai"
+
+	// OpenAI only: the organization requests are billed to, and a system message sent before the prompt
+	OrgID        string `json:"org_id,omitempty"`
+	SystemPrompt string `json:"system_prompt,omitempty"`
+
+	// Optional generation settings; when unset the provider defaults are used
+	MaxTokens     int      `json:"max_tokens,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	Seed          *int64   `json:"seed,omitempty"` // OpenAI only; a fixed seed makes output repeatable
+	StopSequences []string `json:"stop_sequences,omitempty"`
+
+	// Size of the model's context window in tokens; when unset it's looked up by model name
+	ContextWindow int `json:"context_window,omitempty"`
+
+	// Requests allowed per minute before TicketDuck waits, see rateLimiterFor; 0 means no limit
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+
+	// APIKeyFromEnv is set when APIKey was resolved from an environment variable,
+	// so that saveConfig knows not to write it to disk. It's never saved itself: it
+	// only holds while the variable is set.
+	APIKeyFromEnv bool `json:"-"`
+}
+
+// GenerationParams holds the optional request settings passed to the API clients.
+// Zero values mean "use the provider's default".
+type GenerationParams struct {
+	MaxTokens     int
+	Temperature   *float64
+	TopP          *float64
+	Seed          *int64 // Only sent by the OpenAI client
+	StopSequences []string
+	SystemPrompt  string // Only sent by the OpenAI client
+}
+
+// GenerationParams returns the request settings configured for this model
+func (c ModelConfig) GenerationParams() GenerationParams {
+	return GenerationParams{
+		MaxTokens:     c.MaxTokens,
+		Temperature:   c.Temperature,
+		TopP:          c.TopP,
+		Seed:          c.Seed,
+		StopSequences: c.StopSequences,
+		SystemPrompt:  c.SystemPrompt,
+	}
+}
+
+// LocalAPIStyle returns the API style of a local server. Entries saved before api_style existed
+// are assumed to be Ollama if they point at Ollama's default port.
+func (c ModelConfig) LocalAPIStyle() APIStyle {
+	if c.APIStyle != "" {
+		return c.APIStyle
+	}
+	if strings.Contains(c.APIBaseURL, "localhost:11434") || strings.Contains(c.APIBaseURL, "127.0.0.1:11434") {
+		return APIStyleOllama
+	}
+	return APIStyleOpenAI
+}
+
+// String describes the effective settings for logging
+func (p GenerationParams) String() string {
+	maxTokens := "default"
+	if p.MaxTokens > 0 {
+		maxTokens = fmt.Sprintf("%d", p.MaxTokens)
+	}
+	temperature := "default"
+	if p.Temperature != nil {
+		temperature = fmt.Sprintf("%.2f", *p.Temperature)
+	}
+	topP := "default"
+	if p.TopP != nil {
+		topP = fmt.Sprintf("%.2f", *p.TopP)
+	}
+	seed := "default"
+	if p.Seed != nil {
+		seed = fmt.Sprintf("%d", *p.Seed)
+	}
+	stop := "none"
+	if len(p.StopSequences) > 0 {
+		stop = fmt.Sprintf("%q", p.StopSequences)
+	}
+	return fmt.Sprintf("max tokens: %s, temperature: %s, top_p: %s, seed: %s, stop sequences: %s", maxTokens, temperature, topP, seed, stop)
+}
+
+// ---[ Clients ]-------------------------------------------------------------
+
+// LLMClient defines the interface for different LLM providers
+type LLMClient interface {
+	Complete(ctx context.Context, prompt string) (string, error)
+}
+
+// StreamingLLMClient is implemented by clients that can deliver the response as it is generated.
+// onChunk is called with each piece of text, and the full response is returned at the end.
+type StreamingLLMClient interface {
+	LLMClient
+	CompleteStream(ctx context.Context, prompt string, onChunk func(chunk string)) (string, error)
+}
+
+// Message is one turn of a conversation with the model
+type Message struct {
+	Role    string // "system", "user" or "assistant"
+	Content string
+}
+
+// UserPrompt returns a conversation made of a single user message
+func UserPrompt(prompt string) []Message {
+	return []Message{{Role: "user", Content: prompt}}
+}
+
+// ConversationLLMClient is implemented by clients that can send the earlier turns of a
+// conversation as separate messages. The response is streamed to onChunk unless it is nil.
+type ConversationLLMClient interface {
+	LLMClient
+	CompleteConversation(ctx context.Context, messages []Message, onChunk func(chunk string)) (string, error)
+}
+
+// Usage is the number of tokens a provider counted for a request
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// UsageReporter is implemented by clients whose provider reports token usage. LastUsage
+// returns the usage of the last request that succeeded, or nil if the provider didn't report it.
+type UsageReporter interface {
+	LastUsage() *Usage
+}
+
+// mergeSystemMessages folds system messages into the user message that follows them, for
+// APIs without a system role
+func mergeSystemMessages(messages []Message) []Message {
+	merged := make([]Message, 0, len(messages))
+	system := ""
+	for _, message := range messages {
+		switch {
+		case message.Role == "system":
+			system = strings.TrimPrefix(system+"\n\n"+message.Content, "\n\n")
+		case message.Role == "user" && system != "":
+			merged = append(merged, Message{Role: "user", Content: system + "\n\n" + message.Content})
+			system = ""
+		default:
+			merged = append(merged, message)
+		}
+	}
+	return merged
+}
+
+// FlattenConversation writes a conversation out as a single prompt, for clients that only take one
+func FlattenConversation(messages []Message) string {
+	messages = mergeSystemMessages(messages)
+	if len(messages) == 1 {
+		return messages[0].Content
+	}
+
+	var sb strings.Builder
+	for _, message := range messages {
+		label := "User"
+		if message.Role == "assistant" {
+			label = "Assistant"
+		}
+		sb.WriteString(fmt.Sprintf("%s:\n%s\n\n", label, message.Content))
+	}
+	sb.WriteString("Assistant:\n")
+	return sb.String()
+}
+
+// CreateLLMClient creates an appropriate client based on the model configuration
+func CreateLLMClient(config ModelConfig, httpClient *http.Client) (LLMClient, error) {
+	Logf("Creating LLM client for provider: %s, model: %s", config.Provider, config.ModelName)
+
+	switch config.Provider {
+	case ProviderOpenAI:
+		if config.APIKey == "" {
+			Logf("ERROR: OpenAI API key is missing")
+			return nil, fmt.Errorf("OpenAI API key is required")
+		}
+
+		// Log key length and first/last characters for debugging
+		keyLength := len(config.APIKey)
+		Logf("OpenAI: Using API key with length: %d characters", keyLength)
+
+		if keyLength < 20 {
+			Logf("WARNING: OpenAI API key seems too short (length: %d), may be invalid", keyLength)
+		}
+
+		if keyLength >= 10 {
+			firstChars := config.APIKey[:4]
+			lastChars := config.APIKey[keyLength-4:]
+			Logf("OpenAI: Key prefix: %s..., suffix: ...%s", firstChars, lastChars)
+		}
+
+		if config.APIBaseURL != "" || config.OrgID != "" {
+			Logf("OpenAI: Using base URL %q, organization %q", config.APIBaseURL, config.OrgID)
+		}
+
+		return NewOpenAIClient(config.APIKey, config.ModelName, config.GenerationParams(), httpClient, openAIEndpointOptions(config)...), nil
+
+	case ProviderAnthropic:
+		if config.APIKey == "" {
+			Logf("ERROR: Claude API key is missing")
+			return nil, fmt.Errorf("Claude API key is required")
+		}
+
+		keyLength := len(config.APIKey)
+		Logf("Claude: Using API key with length: %d characters", keyLength)
+
+		if keyLength < 20 {
+			Logf("WARNING: Claude API key seems too short (length: %d), may be invalid", keyLength)
+		}
+
+		return NewClaudeClient(config.APIKey, config.ModelName, config.GenerationParams(), httpClient), nil
+
+	case ProviderLocal:
+		if config.APIBaseURL == "" {
+			Logf("ERROR: Local LLM API base URL is missing")
+			return nil, fmt.Errorf("API base URL is required for local models")
+		}
+
+		Logf("Local LLM: Using API base URL: %s", config.APIBaseURL)
+
+		// Validate model name
+		modelName := config.ModelName
+		if modelName == "" {
+			Logf("WARNING: Local LLM model name is empty, using default 'llama3'")
+			modelName = "llama3"
+		}
+
+		Logf("Local LLM: Using model name: %s", modelName)
+
+		Logf("Local LLM: Using API style: %s", config.LocalAPIStyle())
+
+		if _, err := buildLocalEndpoint(config.APIBaseURL, config.LocalAPIStyle() == APIStyleOllama); err != nil {
+			Logf("ERROR: Local LLM API base URL is invalid: %v", err)
+			return nil, err
+		}
+
+		client := NewLocalLLMClient(config.APIBaseURL, modelName, config.APIKey, config.LocalAPIStyle(), httpClient)
+
+		// Fail fast with guidance if Ollama isn't running
+		if client.apiStyle == APIStyleOllama {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := client.checkOllama(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		return client, nil
+
+	default:
+		Logf("ERROR: Unsupported provider: %s", config.Provider)
+		return nil, fmt.Errorf("unsupported provider: %s", config.Provider)
+	}
+}
+
+// ---[ Errors ]--------------------------------------------------------------
+
+// retryableError marks a provider error as transient, for errors whose original type
+// doesn't carry a status code
+type retryableError struct {
+	err        error
+	retryAfter time.Duration // How long the server asked us to wait, if it said
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// AuthError reports that the provider rejected the API key. The raw error is logged when it's
+// created and kept for errors.As and errors.Unwrap.
+type AuthError struct {
+	provider  string // Shown to the user, e.g. "OpenAI"
+	model     string
+	forbidden bool // 403: the key is valid but may not use the model
+	err       error
+}
+
+// newAuthError returns an AuthError for a 401 or 403 response, logging the raw error
+func newAuthError(provider, model string, status int, err error) error {
+	Logf("%s ERROR: The API key was rejected (HTTP %d): %v", provider, status, err)
+	return &AuthError{provider: provider, model: model, forbidden: status == http.StatusForbidden, err: err}
+}
+
+func (e *AuthError) Error() string {
+	if e.forbidden {
+		return fmt.Sprintf("your %s API key isn't allowed to use %s; check its permissions, or reconfigure with ~ then c", e.provider, e.model)
+	}
+	return fmt.Sprintf("your %s API key appears invalid or expired; reconfigure with ~ then c", e.provider)
+}
+func (e *AuthError) Unwrap() error { return e.err }
+
+// isAuthStatus reports whether an HTTP status means the API key was rejected
+func isAuthStatus(code int) bool {
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+// retryableStatus reports whether an HTTP status is worth retrying
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code <= 503)
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// IsRetryable reports whether err is transient, along with any delay the server asked for
+func IsRetryable(err error) (bool, time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		return false, 0
+	}
+
+	var retryErr *retryableError
+	if errors.As(err, &retryErr) {
+		return true, retryErr.retryAfter
+	}
+
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		var retryAfter time.Duration
+		if openaiErr.Response != nil {
+			retryAfter = parseRetryAfter(openaiErr.Response.Header)
+		}
+		return retryableStatus(openaiErr.StatusCode), retryAfter
+	}
+
+	var anthropicReqErr *anthropic.RequestError
+	if errors.As(err, &anthropicReqErr) {
+		return retryableStatus(anthropicReqErr.StatusCode), 0
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+
+	return false, 0
+}
+
+// IsUnavailable reports whether err means the provider couldn't serve the request right now:
+// nothing answered at its address, or it was overloaded, failing, or too slow. Errors the user
+// has to fix, such as a rejected API key, aren't included.
+func IsUnavailable(err error) bool {
+	if retryable, _ := IsRetryable(err); retryable {
+		return true
+	}
+	return isDialError(err)
+}
@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateLLMClient(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":"0.1.0"}`))
+	}))
+	defer ollama.Close()
+
+	tests := []struct {
+		name    string
+		config  ModelConfig
+		want    string // Type of the client, or "" when an error is expected
+		wantErr string
+	}{
+		{"openai", ModelConfig{Provider: ProviderOpenAI, ModelName: "gpt-4o", APIKey: "sk-0123456789abcdefghij"}, "*llm.OpenAIClient", ""},
+		{"openai without key", ModelConfig{Provider: ProviderOpenAI, ModelName: "gpt-4o"}, "", "OpenAI API key is required"},
+		{"claude", ModelConfig{Provider: ProviderAnthropic, ModelName: "claude-3-haiku", APIKey: "sk-ant-0123456789abcdef"}, "*llm.ClaudeClient", ""},
+		{"claude without key", ModelConfig{Provider: ProviderAnthropic, ModelName: "claude-3-haiku"}, "", "Claude API key is required"},
+		{"local openai style", ModelConfig{Provider: ProviderLocal, APIBaseURL: "http://127.0.0.1:8000", APIStyle: APIStyleOpenAI}, "*llm.LocalLLMClient", ""},
+		{"local ollama", ModelConfig{Provider: ProviderLocal, APIBaseURL: ollama.URL, APIStyle: APIStyleOllama}, "*llm.LocalLLMClient", ""},
+		{"local without base URL", ModelConfig{Provider: ProviderLocal, APIStyle: APIStyleOpenAI}, "", "API base URL is required"},
+		{"local with invalid base URL", ModelConfig{Provider: ProviderLocal, APIBaseURL: "ftp://example.com", APIStyle: APIStyleOpenAI}, "", "must start with http:// or https://"},
+		{"ollama not running", ModelConfig{Provider: ProviderLocal, APIBaseURL: "http://127.0.0.1:1", APIStyle: APIStyleOllama}, "", "could not reach Ollama"},
+		{"unknown provider", ModelConfig{Provider: "gemini"}, "", "unsupported provider"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := CreateLLMClient(tt.config, http.DefaultClient)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CreateLLMClient() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateLLMClient() error = %v", err)
+			}
+			if got := fmt.Sprintf("%T", client); got != tt.want {
+				t.Errorf("CreateLLMClient() returned %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelConfigDoesNotSaveAPIKeyFromEnv(t *testing.T) {
+	data, err := json.Marshal(ModelConfig{Provider: ProviderOpenAI, ModelName: "gpt-4o", APIKeyFromEnv: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "APIKeyFromEnv") {
+		t.Errorf("ModelConfig marshalled to %s; APIKeyFromEnv must not be saved", data)
+	}
+
+	var config ModelConfig
+	if err := json.Unmarshal([]byte(`{"provider":"openai","APIKeyFromEnv":true}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.APIKeyFromEnv {
+		t.Error("APIKeyFromEnv was read from an old config file")
+	}
+}
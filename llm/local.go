@@ -0,0 +1,470 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// LocalLLMClient implements the LLMClient interface for local LLMs
+type LocalLLMClient struct {
+	baseURL    string
+	model      string
+	apiKey     string // Optional; sent as a Bearer token when set
+	apiStyle   APIStyle
+	httpClient *http.Client
+}
+
+func NewLocalLLMClient(baseURL, model, apiKey string, apiStyle APIStyle, httpClient *http.Client) *LocalLLMClient {
+	return &LocalLLMClient{
+		baseURL:    baseURL,
+		model:      model,
+		apiKey:     apiKey,
+		apiStyle:   apiStyle,
+		httpClient: httpClient,
+	}
+}
+
+func (c *LocalLLMClient) Complete(ctx context.Context, prompt string) (string, error) {
+	return c.CompleteConversation(ctx, UserPrompt(prompt), nil)
+}
+
+func (c *LocalLLMClient) CompleteStream(ctx context.Context, prompt string, onChunk func(chunk string)) (string, error) {
+	return c.CompleteConversation(ctx, UserPrompt(prompt), onChunk)
+}
+
+func (c *LocalLLMClient) CompleteConversation(ctx context.Context, messages []Message, onChunk func(chunk string)) (string, error) {
+	if onChunk != nil {
+		return c.streamConversation(ctx, messages, onChunk)
+	}
+
+	Logf("Local LLM: Sending request to %s, model: %s (%d messages)", c.baseURL, c.model, len(messages))
+
+	endpoint, err := buildLocalEndpoint(c.baseURL, c.apiStyle == APIStyleOllama)
+	if err != nil {
+		return "", err
+	}
+
+	// For Ollama's native API format
+	if c.apiStyle == APIStyleOllama {
+		Logf("Local LLM: Using Ollama native endpoint: %s", endpoint)
+
+		resp, err := c.sendOllamaChat(ctx, endpoint, messages, false)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		// Read the full response body
+		responseBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			Logf("Local LLM ERROR: Failed to read response body: %v", err)
+			return "", fmt.Errorf("failed to read Ollama response: %v", err)
+		}
+
+		// Log the raw response for debugging
+		Logf("Local LLM: Raw response from Ollama (%d bytes): %.500s...", len(responseBody), string(responseBody))
+
+		// Parse response
+		var result struct {
+			Message struct {
+				Content string `json:"content"`
+				Role    string `json:"role"`
+			} `json:"message"`
+			Done bool `json:"done"`
+		}
+
+		if err := json.Unmarshal(responseBody, &result); err != nil {
+			Logf("Local LLM ERROR: Failed to parse Ollama response JSON: %v", err)
+			Logf("Local LLM ERROR: Response causing the error: %.500s...", string(responseBody))
+			return "", fmt.Errorf("failed to parse Ollama response: %v", err)
+		}
+
+		responseContent := result.Message.Content
+		responseRole := result.Message.Role
+		Logf("Local LLM: Response content length: %d characters, role: %s", len(responseContent), responseRole)
+
+		// Log a substantial preview of the response
+		if len(responseContent) > 0 {
+			previewLength := 500
+			if len(responseContent) < previewLength {
+				previewLength = len(responseContent)
+			}
+			Logf("Local LLM: Response preview: %s", responseContent[:previewLength])
+
+			// Also log the end of the content if it's longer
+			if len(responseContent) > previewLength {
+				endPreviewStart := len(responseContent) - 100
+				if endPreviewStart < previewLength {
+					endPreviewStart = previewLength
+				}
+				Logf("Local LLM: Response end: %s", responseContent[endPreviewStart:])
+			}
+		} else {
+			Logf("Local LLM WARNING: Received empty response content")
+		}
+
+		return responseContent, nil
+	}
+
+	// Standard OpenAI-compatible API for non-Ollama servers
+	client := c.openAICompatClient(endpoint)
+
+	// Structure the request according to OpenAI's expectations
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(openAIMessages(messages)),
+		Model:    openai.F(c.model),
+	}
+
+	Logf("Local LLM: Sending request to model: %s with prompt: %.100s...", c.model, messages[len(messages)-1].Content)
+
+	// Make the API call
+	chatCompletion, err := client.Chat.Completions.New(ctx, params)
+
+	if err != nil {
+		Logf("Local LLM ERROR: API request failed: %v", err)
+
+		// Additional debugging information
+		Logf("Request details - URL: %s, Model: %s", endpoint, c.model)
+		Logf("Error details: %v", err)
+
+		return "", c.describeError(err)
+	}
+
+	// Debug the response
+	Logf("Local LLM: Response received, choices: %d", len(chatCompletion.Choices))
+
+	if len(chatCompletion.Choices) == 0 {
+		return "", fmt.Errorf("No content returned from the LLM")
+	}
+
+	responseContent := chatCompletion.Choices[0].Message.Content
+	Logf("Local LLM: Response content length: %d", len(responseContent))
+	Logf("Local LLM: Response preview: %.100s...", responseContent)
+
+	return responseContent, nil
+}
+
+// isDialError reports whether err means nothing was listening at the server's address
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, syscall.ECONNREFUSED) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+// unreachableError explains that a local server couldn't be reached, or returns err unchanged
+// if it failed for some other reason. The raw error is logged.
+func (c *LocalLLMClient) unreachableError(err error) error {
+	if !isDialError(err) {
+		return err
+	}
+	Logf("Local LLM ERROR: Could not connect to %s: %v", c.baseURL, err)
+	if c.apiStyle == APIStyleOllama {
+		return &serverUnreachableError{msg: fmt.Sprintf("could not reach Ollama at %s. Is `ollama serve` running?", c.baseURL), err: err}
+	}
+	return &serverUnreachableError{msg: fmt.Sprintf("could not reach the local model server at %s. Is it running?", c.baseURL), err: err}
+}
+
+// serverUnreachableError explains that a server couldn't be reached, keeping the dial error underneath
+type serverUnreachableError struct {
+	msg string
+	err error
+}
+
+func (e *serverUnreachableError) Error() string { return e.msg }
+func (e *serverUnreachableError) Unwrap() error { return e.err }
+
+// checkOllama asks Ollama for its version, so that a server that isn't running is reported
+// before the prompt is sent. Other problems are only logged and left to the request itself.
+func (c *LocalLLMClient) checkOllama(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(c.baseURL, "/")+"/api/version", nil)
+	if err != nil {
+		Logf("Local LLM WARNING: Skipping health check: %v", err)
+		return nil
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if isDialError(err) {
+			return c.unreachableError(err)
+		}
+		Logf("Local LLM WARNING: Health check failed: %v", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var version struct {
+		Version string `json:"version"`
+	}
+	if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&version) == nil {
+		Logf("Local LLM: Ollama version %s is running at %s", version.Version, c.baseURL)
+	} else {
+		Logf("Local LLM WARNING: %s/api/version returned %s", c.baseURL, resp.Status)
+	}
+	return nil
+}
+
+// sendOllamaChat posts prompt to Ollama's native /api/chat endpoint and returns the response once
+// its status has been checked. The caller must close the body.
+func (c *LocalLLMClient) sendOllamaChat(ctx context.Context, endpoint string, messages []Message, stream bool) (*http.Response, error) {
+	// Create Ollama-specific request body
+	type OllamaMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+
+	type OllamaRequest struct {
+		Model    string          `json:"model"`
+		Messages []OllamaMessage `json:"messages"`
+		Stream   bool            `json:"stream"`
+	}
+
+	ollamaReq := OllamaRequest{
+		Model:  c.model,
+		Stream: stream,
+	}
+	// Not every model served by Ollama follows a system message, so the instructions stay in the prompt
+	for _, message := range mergeSystemMessages(messages) {
+		ollamaReq.Messages = append(ollamaReq.Messages, OllamaMessage{Role: message.Role, Content: message.Content})
+	}
+
+	Logf("Local LLM: Using Ollama-specific request format (stream: %t)", stream)
+	jsonBody, err := json.Marshal(ollamaReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Ollama request: %v", err)
+	}
+
+	// Create HTTP request
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	// Send request; the deadline comes from ctx (see RequestSettings)
+	Logf("Local LLM: Sending request to Ollama API at %s", endpoint)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		Logf("Local LLM ERROR: API request failed: %v", err)
+		if isDialError(err) {
+			return nil, c.unreachableError(err)
+		}
+		return nil, fmt.Errorf("Local LLM API error: %w", err)
+	}
+
+	// Log response status
+	Logf("Local LLM: Received response with status: %s", resp.Status)
+
+	// Check for non-200 status code
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		// Read error response body
+		errBody, _ := ioutil.ReadAll(resp.Body)
+		Logf("Local LLM ERROR: Bad status code: %d, response: %s", resp.StatusCode, string(errBody))
+		err := fmt.Errorf("Ollama API returned %s: %s", resp.Status, string(errBody))
+		if isAuthStatus(resp.StatusCode) {
+			return nil, newAuthError("local server", c.model, resp.StatusCode, err)
+		}
+		if retryableStatus(resp.StatusCode) {
+			return nil, &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header)}
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// describeError explains errors from an OpenAI-compatible server: one that can't be reached,
+// or that rejects the API key
+func (c *LocalLLMClient) describeError(err error) error {
+	if isDialError(err) {
+		return c.unreachableError(err)
+	}
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && isAuthStatus(apiErr.StatusCode) {
+		return newAuthError("local server", c.model, apiErr.StatusCode, err)
+	}
+	return fmt.Errorf("Local LLM API error: %w", err)
+}
+
+// openAICompatClient returns an OpenAI SDK client that sends chat requests to endpoint
+func (c *LocalLLMClient) openAICompatClient(endpoint string) *openai.Client {
+	baseURL := strings.TrimSuffix(endpoint, "chat/completions")
+	Logf("Local LLM: Using OpenAI-compatible base URL: %s", baseURL)
+
+	opts := []option.RequestOption{
+		option.WithBaseURL(baseURL),
+		option.WithMaxRetries(0),
+		option.WithHTTPClient(c.httpClient),
+	}
+	return openai.NewClient(append(opts, localCredentials(c.apiKey)...)...)
+}
+
+// localCredentials returns the options that authenticate with a local server: its own key, or
+// none at all. The SDK otherwise picks up OPENAI_API_KEY, OPENAI_ORG_ID and OPENAI_PROJECT_ID,
+// which would send the user's OpenAI credentials to whatever server the address points at.
+func localCredentials(apiKey string) []option.RequestOption {
+	opts := []option.RequestOption{
+		option.WithAPIKey(apiKey),
+		option.WithHeaderDel("OpenAI-Organization"),
+		option.WithHeaderDel("OpenAI-Project"),
+	}
+	if apiKey == "" {
+		opts = append(opts, option.WithHeaderDel("Authorization"))
+	}
+	return opts
+}
+
+// streamConversation streams the response, reading Ollama's newline-delimited JSON objects or the
+// OpenAI-compatible server-sent events, and passes each piece of text to onChunk
+func (c *LocalLLMClient) streamConversation(ctx context.Context, messages []Message, onChunk func(chunk string)) (string, error) {
+	Logf("Local LLM: Streaming request to %s, model: %s", c.baseURL, c.model)
+
+	endpoint, err := buildLocalEndpoint(c.baseURL, c.apiStyle == APIStyleOllama)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	chunks := 0
+
+	if c.apiStyle == APIStyleOllama {
+		resp, err := c.sendOllamaChat(ctx, endpoint, messages, true)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		// Each line is an object holding the next piece of the message; the last has done: true
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var part struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+				Done  bool   `json:"done"`
+				Error string `json:"error"`
+			}
+			if err := decoder.Decode(&part); err != nil {
+				if err == io.EOF {
+					Logf("Local LLM ERROR: Stream ended before the final object after %d chunks", chunks)
+					return "", fmt.Errorf("Ollama stream ended unexpectedly")
+				}
+				Logf("Local LLM ERROR: Failed to read stream after %d chunks: %v", chunks, err)
+				return "", fmt.Errorf("failed to read Ollama stream: %w", err)
+			}
+			if part.Error != "" {
+				Logf("Local LLM ERROR: Ollama reported an error mid-stream: %s", part.Error)
+				return "", fmt.Errorf("Ollama error: %s", part.Error)
+			}
+
+			if text := part.Message.Content; text != "" {
+				sb.WriteString(text)
+				chunks++
+				onChunk(text)
+			}
+			if part.Done {
+				break
+			}
+		}
+
+		Logf("Local LLM: Stream finished, received %d chunks, %d characters", chunks, sb.Len())
+		return sb.String(), nil
+	}
+
+	// Standard OpenAI-compatible API for non-Ollama servers
+	client := c.openAICompatClient(endpoint)
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(openAIMessages(messages)),
+		Model:    openai.F(c.model),
+	}
+
+	stream := client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	for stream.Next() {
+		chunk := stream.Current()
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		text := chunk.Choices[0].Delta.Content
+		sb.WriteString(text)
+		chunks++
+		onChunk(text)
+	}
+
+	if err := stream.Err(); err != nil {
+		Logf("Local LLM ERROR: Streaming request failed after %d chunks: %v", chunks, err)
+		return "", c.describeError(err)
+	}
+
+	Logf("Local LLM: Stream finished, received %d chunks, %d characters", chunks, sb.Len())
+	return sb.String(), nil
+}
+
+// buildLocalEndpoint returns the chat endpoint of a local server from its configured address:
+// Ollama's /api/chat, or /v1/chat/completions for an OpenAI-compatible server. The address may
+// already name the endpoint or end in /v1; any other path is kept as a prefix, e.g. for a server
+// behind a reverse proxy. An address that isn't an http(s) URL with a host is an error.
+func buildLocalEndpoint(baseURL string, isOllama bool) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return "", fmt.Errorf("invalid server address %q: %v", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid server address %q: it must start with http:// or https://", baseURL)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid server address %q: it has no host", baseURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid server address %q: it can't have a query or fragment", baseURL)
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+	if isOllama {
+		path = strings.TrimSuffix(path, "/api/chat")
+		// Ollama serves its OpenAI-compatible API under /v1, next to /api
+		path = strings.TrimSuffix(path, "/chat/completions")
+		path = strings.TrimSuffix(path, "/v1") + "/api/chat"
+	} else {
+		path = strings.TrimSuffix(path, "/chat/completions")
+		if !strings.HasSuffix(path, "/v1") {
+			path += "/v1"
+		}
+		path += "/chat/completions"
+	}
+	u.Path = path
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// openAICompatBaseURL turns the configured address of an OpenAI-compatible server into the base
+// URL the SDK expects, e.g. http://localhost:1234 or http://localhost:1234/v1/chat/completions
+// both become http://localhost:1234/v1/
+func openAICompatBaseURL(baseURL string) (string, error) {
+	endpoint, err := buildLocalEndpoint(baseURL, false)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(endpoint, "chat/completions"), nil
+}
@@ -0,0 +1,189 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalLLMClientOllama(t *testing.T) {
+	var gotPath string
+	var gotRequest struct {
+		Model    string    `json:"model"`
+		Stream   bool      `json:"stream"`
+		Messages []Message `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotRequest)
+		if gotRequest.Stream {
+			for _, chunk := range []string{"Hel", "lo"} {
+				fmt.Fprintf(w, "{\"message\":{\"role\":\"assistant\",\"content\":%q},\"done\":false}\n", chunk)
+			}
+			fmt.Fprint(w, "{\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true}\n")
+			return
+		}
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Hello"},"done":true}`))
+	}))
+	defer server.Close()
+
+	client := NewLocalLLMClient(server.URL, "llama3", "", APIStyleOllama, server.Client())
+	messages := []Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Summarize this"}}
+
+	response, err := client.CompleteConversation(context.Background(), messages, nil)
+	if err != nil {
+		t.Fatalf("CompleteConversation() error = %v", err)
+	}
+	if response != "Hello" {
+		t.Errorf("CompleteConversation() = %q, want %q", response, "Hello")
+	}
+	if gotPath != "/api/chat" {
+		t.Errorf("request went to %s, want /api/chat", gotPath)
+	}
+	// Ollama gets the system message folded into the prompt
+	if gotRequest.Model != "llama3" || len(gotRequest.Messages) != 1 || gotRequest.Messages[0].Content != "Be brief.\n\nSummarize this" {
+		t.Errorf("request = %+v, want one user message starting with the system message", gotRequest)
+	}
+
+	var chunks []string
+	response, err = client.CompleteConversation(context.Background(), messages, func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("streamed CompleteConversation() error = %v", err)
+	}
+	if response != "Hello" || strings.Join(chunks, "|") != "Hel|lo" {
+		t.Errorf("streamed CompleteConversation() = %q with chunks %q, want %q in two chunks", response, chunks, "Hello")
+	}
+}
+
+func TestLocalLLMClientOpenAICompatible(t *testing.T) {
+	var gotPath, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(chatCompletion("Hello")))
+	}))
+	defer server.Close()
+
+	client := NewLocalLLMClient(server.URL, "qwen", "secret", APIStyleOpenAI, server.Client())
+	response, err := client.Complete(context.Background(), "Summarize this")
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if response != "Hello" {
+		t.Errorf("Complete() = %q, want %q", response, "Hello")
+	}
+	if gotPath != "/v1/chat/completions" {
+		t.Errorf("request went to %s, want /v1/chat/completions", gotPath)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the API key as a bearer token", gotAuth)
+	}
+}
+
+func TestLocalLLMClientWithoutKeySendsNoCredentials(t *testing.T) {
+	// The user's OpenAI credentials must not leak to a local server that needs none
+	t.Setenv("OPENAI_API_KEY", "sk-real-openai-key")
+	t.Setenv("OPENAI_ORG_ID", "org-real")
+	t.Setenv("OPENAI_PROJECT_ID", "proj-real")
+
+	var requests []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Clone())
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/models" {
+			w.Write([]byte(`{"object": "list", "data": [{"id": "qwen", "object": "model"}]}`))
+			return
+		}
+		w.Write([]byte(chatCompletion("Hello")))
+	}))
+	defer server.Close()
+
+	client := NewLocalLLMClient(server.URL, "qwen", "", APIStyleOpenAI, server.Client())
+	if _, err := client.Complete(context.Background(), "Summarize this"); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	config := ModelConfig{Provider: ProviderLocal, ModelName: "qwen", APIBaseURL: server.URL, APIStyle: APIStyleOpenAI}
+	if _, err := ListModels(context.Background(), config, server.Client()); err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("server got %d requests, want 2", len(requests))
+	}
+	for _, header := range requests {
+		for _, name := range []string{"Authorization", "OpenAI-Organization", "OpenAI-Project"} {
+			if got := header.Get(name); got != "" {
+				t.Errorf("%s = %q reached the local server", name, got)
+			}
+		}
+	}
+}
+
+func TestLocalLLMClientUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close() // Nothing listens at url any more
+
+	for _, style := range []APIStyle{APIStyleOllama, APIStyleOpenAI} {
+		client := NewLocalLLMClient(url, "llama3", "", style, http.DefaultClient)
+		_, err := client.Complete(context.Background(), "Summarize this")
+		if err == nil || !strings.Contains(err.Error(), "could not reach") {
+			t.Errorf("%s: Complete() error = %v, want one saying the server couldn't be reached", style, err)
+		}
+		if !IsUnavailable(err) {
+			t.Errorf("%s: IsUnavailable(%v) = false, want true", style, err)
+		}
+	}
+}
+
+func TestBuildLocalEndpoint(t *testing.T) {
+	tests := []struct {
+		baseURL    string
+		ollama     string // Endpoint with isOllama, or "" for an error
+		compatible string // Endpoint without it, or "" for an error
+	}{
+		{"http://host:11434", "http://host:11434/api/chat", "http://host:11434/v1/chat/completions"},
+		{"http://host:11434/", "http://host:11434/api/chat", "http://host:11434/v1/chat/completions"},
+		{"http://host:11434/v1", "http://host:11434/api/chat", "http://host:11434/v1/chat/completions"},
+		{"http://host:11434/v1/", "http://host:11434/api/chat", "http://host:11434/v1/chat/completions"},
+		{"http://host:1234/v1/chat/completions", "http://host:1234/api/chat", "http://host:1234/v1/chat/completions"},
+		{"https://example.com/llm", "https://example.com/llm/api/chat", "https://example.com/llm/v1/chat/completions"},
+		{"https://example.com/llm/v1/", "https://example.com/llm/api/chat", "https://example.com/llm/v1/chat/completions"},
+		{"http://[::1]:11434", "http://[::1]:11434/api/chat", "http://[::1]:11434/v1/chat/completions"},
+		{"http://[fe80::1%25en0]:8080/v1", "http://[fe80::1%25en0]:8080/api/chat", "http://[fe80::1%25en0]:8080/v1/chat/completions"},
+		{"  http://host:11434  ", "http://host:11434/api/chat", "http://host:11434/v1/chat/completions"},
+		{"host:11434", "", ""},
+		{"localhost", "", ""},
+		{"ftp://host:11434", "", ""},
+		{"http://", "", ""},
+		{"http://host:11434/v1?key=1", "", ""},
+		{"http://host:11434/#top", "", ""},
+		{"http://[::1", "", ""},
+		{"http://host:port", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		for _, isOllama := range []bool{true, false} {
+			want := tt.compatible
+			if isOllama {
+				want = tt.ollama
+			}
+			got, err := buildLocalEndpoint(tt.baseURL, isOllama)
+			if want == "" {
+				if err == nil {
+					t.Errorf("buildLocalEndpoint(%q, %t) = %q, want an error", tt.baseURL, isOllama, got)
+				}
+				continue
+			}
+			if err != nil || got != want {
+				t.Errorf("buildLocalEndpoint(%q, %t) = %q, %v; want %q", tt.baseURL, isOllama, got, err, want)
+			}
+		}
+	}
+}
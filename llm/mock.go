@@ -0,0 +1,67 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// MockClient is a client that answers without a provider, for testing code that sends
+// requests. It replies with Response, or fails with Err when that's set. Streamed replies
+// arrive in Chunks, or in one piece when Chunks is empty. Usage is reported as the provider's
+// token counts; nil means the provider doesn't report them.
+type MockClient struct {
+	Response string
+	Chunks   []string
+	Err      error
+	Usage    *Usage
+
+	mu   sync.Mutex
+	sent [][]Message
+}
+
+// Sent returns the conversations the client was sent, oldest first
+func (c *MockClient) Sent() [][]Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]Message(nil), c.sent...)
+}
+
+func (c *MockClient) LastUsage() *Usage {
+	return c.Usage
+}
+
+func (c *MockClient) Complete(ctx context.Context, prompt string) (string, error) {
+	return c.CompleteConversation(ctx, UserPrompt(prompt), nil)
+}
+
+func (c *MockClient) CompleteStream(ctx context.Context, prompt string, onChunk func(chunk string)) (string, error) {
+	return c.CompleteConversation(ctx, UserPrompt(prompt), onChunk)
+}
+
+func (c *MockClient) CompleteConversation(ctx context.Context, messages []Message, onChunk func(chunk string)) (string, error) {
+	c.mu.Lock()
+	c.sent = append(c.sent, append([]Message(nil), messages...))
+	c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if c.Err != nil {
+		return "", c.Err
+	}
+
+	chunks := c.Chunks
+	if len(chunks) == 0 {
+		chunks = []string{c.Response}
+	}
+	if onChunk != nil {
+		for _, chunk := range chunks {
+			onChunk(chunk)
+		}
+	}
+	if c.Response == "" {
+		return strings.Join(chunks, ""), nil
+	}
+	return c.Response, nil
+}
@@ -0,0 +1,183 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// ListModels asks the provider which models are available to the configured credentials
+func ListModels(ctx context.Context, config ModelConfig, httpClient *http.Client) ([]string, error) {
+	var models []string
+
+	switch config.Provider {
+	case ProviderOpenAI:
+		opts := []option.RequestOption{option.WithAPIKey(config.APIKey), option.WithHTTPClient(httpClient)}
+		client := openai.NewClient(append(opts, openAIEndpointOptions(config)...)...)
+		page, err := client.Models.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, model := range page.Data {
+			models = append(models, model.ID)
+		}
+
+	case ProviderAnthropic:
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://api.anthropic.com/v1/models?limit=1000", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-api-key", config.APIKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Anthropic API returned %s", resp.Status)
+		}
+
+		var result struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("failed to parse model list: %v", err)
+		}
+		for _, model := range result.Data {
+			models = append(models, model.ID)
+		}
+
+	case ProviderLocal:
+		// OpenAI-compatible servers list their models at /v1/models
+		if config.LocalAPIStyle() == APIStyleOpenAI {
+			baseURL, err := openAICompatBaseURL(config.APIBaseURL)
+			if err != nil {
+				return nil, err
+			}
+			opts := []option.RequestOption{option.WithBaseURL(baseURL), option.WithHTTPClient(httpClient)}
+			client := openai.NewClient(append(opts, localCredentials(config.APIKey)...)...)
+			page, err := client.Models.List(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, model := range page.Data {
+				models = append(models, model.ID)
+			}
+			break
+		}
+
+		// Ollama lists its installed models at /api/tags
+		baseURL := strings.TrimSuffix(strings.TrimSpace(config.APIBaseURL), "/")
+		req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/api/tags", nil)
+		if err != nil {
+			return nil, err
+		}
+		if config.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+config.APIKey)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s/api/tags returned %s", baseURL, resp.Status)
+		}
+
+		var result struct {
+			Models []struct {
+				Name string `json:"name"`
+			} `json:"models"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("failed to parse Ollama model list: %v", err)
+		}
+		for _, model := range result.Models {
+			models = append(models, model.Name)
+		}
+
+	default:
+		return nil, fmt.Errorf("listing models isn't supported for provider: %s", config.Provider)
+	}
+
+	sort.Strings(models)
+	return models, nil
+}
+
+// Probe makes the cheapest request that shows whether config works and returns what it found.
+// Ollama is asked for its version and installed models; other servers are sent a one-token
+// completion, which checks the key and the model name together.
+func Probe(ctx context.Context, config ModelConfig, httpClient *http.Client) (string, error) {
+	if config.Provider == ProviderLocal && config.LocalAPIStyle() == APIStyleOllama {
+		version, err := ollamaVersion(ctx, config, httpClient)
+		if err != nil {
+			return "", err
+		}
+		models, err := ListModels(ctx, config, httpClient)
+		if err != nil {
+			return "", err
+		}
+		for _, name := range models {
+			if name == config.ModelName || name == config.ModelName+":latest" {
+				return fmt.Sprintf("Ollama %s is running and has %s", version, config.ModelName), nil
+			}
+		}
+		return "", fmt.Errorf("Ollama %s is running, but %s isn't installed (run 'ollama pull %s')",
+			version, config.ModelName, config.ModelName)
+	}
+
+	config.MaxTokens = 1
+	client, err := CreateLLMClient(config, httpClient)
+	if err != nil {
+		return "", err
+	}
+	if _, err := client.Complete(ctx, "Reply with OK."); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Connected: %s answered a test request", config.ModelName), nil
+}
+
+// ollamaVersion returns the version reported by the Ollama server in config
+func ollamaVersion(ctx context.Context, config ModelConfig, httpClient *http.Client) (string, error) {
+	baseURL := strings.TrimSuffix(strings.TrimSpace(config.APIBaseURL), "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/api/version", nil)
+	if err != nil {
+		return "", err
+	}
+	if config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+config.APIKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if isDialError(err) {
+			return "", fmt.Errorf("could not reach Ollama at %s. Is `ollama serve` running?", baseURL)
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s/api/version returned %s", baseURL, resp.Status)
+	}
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", fmt.Errorf("%s doesn't look like an Ollama server: %v", baseURL, err)
+	}
+	return version.Version, nil
+}
@@ -0,0 +1,201 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// OpenAIClient implements the LLMClient interface for OpenAI
+type OpenAIClient struct {
+	client *openai.Client
+	model  string
+	params GenerationParams
+	usage  *Usage // Reported with the last response, see LastUsage
+}
+
+// NewOpenAIClient creates an OpenAI client; extra options such as openAIEndpointOptions are applied last
+func NewOpenAIClient(apiKey, model string, params GenerationParams, httpClient *http.Client, extra ...option.RequestOption) *OpenAIClient {
+	// Retries are handled by withRetry, so the SDK's own retries are turned off
+	opts := []option.RequestOption{
+		option.WithAPIKey(apiKey),
+		option.WithMaxRetries(0),
+		option.WithHTTPClient(httpClient),
+	}
+	client := openai.NewClient(append(opts, extra...)...)
+
+	return &OpenAIClient{
+		client: client,
+		model:  model,
+		params: params,
+	}
+}
+
+// newParams builds the chat completion request, leaving unset settings out so the API defaults apply
+func (c *OpenAIClient) newParams(messages []Message) openai.ChatCompletionNewParams {
+	chat := openAIMessages(messages)
+	if c.params.SystemPrompt != "" {
+		chat = append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(c.params.SystemPrompt)}, chat...)
+	}
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(chat),
+		Model:    openai.F(c.model),
+	}
+
+	if c.params.MaxTokens > 0 {
+		params.MaxCompletionTokens = openai.F(int64(c.params.MaxTokens))
+	}
+	if c.params.Temperature != nil {
+		params.Temperature = openai.F(*c.params.Temperature)
+	}
+	if c.params.TopP != nil {
+		params.TopP = openai.F(*c.params.TopP)
+	}
+	if c.params.Seed != nil {
+		params.Seed = openai.F(*c.params.Seed)
+	}
+	if len(c.params.StopSequences) > 0 {
+		params.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(c.params.StopSequences))
+	}
+
+	return params
+}
+
+// openAIMessages converts a conversation to chat completion messages
+func openAIMessages(messages []Message) []openai.ChatCompletionMessageParamUnion {
+	params := make([]openai.ChatCompletionMessageParamUnion, 0, len(messages))
+	for _, message := range messages {
+		switch message.Role {
+		case "system":
+			params = append(params, openai.SystemMessage(message.Content))
+		case "assistant":
+			params = append(params, openai.AssistantMessage(message.Content))
+		default:
+			params = append(params, openai.UserMessage(message.Content))
+		}
+	}
+	return params
+}
+
+// describeError replaces errors that have a clear cause with a readable message
+func (c *OpenAIClient) describeError(err error) error {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.Code == "context_length_exceeded" {
+		return fmt.Errorf("the prompt is too long for %s's context window; shorten the answers or choose a model with a larger context window", c.model)
+	}
+	if errors.As(err, &apiErr) && isAuthStatus(apiErr.StatusCode) {
+		return newAuthError("OpenAI", c.model, apiErr.StatusCode, err)
+	}
+	return err
+}
+
+// LastUsage returns the token counts OpenAI reported with the last response
+func (c *OpenAIClient) LastUsage() *Usage {
+	return c.usage
+}
+
+// openAIUsage converts the usage OpenAI reports, which is missing (all zero) from streams
+// unless it's asked for
+func openAIUsage(usage openai.CompletionUsage) *Usage {
+	if usage.TotalTokens == 0 {
+		return nil
+	}
+	return &Usage{PromptTokens: int(usage.PromptTokens), CompletionTokens: int(usage.CompletionTokens)}
+}
+
+func (c *OpenAIClient) Complete(ctx context.Context, prompt string) (string, error) {
+	return c.CompleteConversation(ctx, UserPrompt(prompt), nil)
+}
+
+func (c *OpenAIClient) CompleteStream(ctx context.Context, prompt string, onChunk func(chunk string)) (string, error) {
+	return c.CompleteConversation(ctx, UserPrompt(prompt), onChunk)
+}
+
+func (c *OpenAIClient) CompleteConversation(ctx context.Context, messages []Message, onChunk func(chunk string)) (string, error) {
+	c.usage = nil
+	if onChunk != nil {
+		return c.streamConversation(ctx, messages, onChunk)
+	}
+
+	Logf("OpenAI: Sending request to model %s (%d messages)", c.model, len(messages))
+
+	params := c.newParams(messages)
+
+	Logf("OpenAI: Calling Chat Completions API")
+	chatCompletion, err := c.client.Chat.Completions.New(ctx, params)
+
+	if err != nil {
+		Logf("OpenAI ERROR: API request failed: %v", err)
+		return "", c.describeError(err)
+	}
+
+	Logf("OpenAI: Request successful, received %d choices", len(chatCompletion.Choices))
+	if len(chatCompletion.Choices) > 0 {
+		responseLength := len(chatCompletion.Choices[0].Message.Content)
+		Logf("OpenAI: Response length: %d characters", responseLength)
+	}
+	c.usage = openAIUsage(chatCompletion.Usage)
+
+	return chatCompletion.Choices[0].Message.Content, nil
+}
+
+func (c *OpenAIClient) streamConversation(ctx context.Context, messages []Message, onChunk func(chunk string)) (string, error) {
+	Logf("OpenAI: Streaming request to model %s (%d messages)", c.model, len(messages))
+
+	params := c.newParams(messages)
+	// The usage comes in a last chunk of its own
+	params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.F(true)})
+
+	stream := c.client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	var sb strings.Builder
+	var usage *Usage
+	chunks := 0
+	for stream.Next() {
+		chunk := stream.Current()
+		if chunkUsage := openAIUsage(chunk.Usage); chunkUsage != nil {
+			usage = chunkUsage
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		text := chunk.Choices[0].Delta.Content
+		sb.WriteString(text)
+		chunks++
+		onChunk(text)
+	}
+
+	if err := stream.Err(); err != nil {
+		Logf("OpenAI ERROR: Streaming request failed after %d chunks: %v", chunks, err)
+		return "", c.describeError(err)
+	}
+
+	Logf("OpenAI: Stream finished, received %d chunks, %d characters", chunks, sb.Len())
+	c.usage = usage
+	return sb.String(), nil
+}
+
+// openAIEndpointOptions returns the client options for an OpenAI entry's optional base URL (for
+// example an Azure OpenAI resource) and organization. Without them the SDK's defaults are used.
+func openAIEndpointOptions(config ModelConfig) []option.RequestOption {
+	var opts []option.RequestOption
+	if baseURL := strings.TrimSpace(config.APIBaseURL); baseURL != "" {
+		// The SDK resolves request paths against the base URL, so it must end in a slash
+		opts = append(opts, option.WithBaseURL(strings.TrimSuffix(baseURL, "/")+"/"))
+		if strings.Contains(baseURL, ".openai.azure.com") && config.APIKey != "" {
+			// Azure OpenAI takes the key in its own header
+			opts = append(opts, option.WithHeader("api-key", config.APIKey))
+		}
+	}
+	if orgID := strings.TrimSpace(config.OrgID); orgID != "" {
+		opts = append(opts, option.WithOrganization(orgID))
+	}
+	return opts
+}
@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// chatCompletion is a non-streamed chat completion response with content as the answer
+func chatCompletion(content string) string {
+	return fmt.Sprintf(`{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"test","choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, content)
+}
+
+// writeChatStream writes chunks as the server-sent events of a streamed chat completion
+func writeChatStream(w http.ResponseWriter, chunks ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, chunk := range chunks {
+		fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"created\":1,\"model\":\"test\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", chunk)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func TestOpenAIClient(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotRequest struct {
+		Model    string `json:"model"`
+		Stream   bool   `json:"stream"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"` // A string, or a list of parts
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&gotRequest)
+		if gotRequest.Stream {
+			writeChatStream(w, "Hel", "lo")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(chatCompletion("Hello")))
+	}))
+	defer server.Close()
+
+	config := ModelConfig{Provider: ProviderOpenAI, ModelName: "gpt-4o", APIKey: "sk-test-0123456789abcdef", APIBaseURL: server.URL + "/v1", SystemPrompt: "Be brief."}
+	client, err := CreateLLMClient(config, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	openAI := client.(*OpenAIClient)
+
+	response, err := openAI.Complete(context.Background(), "Summarize this")
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if response != "Hello" {
+		t.Errorf("Complete() = %q, want %q", response, "Hello")
+	}
+	if gotPath != "/v1/chat/completions" {
+		t.Errorf("request went to %s, want /v1/chat/completions", gotPath)
+	}
+	if gotAuth != "Bearer "+config.APIKey {
+		t.Errorf("Authorization = %q, want the API key as a bearer token", gotAuth)
+	}
+	if gotRequest.Model != "gpt-4o" || len(gotRequest.Messages) != 2 || gotRequest.Messages[0].Role != "system" || !strings.Contains(string(gotRequest.Messages[1].Content), "Summarize this") {
+		t.Errorf("request = %+v, want the system prompt followed by the prompt for gpt-4o", gotRequest)
+	}
+
+	var chunks []string
+	response, err = openAI.CompleteStream(context.Background(), "Summarize this", func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("CompleteStream() error = %v", err)
+	}
+	if response != "Hello" || strings.Join(chunks, "|") != "Hel|lo" {
+		t.Errorf("CompleteStream() = %q with chunks %q, want %q in two chunks", response, chunks, "Hello")
+	}
+}
+
+func TestOpenAIClientErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		wantAuth      bool
+		wantRetryable bool
+	}{
+		{"rejected key", http.StatusUnauthorized, true, false},
+		{"forbidden model", http.StatusForbidden, true, false},
+		{"rate limited", http.StatusTooManyRequests, false, true},
+		{"overloaded", http.StatusServiceUnavailable, false, true},
+		{"bad request", http.StatusBadRequest, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"error":{"message":"nope","type":"test"}}`))
+			}))
+			defer server.Close()
+
+			client := NewOpenAIClient("sk-test", "gpt-4o", GenerationParams{}, server.Client(), openAIEndpointOptions(ModelConfig{APIBaseURL: server.URL})...)
+			_, err := client.Complete(context.Background(), "Summarize this")
+			if err == nil {
+				t.Fatal("Complete() succeeded, want an error")
+			}
+			var authErr *AuthError
+			if got := errors.As(err, &authErr); got != tt.wantAuth {
+				t.Errorf("errors.As(%v, *AuthError) = %t, want %t", err, got, tt.wantAuth)
+			}
+			if got, _ := IsRetryable(err); got != tt.wantRetryable {
+				t.Errorf("IsRetryable(%v) = %t, want %t", err, got, tt.wantRetryable)
+			}
+		})
+	}
+}
+
+func TestOpenAIClientUsage(t *testing.T) {
+	var includeUsage bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Stream        bool `json:"stream"`
+			StreamOptions struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Stream {
+			includeUsage = request.StreamOptions.IncludeUsage
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"created\":1,\"model\":\"test\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"created\":1,\"model\":\"test\",\"choices\":[],\"usage\":{\"prompt_tokens\":20,\"completion_tokens\":3,\"total_tokens\":23}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"test","choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":2,"total_tokens":14}}`)
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient("sk-test", "gpt-4o", GenerationParams{}, server.Client(), openAIEndpointOptions(ModelConfig{APIBaseURL: server.URL})...)
+	if _, err := client.Complete(context.Background(), "Summarize this"); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if got := client.LastUsage(); got == nil || *got != (Usage{PromptTokens: 12, CompletionTokens: 2}) {
+		t.Errorf("LastUsage() after Complete() = %+v, want 12 prompt and 2 completion tokens", got)
+	}
+
+	if _, err := client.CompleteStream(context.Background(), "Summarize this", func(string) {}); err != nil {
+		t.Fatalf("CompleteStream() error = %v", err)
+	}
+	if !includeUsage {
+		t.Error("the streamed request didn't ask for usage")
+	}
+	if got := client.LastUsage(); got == nil || *got != (Usage{PromptTokens: 20, CompletionTokens: 3}) {
+		t.Errorf("LastUsage() after CompleteStream() = %+v, want 20 prompt and 3 completion tokens", got)
+	}
+}
@@ -3,30 +3,51 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/acarl005/stripansi"
 	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
-	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/glamour/styles"
 	"github.com/charmbracelet/lipgloss"
-	anthropic "github.com/liushuangls/go-anthropic"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
+	xansi "github.com/charmbracelet/x/ansi"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/term"
+
+	"ticketduck/llm"
 )
 
 // ---[ DEBUG: Logging ]-------------------------------------------------------
@@ -41,19 +62,21 @@ var (
 	logFile *os.File
 )
 
-func setupLogging() error {
+func setupLogging(configDir string) error {
 	// Create logs directory if it doesn't exist
-	logsDir := filepath.Join(getConfigDir(), "logs")
-	if err := os.MkdirAll(logsDir, 0755); err != nil {
+	logsDir := filepath.Join(configDir, "logs")
+	if err := os.MkdirAll(logsDir, 0700); err != nil {
 		return fmt.Errorf("failed to create logs directory: %v", err)
 	}
+	// Directories made by older versions were readable by everyone
+	logsWidened, logsErr := restrictPermissions(logsDir, 0700)
 
 	// Create log file with timestamp
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
 	logFilePath := filepath.Join(logsDir, fmt.Sprintf("ticketduck_%s.log", timestamp))
 
 	var err error
-	logFile, err = os.Create(logFilePath)
+	logFile, err = os.OpenFile(logFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to create log file: %v", err)
 	}
@@ -61,10 +84,34 @@ func setupLogging() error {
 	// Configure the logger
 	logger = log.New(logFile, "", log.LstdFlags)
 	logger.Printf("Logging initialized at %s", timestamp)
+	if logsErr != nil {
+		warnf("%s is readable by other users and its permissions could not be changed: %v", logsDir, logsErr)
+	} else if logsWidened != 0 {
+		warnf("%s was readable by other users (%v); its permissions have been changed to %v", logsDir, logsWidened, os.FileMode(0700))
+	}
 
 	return nil
 }
 
+// restrictPermissions removes any access beyond perm from path, because the config and logs can
+// hold API keys. It returns the previous permissions if they had to be changed, or 0.
+func restrictPermissions(path string, perm os.FileMode) (os.FileMode, error) {
+	if runtime.GOOS == "windows" {
+		return 0, nil // Unix permission bits don't apply
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.Mode().Perm()&^perm == 0 {
+		return 0, nil
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		return 0, err
+	}
+	return info.Mode().Perm(), nil
+}
+
 // closeLogging properly closes the log file
 func closeLogging() {
 	if logFile != nil {
@@ -80,6 +127,43 @@ func logf(format string, v ...interface{}) {
 	}
 }
 
+// panicError logs a recovered panic with its stack trace and turns it into an error to show
+func panicError(where string, recovered interface{}) error {
+	logf("PANIC in %s: %v\n%s", where, recovered, debug.Stack())
+	return fmt.Errorf("internal error in %s: %v (details are in the log)", where, recovered)
+}
+
+var (
+	// tuiStarted is set once the TUI owns the terminal, after which warnf must not print
+	tuiStarted bool
+
+	warningsMu      sync.Mutex
+	pendingWarnings []string // Warnings from after tuiStarted, see takeWarnings
+)
+
+// warnf logs a warning and also prints it to stderr, for problems the user should fix.
+// Once the TUI is running the warning is kept for takeWarnings instead, since writing to
+// stderr would tear through the screen.
+func warnf(format string, args ...interface{}) {
+	logf("WARNING: "+format, args...)
+	if !tuiStarted {
+		fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+		return
+	}
+	warningsMu.Lock()
+	pendingWarnings = append(pendingWarnings, fmt.Sprintf(format, args...))
+	warningsMu.Unlock()
+}
+
+// takeWarnings returns the warnings raised since the TUI started and forgets them
+func takeWarnings() []string {
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	warnings := pendingWarnings
+	pendingWarnings = nil
+	return warnings
+}
+
 // ---[ Configuration ]-------------------------------------------------------
 //
 // This section defines the configuration for the application.
@@ -98,54 +182,407 @@ const (
 	apiKeyInputMode
 	modelSelectMode
 	styleSelectMode
+	reviewMode
+	historyMode
+	compareMode
+	welcomeMode
+	errorMode
 )
 
-// ModelProvider represents the different AI providers supported by the application
-type ModelProvider string
+// keyHelp describes a single key binding for the help overlay
+type keyHelp struct {
+	key  string
+	desc string
+}
 
-const (
-	ProviderOpenAI    ModelProvider = "openai"
-	ProviderAnthropic ModelProvider = "claude"
-	ProviderLocal     ModelProvider = "local"
-)
+// globalKeyHelp lists the bindings that work in every mode
+var globalKeyHelp = []keyHelp{
+	{"ctrl+q, ctrl+c", "quit"},
+	{"esc", "return to main menu (never quits)"},
+	{"~", "select model (not while typing)"},
+	{"ctrl+t", "select style (not while typing)"},
+	{"?", "toggle this help (F1 while typing an answer or API key)"},
+}
+
+// modeKeyHelp lists the bindings for each mode, shown by the help overlay. An entry's key is
+// either a keymap action, shown with the keys bound to it, or a fixed key.
+// Add to it alongside any new binding so the overlay stays in sync.
+var modeKeyHelp = map[mode][]keyHelp{
+	selectionMode: {
+		{string(actionMoveUp), "move up through form types"},
+		{string(actionMoveDown), "move down through form types"},
+		{"enter, space", "select a form type"},
+		{"1-9", "start the numbered form type"},
+		{string(actionFilter), "filter form types by name (esc clears)"},
+		{string(actionEditPrompt), "edit the prompt of the selected form"},
+		{string(actionImport), "import answers for the selected form from a file"},
+		{string(actionHistory), "browse past summaries"},
+	},
+	questionMode: {
+		{"enter", "insert a new line"},
+		{"ctrl+d", "submit answer"},
+		{"ctrl+s", "skip question"},
+		{"ctrl+b, shift+tab", "go back to the previous question"},
+		{"ctrl+w", "delete the previous word"},
+		{"ctrl+u", "clear the current line"},
+		{"ctrl+v", "paste from the clipboard"},
+		{"ctrl+g", "go to a question by number"},
+	},
+	reviewMode: {
+		{string(actionMoveUp), "move up through questions"},
+		{string(actionMoveDown), "move down through questions"},
+		{"enter", "edit the selected answer"},
+		{string(actionSend), "send the answers to the model"},
+		{string(actionCycleLength), "cycle summary length (normal, brief, detailed)"},
+		{string(actionCycleTone), "cycle summary tone (neutral, formal, casual)"},
+		{string(actionPreview), "preview the prompt without sending it"},
+		{string(actionCompare), "compare the active model with another one"},
+	},
+	apiKeyInputMode: {
+		{"tab/shift+tab, ↑/↓", "next/previous field"},
+		{"←/→", "choose from the model list"},
+		{"ctrl+r", "show/hide the API key"},
+		{"ctrl+t", "test the connection with these settings"},
+		{"space", "toggle save configuration"},
+		{"enter", "save and return to menu"},
+	},
+	modelSelectMode: {
+		{string(actionMoveUp), "move up through models"},
+		{string(actionMoveDown), "move down through models"},
+		{"enter, space", "select a model"},
+		{"1-9", "select the numbered model"},
+		{string(actionFilter), "filter models by name (esc clears)"},
+		{string(actionConfigure), "configure the selected model"},
+		{string(actionAddProvider), "add a provider"},
+		{string(actionDelete), "delete the selected custom provider"},
+		{string(actionNextProfile), "switch to the next profile"},
+	},
+	styleSelectMode: {
+		{string(actionMoveUp), "move up through themes"},
+		{string(actionMoveDown), "move down through themes"},
+		{"enter", "apply the selected theme"},
+	},
+	compareMode: {
+		{string(actionSwitchPane), "switch between the two panes"},
+		{"↑/↓, j/k", "scroll the focused pane"},
+		{string(actionCopy), "copy the focused pane"},
+		{string(actionCopyFormat), "cycle copy format (Markdown, Jira, Slack)"},
+	},
+	historyMode: {
+		{string(actionMoveUp), "move up through past summaries"},
+		{string(actionMoveDown), "move down through past summaries"},
+		{"enter", "open the selected summary"},
+		{string(actionDelete), "delete the selected summary"},
+	},
+	welcomeMode: {
+		{"enter", "start setup"},
+		{string(actionSkipSetup), "skip setup"},
+	},
+	errorMode: {
+		{string(actionRetry), "send the answers again"},
+		{string(actionFixSettings), "update the model's settings after its API key was rejected"},
+		{"~", "choose another model, then retry to send to it"},
+		{"esc", "return to the main menu"},
+	},
+	displayMode: displayKeyActions,
+}
+
+// name returns the label used for the mode in the status bar and help overlay
+func (md mode) name() string {
+	switch md {
+	case selectionMode:
+		return "Selection"
+	case questionMode:
+		return "Question"
+	case displayMode:
+		return "Display"
+	case apiKeyInputMode:
+		return "API Config"
+	case modelSelectMode:
+		return "Model Select"
+	case styleSelectMode:
+		return "Style Select"
+	case reviewMode:
+		return "Review"
+	case historyMode:
+		return "History"
+	case compareMode:
+		return "Compare"
+	case welcomeMode:
+		return "Welcome"
+	case errorMode:
+		return "Error"
+	}
+	return ""
+}
+
+// knownModel describes a model: its context window in tokens and its approximate list
+// price in US dollars per million input and output tokens (0 when there's no public price)
+type knownModel struct {
+	prefix      string
+	tokens      int
+	inputPrice  float64
+	outputPrice float64
+}
+
+// Known models by model name prefix. More specific prefixes come first.
+var knownModels = []knownModel{
+	{"gpt-4o-mini", 128000, 0.15, 0.60},
+	{"gpt-4o", 128000, 2.50, 10},
+	{"gpt-4-turbo", 128000, 10, 30},
+	{"gpt-4-32k", 32768, 60, 120},
+	{"gpt-4", 8192, 30, 60},
+	{"gpt-3.5-turbo", 16385, 0.50, 1.50},
+	{"o1-mini", 128000, 1.10, 4.40},
+	{"o1", 200000, 15, 60},
+	{"o3-mini", 200000, 1.10, 4.40},
+	{"o3", 200000, 10, 40},
+	{"claude-3-haiku", 200000, 0.25, 1.25},
+	{"claude-3-5-haiku", 200000, 0.80, 4},
+	{"claude-3-opus", 200000, 15, 75},
+	{"claude-opus", 200000, 15, 75},
+	{"claude-3-sonnet", 200000, 3, 15},
+	{"claude-3-5-sonnet", 200000, 3, 15},
+	{"claude-3-7-sonnet", 200000, 3, 15},
+	{"claude-sonnet", 200000, 3, 15},
+	{"claude-", 200000, 0, 0},
+	{"llama3.1", 128000, 0, 0},
+	{"llama3.2", 128000, 0, 0},
+	{"llama3", 8192, 0, 0},
+	{"mistral", 32768, 0, 0},
+}
+
+// modelInfo returns what's known about the model, and false if its name isn't in knownModels
+func modelInfo(c llm.ModelConfig) (knownModel, bool) {
+	for _, info := range knownModels {
+		if strings.HasPrefix(c.ModelName, info.prefix) {
+			return info, true
+		}
+	}
+	return knownModel{}, false
+}
+
+// contextWindow returns the model's context window in tokens, or 0 if it isn't known
+func contextWindow(c llm.ModelConfig) int {
+	if c.ContextWindow > 0 {
+		return c.ContextWindow
+	}
+	info, _ := modelInfo(c)
+	return info.tokens
+}
 
-// ModelConfig holds configuration for a specific AI model
-type ModelConfig struct {
-	Provider   ModelProvider `json:"provider"`
-	ModelName  string        `json:"model_name"`
-	APIKey     string        `json:"api_key,omitempty"`
-	APIBaseURL string        `json:"api_base_url,omitempty"` // For local models or custom endpoints
+// modelSummary describes the model's context window and price for the model select screen
+func modelSummary(c llm.ModelConfig) string {
+	context := "context unknown"
+	if window := contextWindow(c); window > 0 {
+		context = fmt.Sprintf("%dK context", window/1000)
+	}
+
+	price := "price unknown"
+	if c.Provider == llm.ProviderLocal {
+		price = "runs locally"
+	} else if info, ok := modelInfo(c); ok && info.inputPrice > 0 {
+		price = fmt.Sprintf("~$%.2f in / $%.2f out per 1M tokens", info.inputPrice, info.outputPrice)
+	}
+	return context + " • " + price
+}
+
+// estimateTokens roughly estimates the token count of text, at about four characters per token
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// contextWindowWarning returns a warning if the prompt is likely too long for the model, or "" if
+// it fits (or the window isn't known)
+func contextWindowWarning(modelConfig llm.ModelConfig, prompt string) string {
+	window := contextWindow(modelConfig)
+	tokens := estimateTokens(prompt)
+	if window == 0 || tokens <= window {
+		return ""
+	}
+	return fmt.Sprintf("The prompt is roughly %d tokens, more than the %d-token context window of %s. It may be truncated or rejected.",
+		tokens, window, modelConfig.ModelName)
 }
 
 // Config holds all application configuration
 type Config struct {
-	ActiveModel string                 `json:"active_model"`
-	Models      map[string]ModelConfig `json:"models"`
+	Version     int                        `json:"version"` // Schema version, see configMigrations
+	ActiveModel string                     `json:"active_model"`
+	ActiveTheme string                     `json:"active_theme,omitempty"`
+	Themes      []StyleTheme               `json:"themes,omitempty"` // Custom themes added to the built-in ones
+	Models      map[string]llm.ModelConfig `json:"models"`
+
+	// How long a single request attempt may take before it's abandoned
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	// How long form responses are kept in the response cache; 0 turns the cache off
+	CacheTTLMinutes int `json:"cache_ttl_minutes,omitempty"`
+
+	// Show a desktop notification when a summary finishes generating
+	NotifyOnComplete bool `json:"notify_on_complete,omitempty"`
+
+	// Ring the terminal bell when a summary finishes generating: once on success, three times on error
+	BellOnComplete bool `json:"bell_on_complete,omitempty"`
+
+	// List the questions and answers in the front matter of saved summaries
+	FrontMatterAnswers bool `json:"front_matter_answers,omitempty"`
+
+	// Show summaries without wrapping long lines; they scroll sideways instead. Toggled with w.
+	NoWrap bool `json:"no_wrap,omitempty"`
+
+	// Column summaries are wrapped at, if narrower than the window; 0 wraps at the window's width
+	WrapWidth int `json:"wrap_width,omitempty"`
+
+	// Animation shown while waiting for a response, one of spinnerStyles; "none" turns it off
+	Spinner string `json:"spinner,omitempty"`
+
+	// Whether links in summaries are OSC 8 hyperlinks: "on", "off", or "auto" for terminals
+	// known to support them, see Config.hyperlinks
+	Hyperlinks string `json:"hyperlinks,omitempty"`
+
+	// Keys for the actions on every screen: a preset ("default" or "vim", see keyPresets) and
+	// keys for single actions, e.g. {"copy": ["y"]}, that replace the preset's. See newKeymap.
+	KeyPreset string              `json:"key_preset,omitempty"`
+	Keys      map[string][]string `json:"keys,omitempty"`
+
+	// Slack incoming webhook that summaries are posted to; encrypted like the API keys, and
+	// overridden by TICKETDUCK_SLACK_WEBHOOK_URL, see slackWebhookURL
+	SlackWebhookURL string `json:"slack_webhook_url,omitempty"`
+
+	// Where issues are created from summaries: a token (encrypted like the API keys, and
+	// overridden by TICKETDUCK_GITHUB_TOKEN), the owner/name repository, and the API base URL
+	// for GitHub Enterprise. See the GitHub section.
+	GitHubToken  string `json:"github_token,omitempty"`
+	GitHubRepo   string `json:"github_repo,omitempty"`
+	GitHubAPIURL string `json:"github_api_url,omitempty"`
+
+	// Jira site summaries are posted to as new issues or comments. The API token is encrypted
+	// like the API keys and overridden by TICKETDUCK_JIRA_TOKEN; see the Jira section.
+	JiraURL       string `json:"jira_url,omitempty"`
+	JiraEmail     string `json:"jira_email,omitempty"` // Left out for Jira Server/Data Center personal access tokens
+	JiraToken     string `json:"jira_token,omitempty"`
+	JiraProject   string `json:"jira_project,omitempty"`    // Key of the project new issues go to, e.g. PROJ
+	JiraIssueType string `json:"jira_issue_type,omitempty"` // Defaults to Task
+
+	// Models a summary request moves on to, in order, when the provider is unavailable: it
+	// can't be reached, is overloaded or failing, or times out. See modelChain.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+
+	// Proxy for all provider requests; when unset HTTPS_PROXY/HTTP_PROXY/NO_PROXY are used
+	ProxyURL string `json:"proxy_url,omitempty"`
+
+	// Retries for transient API errors (rate limits, server errors, timeouts)
+	MaxRetries       *int `json:"max_retries,omitempty"`
+	RetryBaseDelayMs int  `json:"retry_base_delay_ms,omitempty"`
+
+	// Prompts edited in the TUI, keyed by form name; they replace the form's built-in prompt
+	PromptOverrides map[string]string `json:"prompt_overrides,omitempty"`
+
+	// Length and tone last chosen on the review screen, see lengthDirectives and toneDirectives
+	Length string `json:"length,omitempty"`
+	Tone   string `json:"tone,omitempty"`
+
+	dir       string // Directory the config was loaded from and is saved to, see getConfigDir
+	profile   string // Named profile the config belongs to, "" for the default; see configFileName
+	secretKey []byte // Key API keys are encrypted with, when Encryption is set
+
+	// Set once first-run setup has been completed or skipped
+	Onboarded bool `json:"onboarded,omitempty"`
+
+	// Set when API keys are stored encrypted with a passphrase, see enableKeyEncryption
+	Encryption *keyEncryption `json:"encryption,omitempty"`
+}
+
+// Choices for the length and tone of the summary, in the order the review screen cycles through them.
+// The first of each is the default and adds nothing to the prompt.
+var (
+	lengthOptions = []string{"normal", "brief", "detailed"}
+	toneOptions   = []string{"neutral", "formal", "casual"}
+)
+
+// lengthDirectives and toneDirectives hold the sentence appended to the prompt for each choice
+var lengthDirectives = map[string]string{
+	"brief":    "Keep the summary brief: no more than two or three sentences.",
+	"detailed": "Write a detailed summary of several paragraphs that covers every answer.",
+}
+
+var toneDirectives = map[string]string{
+	"formal": "Use a formal, professional tone.",
+	"casual": "Use a casual, conversational tone.",
+}
+
+// nextOption returns the option after current in options, wrapping around.
+// An unknown current value counts as the first option.
+func nextOption(options []string, current string) string {
+	return options[(indexOf(options, current)+1)%len(options)]
+}
+
+// optionOrDefault returns value if it is one of options, otherwise the first option
+func optionOrDefault(options []string, value string) string {
+	for _, option := range options {
+		if option == value {
+			return value
+		}
+	}
+	return options[0]
+}
+
+// requestPrompt returns the prompt sent with a form: its prompt (or override) followed by
+// the directives for the chosen length and tone.
+func (c Config) requestPrompt(form formType) string {
+	var directives []string
+	for _, directive := range []string{lengthDirectives[c.Length], toneDirectives[c.Tone]} {
+		if directive != "" {
+			directives = append(directives, directive)
+		}
+	}
+	if len(directives) == 0 {
+		return c.formPrompt(form)
+	}
+	return c.formPrompt(form) + "\n\n" + strings.Join(directives, " ")
+}
+
+// formPrompt returns the prompt to send with a form: the user's override if there is one,
+// otherwise the form's own prompt.
+func (c Config) formPrompt(form formType) string {
+	if prompt := c.PromptOverrides[form.name]; strings.TrimSpace(prompt) != "" {
+		return prompt
+	}
+	return form.prompt
 }
 
 // This provides presets for common providers of pre-trained models, but you could certainly add more
 // The local models (e.g., Mistral, Llama) should probably be modified to suit your hosting situation,
 // which you'll be able to configure at runtime.
 
-var DefaultModelConfigs = map[string]ModelConfig{
+var DefaultModelConfigs = map[string]llm.ModelConfig{
 	"openai": {
-		Provider:  ProviderOpenAI,
+		Provider:  llm.ProviderOpenAI,
 		ModelName: "gpt-3.5-turbo", // Default model, can be changed
 	},
 	"anthropic": {
-		Provider:  ProviderAnthropic,
+		Provider:  llm.ProviderAnthropic,
 		ModelName: "claude-3-sonnet-20240229", // Default model, can be changed
 	},
 	"ollama": {
-		Provider:   ProviderLocal,
+		Provider:   llm.ProviderLocal,
 		ModelName:  "llama3", // Default model, can be changed
 		APIBaseURL: "http://localhost:11434",
+		APIStyle:   llm.APIStyleOllama,
 	},
 }
 
-// getConfigDir returns the directory for storing configuration
-func getConfigDir() string {
-	// First try to use the XDG_CONFIG_HOME environment variable
+// getConfigDir returns the directory for storing configuration. An override (from --config-dir)
+// wins, then TICKETDUCK_CONFIG_DIR, then XDG_CONFIG_HOME, then the home directory.
+func getConfigDir(override string) string {
+	if override != "" {
+		return override
+	}
+	if dir := os.Getenv("TICKETDUCK_CONFIG_DIR"); dir != "" {
+		return dir
+	}
+
+	// Then try to use the XDG_CONFIG_HOME environment variable
 	configDir := os.Getenv("XDG_CONFIG_HOME")
 	if configDir != "" {
 		return filepath.Join(configDir, "ticketduck")
@@ -161,15 +598,100 @@ func getConfigDir() string {
 	return filepath.Join(homeDir, ".ticketduck")
 }
 
-// saveConfig saves the configuration to the config file
+// configFileName returns the name of a profile's config file: config.json for the default
+// profile and config.<profile>.json for named ones
+func configFileName(profile string) string {
+	if profile == "" {
+		return "config.json"
+	}
+	return "config." + profile + ".json"
+}
+
+// validProfileName reports whether a profile name can be used in a file name
+func validProfileName(profile string) bool {
+	return profile != "" && !strings.ContainsAny(profile, `/\.`) && strings.TrimSpace(profile) == profile
+}
+
+// listProfiles returns the profiles with a config file in configDir, the default ("") first
+func listProfiles(configDir string) []string {
+	profiles := []string{""}
+	matches, _ := filepath.Glob(filepath.Join(configDir, "config.*.json"))
+	for _, match := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), "config."), ".json")
+		if validProfileName(name) {
+			profiles = append(profiles, name)
+		}
+	}
+	sort.Strings(profiles[1:])
+	return profiles
+}
+
+// profileLabel returns the name shown for a profile
+func profileLabel(profile string) string {
+	if profile == "" {
+		return "default"
+	}
+	return profile
+}
+
+// configFileExists reports whether the profile's config file has been written to configDir yet
+func configFileExists(configDir, profile string) bool {
+	_, err := os.Stat(filepath.Join(configDir, configFileName(profile)))
+	return err == nil
+}
+
+// saveConfig saves the configuration to its profile's file in the directory it was loaded from
 func saveConfig(config Config) error {
-	configDir := getConfigDir()
+	configDir := config.dir
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		return fmt.Errorf("failed to create config directory: %v", err)
 	}
 
-	configFile := filepath.Join(configDir, "config.json")
-	data, err := json.MarshalIndent(config, "", "  ")
+	configFile := filepath.Join(configDir, configFileName(config.profile))
+	if config.Encryption == nil && fileHasEncryptedKeys(configFile) {
+		// The config couldn't be unlocked; writing it now would lose the encrypted keys
+		return fmt.Errorf("not overwriting %s: its API keys are encrypted and it wasn't unlocked", configFile)
+	}
+	if config.Encryption != nil && config.secretKey == nil {
+		return fmt.Errorf("not saving %s: its API keys are encrypted and it wasn't unlocked", configFile)
+	}
+
+	// Saving drops the settings a newer version added, so keep its file as it was
+	if err := backupNewerConfig(configFile); err != nil {
+		return err
+	}
+
+	// Never persist API keys that were picked up from the environment
+	persisted := config
+	persisted.Models = make(map[string]llm.ModelConfig, len(config.Models))
+	persisted.Version = currentConfigVersion
+	for k, v := range config.Models {
+		if v.APIKeyFromEnv {
+			v.APIKey = ""
+		}
+		if config.secretKey != nil && v.APIKey != "" {
+			sealed, err := sealSecret(config.secretKey, v.APIKey)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt API key for %s: %v", k, err)
+			}
+			v.APIKey = sealed
+		}
+		persisted.Models[k] = v
+	}
+	if config.secretKey != nil {
+		for _, secret := range persisted.secrets() {
+			if *secret.value == "" {
+				continue
+			}
+			sealed, err := sealSecret(config.secretKey, *secret.value)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s: %v", secret.name, err)
+			}
+			*secret.value = sealed
+		}
+	}
+
+	data, err := json.MarshalIndent(persisted, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
@@ -181,11 +703,13 @@ func saveConfig(config Config) error {
 	return nil
 }
 
-// loadConfig loads the configuration from the config file
-func loadConfig() (Config, error) {
+// loadConfig loads the configuration for a profile ("" for the default) from configDir
+func loadConfig(configDir, profile string) (Config, error) {
 	config := Config{
 		ActiveModel: "", // No default model selected
-		Models:      make(map[string]ModelConfig),
+		Models:      make(map[string]llm.ModelConfig),
+		dir:         configDir,
+		profile:     profile,
 	}
 
 	// Copy default model configs to the config
@@ -193,14 +717,21 @@ func loadConfig() (Config, error) {
 		config.Models[k] = v
 	}
 
-	configDir := getConfigDir()
-	configFile := filepath.Join(configDir, "config.json")
+	configFile := filepath.Join(configDir, configFileName(profile))
 
 	// Check if config file exists
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		applyEnvAPIKeys(&config)
 		return config, nil // Return default config if file doesn't exist
 	}
 
+	// A config from an older version or copied in may be readable by others, and it holds API keys
+	if widened, err := restrictPermissions(configFile, 0600); err != nil {
+		warnf("%s is readable by other users and its permissions could not be changed: %v", configFile, err)
+	} else if widened != 0 {
+		warnf("%s was readable by other users (%v); its permissions have been changed to %v", configFile, widened, os.FileMode(0600))
+	}
+
 	data, err := ioutil.ReadFile(configFile)
 	if err != nil {
 		return config, fmt.Errorf("failed to read config file: %v", err)
@@ -210,6 +741,21 @@ func loadConfig() (Config, error) {
 		return config, fmt.Errorf("failed to parse config file: %v", err)
 	}
 
+	if config.Encryption != nil {
+		if err := unlockConfig(&config); err != nil {
+			return config, err
+		}
+	}
+
+	if config.Version > currentConfigVersion {
+		warnf("%s is version %d, newer than this build supports (%d); settings it doesn't know are ignored, and the file is backed up to %s.bak before it's first saved",
+			configFile, config.Version, currentConfigVersion, configFileName(profile))
+	} else if config.Version < currentConfigVersion {
+		if err := migrateConfig(&config, configFile, data); err != nil {
+			logf("WARNING: failed to migrate config file: %v", err)
+		}
+	}
+
 	// Ensure all default models exist in the config
 	for k, v := range DefaultModelConfigs {
 		if _, exists := config.Models[k]; !exists {
@@ -217,1766 +763,8337 @@ func loadConfig() (Config, error) {
 		}
 	}
 
+	// A hand-edited config can name a model that isn't in it; it has to be chosen again
+	if _, ok := config.activeModelConfig(); !ok && config.ActiveModel != "" {
+		logf("WARNING: active model %q is not in the config, clearing it", config.ActiveModel)
+		config.ActiveModel = ""
+	}
+
+	applyEnvAPIKeys(&config)
+
 	return config, nil
 }
 
-// ---[ Lip Gloss Styles ]-----------------------------------------------------
+// ConfigStore is where the TUI loads its Config from and saves it to
+type ConfigStore interface {
+	Load() (Config, error)
+	Save(Config) error
+}
 
-// StyleTheme represents a predefined style theme
-type StyleTheme struct {
-	Name    string
-	Base    lipgloss.AdaptiveColor
-	Accent  lipgloss.AdaptiveColor
-	Error   lipgloss.AdaptiveColor
-	Success lipgloss.AdaptiveColor
+// fileConfigStore keeps a profile's config in its file in dir, see loadConfig and saveConfig
+type fileConfigStore struct {
+	dir     string
+	profile string
 }
 
-// Available style themes
-var styleThemes = []StyleTheme{
-	{
-		Name:    "Normal",
-		Base:    lipgloss.AdaptiveColor{Light: "#a5b342", Dark: "#a5b342"},
-		Accent:  lipgloss.AdaptiveColor{Light: "#FFFAFF", Dark: "#808080"},
-		Error:   lipgloss.AdaptiveColor{Light: "#FFFAFF", Dark: "#808080"},
-		Success: lipgloss.AdaptiveColor{Light: "#FFFAFF", Dark: "#808080"},
-	},
-	{
-		Name:    "Forest",
-		Base:    lipgloss.AdaptiveColor{Light: "#04B575", Dark: "#25cc6a"},
-		Accent:  lipgloss.AdaptiveColor{Light: "#7D56F4", Dark: "#7D56F4"},
-		Error:   lipgloss.AdaptiveColor{Light: "#FF5F87", Dark: "#FF5F87"},
-		Success: lipgloss.AdaptiveColor{Light: "#02BA84", Dark: "#02BF87"},
-	},
-	{
-		Name:    "Ocean",
-		Base:    lipgloss.AdaptiveColor{Light: "#5A56E0", Dark: "#0c8ffa"},
-		Accent:  lipgloss.AdaptiveColor{Light: "#00B4D8", Dark: "#00B4D8"},
-		Error:   lipgloss.AdaptiveColor{Light: "#FF6B6B", Dark: "#FF6B6B"},
-		Success: lipgloss.AdaptiveColor{Light: "#4ECDC4", Dark: "#4ECDC4"},
-	},
-	{
-		Name:    "Sunset",
-		Base:    lipgloss.AdaptiveColor{Light: "#FF6B6B", Dark: "#FF6B6B"},
-		Accent:  lipgloss.AdaptiveColor{Light: "#FFD166", Dark: "#FFD166"},
-		Error:   lipgloss.AdaptiveColor{Light: "#EF476F", Dark: "#EF476F"},
-		Success: lipgloss.AdaptiveColor{Light: "#06D6A0", Dark: "#06D6A0"},
-	},
+func (s fileConfigStore) Load() (Config, error) {
+	return loadConfig(s.dir, s.profile)
 }
 
-// Styles defines the styling for the application
-type Styles struct {
-	Base,
-	HeaderText,
-	Status,
-	StatusHeader,
-	Highlight,
-	ErrorHeaderText,
-	Help,
-	// Status bar styles
-	StatusBar,
-	StatusText,
-	StatusNugget,
-	StatusMode lipgloss.Style
+func (s fileConfigStore) Save(config Config) error {
+	config.dir, config.profile = s.dir, s.profile
+	return saveConfig(config)
 }
 
-// NewStyles creates a new Styles instance with the given theme
-func NewStyles(lg *lipgloss.Renderer, theme StyleTheme) *Styles {
-	s := Styles{}
-	s.Base = lg.NewStyle().
-		Padding(1, 4, 0, 1)
-	s.HeaderText = lg.NewStyle().
-		Foreground(theme.Base).
-		Bold(true).
-		Padding(0, 1, 0, 2)
-	s.Status = lg.NewStyle().
-		Border(lipgloss.RoundedBorder()).
-		BorderForeground(theme.Base).
-		PaddingLeft(1).
-		MarginTop(1)
-	s.StatusHeader = lg.NewStyle().
-		Foreground(theme.Base).
-		Bold(true)
-	s.Highlight = lg.NewStyle().
-		Foreground(theme.Accent) // Use Accent color for highlighting
-	s.ErrorHeaderText = lg.NewStyle().
-		Foreground(theme.Error).
-		Bold(true).
-		Padding(0, 1, 0, 2)
-	s.Help = lg.NewStyle().
-		Foreground(lipgloss.AdaptiveColor{Light: "241", Dark: "241"})
+// memoryConfigStore keeps a config in memory, so the TUI can run without touching the user's
+// config file. Drafts, history and forms are still kept in the config's dir.
+type memoryConfigStore struct {
+	mu     sync.Mutex
+	config Config
+	saves  int
+}
 
-	// Initialize status bar styles
-	s.StatusBar = lg.NewStyle().
-		Foreground(lipgloss.AdaptiveColor{Light: "#343433", Dark: "#C1C6B2"}).
-		Background(lipgloss.AdaptiveColor{Light: "#D9DCCF", Dark: "#353533"})
+// newMemoryConfigStore returns a store holding config, with the default providers added as
+// loadConfig would
+func newMemoryConfigStore(config Config) *memoryConfigStore {
+	models := make(map[string]llm.ModelConfig, len(DefaultModelConfigs)+len(config.Models))
+	for k, v := range DefaultModelConfigs {
+		models[k] = v
+	}
+	for k, v := range config.Models {
+		models[k] = v
+	}
+	config.Models = models
+	return &memoryConfigStore{config: config}
+}
 
-	s.StatusText = lg.NewStyle().
-		Inherit(s.StatusBar)
+func (s *memoryConfigStore) Load() (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config.clone(), nil
+}
 
-	s.StatusNugget = lg.NewStyle().
-		Foreground(lipgloss.Color("#FFFDF5")).
-		Padding(0, 1)
+func (s *memoryConfigStore) Save(config Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config.clone()
+	s.saves++
+	return nil
+}
 
-	s.StatusMode = lg.NewStyle().
-		Inherit(s.StatusBar).
-		Foreground(lipgloss.Color("#FFFDF5")).
-		Background(theme.Base).
-		Padding(0, 1).
-		MarginRight(1)
+// Saves returns how many times the config has been saved
+func (s *memoryConfigStore) Saves() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saves
+}
 
-	return &s
+// clone returns a copy of c whose providers can be changed without changing c's
+func (c Config) clone() Config {
+	models := make(map[string]llm.ModelConfig, len(c.Models))
+	for k, v := range c.Models {
+		models[k] = v
+	}
+	c.Models = models
+	keys := make(map[string][]string, len(c.Keys))
+	for k, v := range c.Keys {
+		keys[k] = append([]string(nil), v...)
+	}
+	c.Keys = keys
+	return c
 }
 
-type formType struct {
-	name      string
-	questions []string
-	prompt    string
+// activeModelConfig returns the settings of the active model, or false if no model is selected
+// or the selected one is no longer in the config
+func (c Config) activeModelConfig() (llm.ModelConfig, bool) {
+	if c.ActiveModel == "" {
+		return llm.ModelConfig{}, false
+	}
+	modelConfig, ok := c.Models[c.ActiveModel]
+	return modelConfig, ok
 }
 
-var formTypes = []formType{
-	{
-		name: "Incident Response",
-		questions: []string{
-			"What happened?",
-			"What did you do?",
-			"Why did you do it?",
-			"Did it work? If not, what was the result?",
-			"What did you learn?",
-		},
-		prompt: "Using the following text, craft an informative and detailed work note for an incident response. The output of your response should be a between 2 sentences and several paragraphs, depending on the amount of context offered. It does not need to restate the rubric questions. Ensure clarity and conciseness, without referring explicitly to 'the incident response'",
+// currentConfigVersion is written to config.json by saveConfig. Bump it when adding a migration.
+const currentConfigVersion = 1
+
+// configMigrations upgrade a config one version at a time: configMigrations[i] takes a
+// version i config to version i+1.
+var configMigrations = []func(config *Config){
+	// 0 -> 1: local entries record their API style instead of having it guessed from the port
+	func(config *Config) {
+		for k, v := range config.Models {
+			if v.Provider == llm.ProviderLocal && v.APIStyle == "" {
+				v.APIStyle = v.LocalAPIStyle()
+				config.Models[k] = v
+			}
+		}
+	},
+}
+
+// migrateConfig upgrades an older config to the current version. The original file is backed up
+// to config.json.bak before the upgraded config is written.
+func migrateConfig(config *Config, configFile string, original []byte) error {
+	from := config.Version
+	for v := from; v < currentConfigVersion; v++ {
+		configMigrations[v](config)
+	}
+	config.Version = currentConfigVersion
+	logf("Migrated config from version %d to %d", from, currentConfigVersion)
+
+	if err := ioutil.WriteFile(configFile+".bak", original, 0600); err != nil {
+		return fmt.Errorf("failed to back up config file: %v", err)
+	}
+	return saveConfig(*config)
+}
+
+// backupNewerConfig copies configFile to configFile.bak if it was written by a newer version,
+// whose settings would be lost when this build saves over it
+func backupNewerConfig(configFile string) error {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil // Nothing to lose
+	}
+	var onDisk struct {
+		Version int `json:"version"`
+	}
+	if json.Unmarshal(data, &onDisk) != nil || onDisk.Version <= currentConfigVersion {
+		return nil
+	}
+	if err := ioutil.WriteFile(configFile+".bak", data, 0600); err != nil {
+		return fmt.Errorf("not saving %s: it's from a newer version and couldn't be backed up: %v", configFile, err)
+	}
+	logf("Backed up version %d config to %s.bak before saving over it", onDisk.Version, configFile)
+	return nil
+}
+
+// envAPIKey looks up an API key for the given model in the environment.
+// TICKETDUCK_<KEY>_KEY takes priority over the provider's conventional variable.
+func envAPIKey(key string, provider llm.ModelProvider) string {
+	name := strings.ToUpper(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key))
+	if v := strings.TrimSpace(os.Getenv("TICKETDUCK_" + name + "_KEY")); v != "" {
+		return v
+	}
+
+	switch provider {
+	case llm.ProviderOpenAI:
+		return strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
+	case llm.ProviderAnthropic:
+		return strings.TrimSpace(os.Getenv("ANTHROPIC_API_KEY"))
+	}
+	return ""
+}
+
+// applyEnvAPIKeys fills in missing API keys from the environment.
+// Keys already present in the config file always win over the environment.
+func applyEnvAPIKeys(config *Config) {
+	for k, v := range config.Models {
+		if v.APIKey != "" {
+			continue
+		}
+		if key := envAPIKey(k, v.Provider); key != "" {
+			logf("Using API key for %s from environment", k)
+			v.APIKey = key
+			v.APIKeyFromEnv = true
+			config.Models[k] = v
+		}
+	}
+}
+
+// ---[ API Key Encryption ]--------------------------------------------------
+//
+// With --encrypt-keys, API keys in the config file are encrypted with AES-GCM using a key derived
+// from a passphrase. The passphrase is asked for once per session (or read from
+// TICKETDUCK_PASSPHRASE); every other setting stays readable.
+
+// keyEncryption records how the API keys in a config file were encrypted
+type keyEncryption struct {
+	Salt       string `json:"salt"` // Base64
+	Iterations int    `json:"iterations"`
+	Check      string `json:"check"` // checkPlaintext sealed with the key, to detect a wrong passphrase
+}
+
+const (
+	sealedPrefix          = "enc:"
+	checkPlaintext        = "ticketduck"
+	keyDerivationRounds   = 600000
+	maxDerivationRounds   = 10000000 // Far more than any config we write; keeps startup from hanging
+	maxPassphraseAttempts = 3
+)
+
+var errWrongPassphrase = errors.New("wrong passphrase")
+
+var (
+	// sessionPassphrase is remembered so the passphrase is only asked for once
+	sessionPassphrase string
+
+	// passphrasePromptAllowed is cleared once the TUI owns the terminal
+	passphrasePromptAllowed = true
+)
+
+// checkIterations rejects an iteration count from the config file that would quietly weaken
+// the key, or take so long that startup seems to hang
+func (enc keyEncryption) checkIterations() error {
+	if enc.Iterations < keyDerivationRounds || enc.Iterations > maxDerivationRounds {
+		return fmt.Errorf("invalid encryption iterations %d: must be between %d and %d", enc.Iterations, keyDerivationRounds, maxDerivationRounds)
+	}
+	return nil
+}
+
+// deriveKey turns a passphrase into the AES-256 key described by enc
+func (enc keyEncryption) deriveKey(passphrase string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(enc.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption salt: %v", err)
+	}
+	if err := enc.checkIterations(); err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key([]byte(passphrase), salt, enc.Iterations, 32, sha256.New), nil
+}
+
+// sealSecret encrypts a value for storing in the config file
+func sealSecret(key []byte, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret decrypts a value written by sealSecret
+func openSecret(key []byte, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return "", errors.New("value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// readPassphrase asks for a passphrase on the terminal without echoing it
+func readPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !passphrasePromptAllowed || !term.IsTerminal(fd) {
+		return "", errors.New("a passphrase is needed; set TICKETDUCK_PASSPHRASE")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return string(passphrase), nil
+}
+
+// unlockConfig decrypts the API keys of a config loaded from disk. The file itself is never
+// changed, so a wrong passphrase can't damage it.
+func unlockConfig(config *Config) error {
+	enc := *config.Encryption
+
+	// No passphrase can help with a bad iteration count, so don't ask for one
+	if err := enc.checkIterations(); err != nil {
+		return err
+	}
+
+	// Try the passphrase given earlier in the session (or in the environment) first
+	candidates := []string{}
+	if env := os.Getenv("TICKETDUCK_PASSPHRASE"); env != "" {
+		candidates = append(candidates, env)
+	} else if sessionPassphrase != "" {
+		candidates = append(candidates, sessionPassphrase)
+	}
+
+	var key []byte
+	for attempt := 0; key == nil; attempt++ {
+		var passphrase string
+		if attempt < len(candidates) {
+			passphrase = candidates[attempt]
+		} else if attempt < len(candidates)+maxPassphraseAttempts && os.Getenv("TICKETDUCK_PASSPHRASE") == "" {
+			var err error
+			passphrase, err = readPassphrase(fmt.Sprintf("Passphrase for %s: ", configFileName(config.profile)))
+			if err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("failed to unlock %s: %w", configFileName(config.profile), errWrongPassphrase)
+		}
+
+		derived, err := enc.deriveKey(passphrase)
+		if err != nil {
+			return err
+		}
+		if check, err := openSecret(derived, enc.Check); err != nil || check != checkPlaintext {
+			logf("Wrong passphrase for %s", configFileName(config.profile))
+			if attempt >= len(candidates) {
+				fmt.Fprintln(os.Stderr, "Wrong passphrase.")
+			}
+			continue
+		}
+		key = derived
+		sessionPassphrase = passphrase
+	}
+
+	for k, v := range config.Models {
+		if v.APIKey == "" {
+			continue
+		}
+		apiKey, err := openSecret(key, v.APIKey)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt API key for %s: %v", k, err)
+		}
+		v.APIKey = apiKey
+		config.Models[k] = v
+	}
+	for _, secret := range config.secrets() {
+		if *secret.value == "" {
+			continue
+		}
+		plaintext, err := openSecret(key, *secret.value)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %v", secret.name, err)
+		}
+		*secret.value = plaintext
+	}
+	config.secretKey = key
+	return nil
+}
+
+// secrets returns the settings besides API keys that are encrypted along with them
+func (c *Config) secrets() []struct {
+	name  string
+	value *string
+} {
+	return []struct {
+		name  string
+		value *string
+	}{
+		{"Slack webhook URL", &c.SlackWebhookURL},
+		{"GitHub token", &c.GitHubToken},
+		{"Jira API token", &c.JiraToken},
+	}
+}
+
+// fileHasEncryptedKeys reports whether the config file on disk stores encrypted API keys
+func fileHasEncryptedKeys(configFile string) bool {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return false
+	}
+	var onDisk struct {
+		Encryption *keyEncryption `json:"encryption"`
+	}
+	return json.Unmarshal(data, &onDisk) == nil && onDisk.Encryption != nil
+}
+
+// enableKeyEncryption asks for a new passphrase and rewrites the profile's config with its API
+// keys encrypted. It does nothing if they already are.
+func enableKeyEncryption(configDir, profile string) error {
+	config, err := loadConfig(configDir, profile)
+	if err != nil {
+		return err
+	}
+	if config.Encryption != nil {
+		logf("API keys in %s are already encrypted", configFileName(profile))
+		return nil
+	}
+
+	passphrase := os.Getenv("TICKETDUCK_PASSPHRASE")
+	if passphrase == "" {
+		if passphrase, err = readPassphrase("New passphrase for API keys: "); err != nil {
+			return err
+		}
+		confirm, err := readPassphrase("Repeat passphrase: ")
+		if err != nil {
+			return err
+		}
+		if confirm != passphrase {
+			return errors.New("the passphrases don't match")
+		}
+		if passphrase == "" {
+			return errors.New("the passphrase can't be empty")
+		}
+	}
+
+	salt := make([]byte, 16)
+	if _, err := cryptorand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %v", err)
+	}
+	enc := keyEncryption{Salt: base64.StdEncoding.EncodeToString(salt), Iterations: keyDerivationRounds}
+	key, err := enc.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	if enc.Check, err = sealSecret(key, checkPlaintext); err != nil {
+		return fmt.Errorf("failed to encrypt API keys: %v", err)
+	}
+
+	config.Encryption = &enc
+	config.secretKey = key
+	if err := saveConfig(config); err != nil {
+		return err
+	}
+	sessionPassphrase = passphrase
+	logf("Encrypted API keys in %s", configFileName(profile))
+	fmt.Fprintf(os.Stderr, "API keys in %s are now encrypted.\n", configFileName(profile))
+	return nil
+}
+
+// ---[ Lip Gloss Styles ]-----------------------------------------------------
+
+// StyleTheme represents a predefined style theme
+type StyleTheme struct {
+	Name    string                 `json:"name"`
+	Base    lipgloss.AdaptiveColor `json:"base"`
+	Accent  lipgloss.AdaptiveColor `json:"accent"`
+	Error   lipgloss.AdaptiveColor `json:"error"`
+	Success lipgloss.AdaptiveColor `json:"success"`
+}
+
+// Available style themes
+var styleThemes = []StyleTheme{
+	{
+		Name:    "Normal",
+		Base:    lipgloss.AdaptiveColor{Light: "#a5b342", Dark: "#a5b342"},
+		Accent:  lipgloss.AdaptiveColor{Light: "#FFFAFF", Dark: "#808080"},
+		Error:   lipgloss.AdaptiveColor{Light: "#FFFAFF", Dark: "#808080"},
+		Success: lipgloss.AdaptiveColor{Light: "#FFFAFF", Dark: "#808080"},
 	},
 	{
-		name: "Pull Request/Commit Message",
-		questions: []string{
-			"What did you do?",
-			"Why did you do it?",
-			"What did you learn?",
-		},
-		prompt: "Using the following text, craft an informative and detailed title and description for a commit message or pull request. The output of your response should be a between 2 sentences and several paragraphs, depending on the amount of context offered. It does not need to restate the rubric questions. Ensure clarity and conciseness, without referring explicitly to 'the pull request' or 'the commit message'",
+		Name:    "Forest",
+		Base:    lipgloss.AdaptiveColor{Light: "#04B575", Dark: "#25cc6a"},
+		Accent:  lipgloss.AdaptiveColor{Light: "#7D56F4", Dark: "#7D56F4"},
+		Error:   lipgloss.AdaptiveColor{Light: "#FF5F87", Dark: "#FF5F87"},
+		Success: lipgloss.AdaptiveColor{Light: "#02BA84", Dark: "#02BF87"},
 	},
 	{
-		name: "Service Request",
-		questions: []string{
-			"What do you want?",
-			"Why do you want it?",
-			"How do you want it?",
-			"What will you do with it?",
-		},
-		prompt: "Using the following text, craft an informative and detailed message for a service request that is being made of a colleague. The output of your response should be a between 2 sentences and several paragraphs, depending on the amount of context offered. It does not need to restate the rubric questions. Ensure clarity and conciseness, without referring explicitly to 'the service request'",
+		Name:    "Ocean",
+		Base:    lipgloss.AdaptiveColor{Light: "#5A56E0", Dark: "#0c8ffa"},
+		Accent:  lipgloss.AdaptiveColor{Light: "#00B4D8", Dark: "#00B4D8"},
+		Error:   lipgloss.AdaptiveColor{Light: "#FF6B6B", Dark: "#FF6B6B"},
+		Success: lipgloss.AdaptiveColor{Light: "#4ECDC4", Dark: "#4ECDC4"},
 	},
 	{
-		name: "Development ticket",
-		questions: []string{
-			"Is this a feature, bug, or chore?",
-			"What is the current behavior?",
-			"How do you want to change, modify, or add behavior?",
-			"Why do you want this change? What are the benefits?",
-			"What are the acceptance criteria for this change?",
-		},
-		prompt: "Your task is to use the following text to create a detailed and informative ticket for a development task. The output of your response should be a between 2 sentences and several paragraphs, depending on the amount of context offered. It does not need to restate the rubric questions. Ensure clarity and conciseness, without referring explicitly to 'the ticket' or 'the development task'",
+		Name:    "Sunset",
+		Base:    lipgloss.AdaptiveColor{Light: "#FF6B6B", Dark: "#FF6B6B"},
+		Accent:  lipgloss.AdaptiveColor{Light: "#FFD166", Dark: "#FFD166"},
+		Error:   lipgloss.AdaptiveColor{Light: "#EF476F", Dark: "#EF476F"},
+		Success: lipgloss.AdaptiveColor{Light: "#06D6A0", Dark: "#06D6A0"},
 	},
+	{
+		// Bright colors on dark terminals (and black on light ones) for maximum contrast
+		Name:    "High Contrast",
+		Base:    lipgloss.AdaptiveColor{Light: "#000000", Dark: "#FFFFFF"},
+		Accent:  lipgloss.AdaptiveColor{Light: "#0000CC", Dark: "#FFFF00"},
+		Error:   lipgloss.AdaptiveColor{Light: "#B00000", Dark: "#FF6E6E"},
+		Success: lipgloss.AdaptiveColor{Light: "#00008B", Dark: "#00FFFF"},
+	},
+	{
+		// Okabe-Ito colors: errors are orange and successes blue, never red against green
+		Name:    "Colorblind Safe",
+		Base:    lipgloss.AdaptiveColor{Light: "#0072B2", Dark: "#56B4E9"},
+		Accent:  lipgloss.AdaptiveColor{Light: "#CC79A7", Dark: "#F0E442"},
+		Error:   lipgloss.AdaptiveColor{Light: "#D55E00", Dark: "#E69F00"},
+		Success: lipgloss.AdaptiveColor{Light: "#0072B2", Dark: "#56B4E9"},
+	},
+	{
+		// No colors at all; see monochrome()
+		Name: monochromeThemeName,
+	},
+}
+
+// monochromeThemeName names the built-in theme that renders plain text without escape codes.
+// It is chosen automatically when NO_COLOR is set or TERM is dumb.
+const monochromeThemeName = "Monochrome"
+
+// monochrome reports whether the theme should render without any colors or text attributes
+func (t StyleTheme) monochrome() bool {
+	return t.Name == monochromeThemeName
+}
+
+// noSpinner is the spinner setting that turns the animation off
+const noSpinner = "none"
+
+// spinnerStyles are the animations the spinner setting can choose from
+var spinnerStyles = map[string]spinner.Spinner{
+	"dot":       spinner.Dot,
+	"line":      spinner.Line,
+	"minidot":   spinner.MiniDot,
+	"jump":      spinner.Jump,
+	"pulse":     spinner.Pulse,
+	"points":    spinner.Points,
+	"globe":     spinner.Globe,
+	"moon":      spinner.Moon,
+	"monkey":    spinner.Monkey,
+	"meter":     spinner.Meter,
+	"hamburger": spinner.Hamburger,
+	"ellipsis":  spinner.Ellipsis,
+}
+
+// spinnerStyle returns the spinner animation to show while waiting, and false if it's turned
+// off: by the spinner setting, or by ACCESSIBLE for screen readers and other assistive tools
+func (c Config) spinnerStyle() (spinner.Spinner, bool) {
+	if c.Spinner == noSpinner || os.Getenv("ACCESSIBLE") != "" {
+		return spinner.Dot, false
+	}
+	if style, ok := spinnerStyles[c.Spinner]; ok {
+		return style, true
+	}
+	return spinner.Dot, true // Also for unknown names, see initialModel
+}
+
+// spinnerTick starts the spinner animation, unless it's turned off
+func (m model) spinnerTick() tea.Cmd {
+	if _, animated := m.config.spinnerStyle(); !animated {
+		return nil
+	}
+	return m.spinner.Tick
+}
+
+// noColorRequested reports whether the environment asks for output without colors
+// (https://no-color.org, or a terminal that can't show them)
+func noColorRequested() bool {
+	return os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb"
+}
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// validColor reports whether s is a color lipgloss understands: a hex code or an ANSI color number
+func validColor(s string) bool {
+	if hexColorPattern.MatchString(s) {
+		return true
+	}
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 0 && n <= 255
+}
+
+// validateTheme checks that a custom theme has a name and that all of its colors parse
+func validateTheme(theme StyleTheme) error {
+	if strings.TrimSpace(theme.Name) == "" {
+		return fmt.Errorf("theme has no name")
+	}
+	colors := []struct {
+		field string
+		color lipgloss.AdaptiveColor
+	}{
+		{"base", theme.Base},
+		{"accent", theme.Accent},
+		{"error", theme.Error},
+		{"success", theme.Success},
+	}
+	for _, c := range colors {
+		if !validColor(c.color.Light) || !validColor(c.color.Dark) {
+			return fmt.Errorf("theme %q has an invalid %s color (light %q, dark %q)", theme.Name, c.field, c.color.Light, c.color.Dark)
+		}
+	}
+	return nil
+}
+
+// loadStyleThemes returns the built-in themes followed by the valid custom themes from the config
+func loadStyleThemes(custom []StyleTheme) []StyleTheme {
+	themes := append([]StyleTheme{}, styleThemes...)
+	for _, theme := range custom {
+		if err := validateTheme(theme); err != nil {
+			logf("Skipping custom theme: %v", err)
+			continue
+		}
+		if styleThemeExists(themes, theme.Name) {
+			logf("Skipping custom theme %q: a theme with that name already exists", theme.Name)
+			continue
+		}
+		themes = append(themes, theme)
+	}
+	return themes
+}
+
+// styleThemeExists reports whether a theme with the given name is in the list
+func styleThemeExists(themes []StyleTheme, name string) bool {
+	for _, theme := range themes {
+		if theme.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Styles defines the styling for the application
+type Styles struct {
+	Base,
+	HeaderText,
+	Status,
+	StatusHeader,
+	Highlight,
+	ErrorHeaderText,
+	Help,
+	// Status bar styles
+	StatusBar,
+	StatusText,
+	StatusNugget,
+	StatusMode lipgloss.Style
+}
+
+// NewStyles creates a new Styles instance with the given theme
+func NewStyles(lg *lipgloss.Renderer, theme StyleTheme) *Styles {
+	if theme.monochrome() {
+		return newMonochromeStyles(lg)
+	}
+
+	s := Styles{}
+	s.Base = lg.NewStyle().
+		Padding(1, 4, 0, 1)
+	s.HeaderText = lg.NewStyle().
+		Foreground(theme.Base).
+		Bold(true).
+		Padding(0, 1, 0, 2)
+	s.Status = lg.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(theme.Base).
+		PaddingLeft(1).
+		MarginTop(1)
+	s.StatusHeader = lg.NewStyle().
+		Foreground(theme.Base).
+		Bold(true)
+	s.Highlight = lg.NewStyle().
+		Foreground(theme.Accent) // Use Accent color for highlighting
+	s.ErrorHeaderText = lg.NewStyle().
+		Foreground(theme.Error).
+		Bold(true).
+		Padding(0, 1, 0, 2)
+	s.Help = lg.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "241", Dark: "241"})
+
+	// Initialize status bar styles
+	s.StatusBar = lg.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#343433", Dark: "#C1C6B2"}).
+		Background(lipgloss.AdaptiveColor{Light: "#D9DCCF", Dark: "#353533"})
+
+	s.StatusText = lg.NewStyle().
+		Inherit(s.StatusBar)
+
+	s.StatusNugget = lg.NewStyle().
+		Foreground(lipgloss.Color("#FFFDF5")).
+		Padding(0, 1)
+
+	s.StatusMode = lg.NewStyle().
+		Inherit(s.StatusBar).
+		Foreground(lipgloss.Color("#FFFDF5")).
+		Background(theme.Base).
+		Padding(0, 1).
+		MarginRight(1)
+
+	return &s
+}
+
+// ErrorStatus renders a one-line error message. The glyph keeps errors apart from successes
+// without relying on color.
+func (s *Styles) ErrorStatus(text string) string {
+	return s.ErrorHeaderText.Render("✗ " + text)
+}
+
+// SuccessStatus renders a one-line confirmation, marked with a glyph like ErrorStatus
+func (s *Styles) SuccessStatus(text string) string {
+	return s.StatusHeader.Render("✓ " + text)
+}
+
+// newMonochromeStyles keeps the layout of NewStyles but drops every color and text attribute
+func newMonochromeStyles(lg *lipgloss.Renderer) *Styles {
+	s := Styles{}
+	s.Base = lg.NewStyle().
+		Padding(1, 4, 0, 1)
+	s.HeaderText = lg.NewStyle().
+		Padding(0, 1, 0, 2)
+	s.Status = lg.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		PaddingLeft(1).
+		MarginTop(1)
+	s.StatusHeader = lg.NewStyle()
+	s.Highlight = lg.NewStyle()
+	s.ErrorHeaderText = lg.NewStyle().
+		Padding(0, 1, 0, 2)
+	s.Help = lg.NewStyle()
+	s.StatusBar = lg.NewStyle()
+	s.StatusText = lg.NewStyle()
+	s.StatusNugget = lg.NewStyle().
+		Padding(0, 1)
+	s.StatusMode = lg.NewStyle().
+		Padding(0, 1).
+		MarginRight(1)
+	return &s
+}
+
+type formType struct {
+	name      string
+	questions []string
+	required  []bool // Parallel to questions; questions past its end are optional
+	prompt    string
+}
+
+// isRequired reports whether question i must be answered before moving on
+func (f formType) isRequired(i int) bool {
+	return i < len(f.required) && f.required[i]
+}
+
+// missingAnswer returns the first required question left blank in answers, or -1 if there's none
+func (f formType) missingAnswer(answers []string) int {
+	for i := range f.questions {
+		if f.isRequired(i) && (i >= len(answers) || strings.TrimSpace(answers[i]) == "") {
+			return i
+		}
+	}
+	return -1
+}
+
+var formTypes = []formType{
+	{
+		name: "Incident Response",
+		questions: []string{
+			"What happened?",
+			"What did you do?",
+			"Why did you do it?",
+			"Did it work? If not, what was the result?",
+			"What did you learn?",
+		},
+		required: []bool{true},
+		prompt:   "Using the following text, craft an informative and detailed work note for an incident response. The output of your response should be a between 2 sentences and several paragraphs, depending on the amount of context offered. It does not need to restate the rubric questions. Ensure clarity and conciseness, without referring explicitly to 'the incident response'",
+	},
+	{
+		name: "Pull Request/Commit Message",
+		questions: []string{
+			"What did you do?",
+			"Why did you do it?",
+			"What did you learn?",
+		},
+		required: []bool{true},
+		prompt:   "Using the following text, craft an informative and detailed title and description for a commit message or pull request. The output of your response should be a between 2 sentences and several paragraphs, depending on the amount of context offered. It does not need to restate the rubric questions. Ensure clarity and conciseness, without referring explicitly to 'the pull request' or 'the commit message'",
+	},
+	{
+		name: "Service Request",
+		questions: []string{
+			"What do you want?",
+			"Why do you want it?",
+			"How do you want it?",
+			"What will you do with it?",
+		},
+		required: []bool{true},
+		prompt:   "Using the following text, craft an informative and detailed message for a service request that is being made of a colleague. The output of your response should be a between 2 sentences and several paragraphs, depending on the amount of context offered. It does not need to restate the rubric questions. Ensure clarity and conciseness, without referring explicitly to 'the service request'",
+	},
+	{
+		name: "Development ticket",
+		questions: []string{
+			"Is this a feature, bug, or chore?",
+			"What is the current behavior?",
+			"How do you want to change, modify, or add behavior?",
+			"Why do you want this change? What are the benefits?",
+			"What are the acceptance criteria for this change?",
+		},
+		required: []bool{true},
+		prompt:   "Your task is to use the following text to create a detailed and informative ticket for a development task. The output of your response should be a between 2 sentences and several paragraphs, depending on the amount of context offered. It does not need to restate the rubric questions. Ensure clarity and conciseness, without referring explicitly to 'the ticket' or 'the development task'",
+	},
+}
+
+// formFile mirrors formType for reading user-defined forms from forms.json
+type formFile struct {
+	Name      string             `json:"name"`
+	Questions []formQuestionFile `json:"questions"`
+	Prompt    string             `json:"prompt"`
+}
+
+// formQuestionFile is a question in forms.json: either a plain string, or an object
+// like {"question": "...", "required": true} for a question that can't be left blank
+type formQuestionFile struct {
+	Question string `json:"question"`
+	Required bool   `json:"required"`
+}
+
+// UnmarshalJSON accepts both forms of a question
+func (q *formQuestionFile) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*q = formQuestionFile{Question: text}
+		return nil
+	}
+
+	type plain formQuestionFile // Without the method, so this doesn't recurse
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("a question must be a string or an object with \"question\" and \"required\": %v", err)
+	}
+	*q = formQuestionFile(p)
+	return nil
+}
+
+// loadFormTypes returns the built-in form types merged with any user-defined forms
+// from forms.json in the config directory. A user form with the same name as a
+// built-in one replaces it; otherwise it is appended to the list.
+func loadFormTypes(configDir string) []formType {
+	forms := make([]formType, len(formTypes))
+	copy(forms, formTypes)
+
+	formsFile := filepath.Join(configDir, "forms.json")
+	data, err := ioutil.ReadFile(formsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logf("Failed to read %s, using built-in forms: %v", formsFile, err)
+		}
+		return forms
+	}
+
+	var userForms []formFile
+	if err := json.Unmarshal(data, &userForms); err != nil {
+		logf("Failed to parse %s, using built-in forms: %v", formsFile, err)
+		return forms
+	}
+
+	for i, uf := range userForms {
+		name := strings.TrimSpace(uf.Name)
+		prompt := strings.TrimSpace(uf.Prompt)
+
+		var questions []string
+		var required []bool
+		for _, q := range uf.Questions {
+			if text := strings.TrimSpace(q.Question); text != "" {
+				questions = append(questions, text)
+				required = append(required, q.Required)
+			}
+		}
+
+		// Skip entries that couldn't produce a usable form
+		if name == "" || prompt == "" || len(questions) == 0 {
+			logf("Skipping form #%d in %s: a form needs a name, a prompt, and at least one question", i+1, formsFile)
+			continue
+		}
+
+		form := formType{
+			name:      name,
+			questions: questions,
+			required:  required,
+			prompt:    prompt,
+		}
+
+		replaced := false
+		for j := range forms {
+			if forms[j].name == name {
+				forms[j] = form
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			forms = append(forms, form)
+		}
+		logf("Loaded custom form %q with %d questions", name, len(questions))
+	}
+
+	return forms
+}
+
+var (
+	titleStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#FFFFFF")).
+			Background(lipgloss.Color("#7D56F4")).
+			Padding(0, 1)
+
+	cursorStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#04B575")).
+			Bold(true)
+
+	selectedStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FF5F87")).
+			Bold(true)
+
+	checkedStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#A550DF"))
+
+	dimStyle = lipgloss.NewStyle().
+			Faint(true)
+
+	helpStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("241"))
+)
+
+// ---[ Model ]----------------------------------------------------------------
+//
+// This section defines the Model interface (Model as in Model-View-Controller/MVC, not Model as in machine learning model)
+// and its implementation for the bubbletea framework.
+//
+// The Model interface is used to manage the state and behavior of the application.
+// It defines the Update method, which is called when a message is received from the terminal.
+//
+
+type model struct {
+	currentMode mode
+	styles      *Styles
+	onboarding  bool // True during first-run setup: welcome, provider, settings, theme
+
+	// For selection mode:
+	formTypes     []formType
+	cursor        int
+	selectedIndex int            // The index of the selected item, where -1 means no item is selected
+	editingPrompt bool           // True while the prompt of the form at the cursor is being edited
+	promptInput   textarea.Model // Editor for a form's prompt
+	promptStatus  string         // Confirmation or error shown after saving a prompt
+	formFilter    listFilter     // Narrows the form types shown
+
+	// For importing answers from a file into the form at the cursor:
+	importInput      textinput.Model
+	importingAnswers bool   // True while the file path prompt is open
+	importErr        string // Why the last import failed
+
+	// For rubric mode:
+	currentForm     formType
+	answers         []string
+	currentQuestion int
+	answerInput     textarea.Model // Multi-line input for the current answer
+	answerWarning   string         // Why the answer couldn't be submitted or skipped, until the next key
+	jumpingTo       bool           // True while the go-to-question prompt is open
+	resumeDraft     *formDraft     // A draft found at startup, until it's resumed or discarded
+	jumpQuery       string         // Question number typed into it so far
+
+	// For review mode:
+	reviewCursor      int
+	editingFromReview bool     // True when an answer is being edited from the review screen
+	contextWarning    string   // Set while asking whether to send a prompt that may not fit the context window
+	previewingPrompt  bool     // True while the viewport shows the prompt that would be sent
+	choosingCompare   bool     // True while picking the model to compare the active one with
+	compareChoices    []string // Configured models other than the active one
+	compareCursor     int
+	reviewWarning     string // Why the answers couldn't be sent, until the next key
+
+	// For compare mode:
+	comparePanes  [2]comparePane // The active model's result on the left, the other on the right
+	compareFocus  int            // Pane that scrolls and copies
+	compareStatus string         // One-line confirmation or error shown under the panes
+
+	// For display mode:
+	viewport viewport.Model
+	// Store the raw output from the LLM so we can re-render if needed.
+	gptRawOutput string
+	// Provider's model name and time of the output, for the front matter of saved summaries
+	generatedBy string
+	generatedAt time.Time
+	usage       *llm.Usage // Token counts the provider reported for the output, nil if it didn't
+	// Store the rendered markdown content so we can re-display or update if needed.
+	content string
+	// Show m.content as raw markdown source instead of the styled rendering
+	showRawMarkdown bool
+	// Markup the summary is converted to by ctrl+y
+	copyFormat copyFormat
+	// Where ctrl+y copies to: the system clipboard, or the terminal's when that isn't available
+	clipboard         Clipboard
+	terminalClipboard Clipboard
+
+	// State for the request running in the background:
+	requestID       int           // Identifies the latest request so stale results are ignored
+	requestMarkdown string        // The answers markdown the summary is appended to
+	generating      bool          // True while a request is in flight
+	regenerating    bool          // True if the request in flight replaces an earlier summary
+	crashErr        string        // Set when Update recovered from a panic; shown instead of the view
+	refreshCache    bool          // True if the next request should skip the response cache
+	previousContent string        // Content to restore if regeneration fails
+	previousOutput  string        // Raw output to restore if regeneration fails
+	refining        bool          // True if the request in flight is a follow-up instruction
+	conversation    []llm.Message // Turns so far behind the summary shown, sent again with follow-ups
+	pendingTurns    []llm.Message // The conversation sent with the request in flight
+	spinner         spinner.Model // Shown until the first output arrives
+	showSpinner     bool          // True while the spinner should keep ticking
+
+	requestCancel context.CancelFunc // Cancels the request in flight, see stopRequest
+
+	keys       keymap // Keys for each screen's actions, from the config
+	pendingKey string // First key of a sequence such as gg, while waiting for the second
+
+	showHelp bool // True while the key binding overlay is open
+
+	confirmingQuit bool // True while asking whether to quit and lose unsaved work
+	outputSaved    bool // True once the current output has been copied or saved
+
+	// For history mode:
+	historyEntries  []historyEntry // Saved summaries, newest first
+	historyCursor   int
+	deletingHistory bool   // True while asking whether to delete the entry at the cursor
+	historyErr      string // Problem loading or deleting entries, shown under the list
+
+	// For follow-up instructions from display mode:
+	followUpInput  textinput.Model
+	askingFollowUp bool // True while the follow-up prompt is open
+
+	// For the analyst's own note at the end of the summary:
+	noteInput   textinput.Model
+	askingNote  bool   // True while the note prompt is open
+	analystNote string // The note appended to gptRawOutput, if any
+
+	// For saving the output to a file from display mode:
+	fileNameInput textinput.Model
+	savingToFile  bool      // True while the filename prompt is open
+	postingSlack  bool      // True while the summary is being posted to Slack
+	creatingIssue bool      // True while a GitHub issue is being created from the summary
+	displayStatus string    // One-line confirmation or error shown under the viewport
+	rejectedKey   string    // Model whose API key the provider rejected on the last request, if any
+	requestStart  time.Time // When the running request was sent, for the elapsed time
+	requestErr    string    // Why the last request failed, shown by error mode until it's retried or abandoned
+
+	// For posting the summary to Jira from display mode:
+	jiraInput      textinput.Model
+	askingJiraKey  bool // True while asking which issue to comment on
+	submittingJira bool // True while the summary is being posted to Jira
+
+	// For API key input mode:
+	apiKeyInput    textinput.Model
+	apiBaseInput   textinput.Model
+	modelNameInput textinput.Model
+	focusedInput   int // Index into apiConfigFields()
+	saveConfig     bool
+	fieldErrors    map[apiConfigField]string // Why each field couldn't be saved, from the last Enter
+
+	// Model names fetched from the provider, shown as a pick list instead of the free-text field
+	availableModels []string
+	modelListCursor int
+	modelListErr    string              // Why the list couldn't be loaded, if it failed
+	fetchingModels  bool                // True while the list is being fetched
+	testingModel    bool                // True while a test request is running
+	testStatus      string              // Result of the last connection test
+	modelListCache  map[string][]string // Lists already fetched this session
+
+	// For model selection:
+	config        Config
+	store         ConfigStore // Where config is saved
+	modelCursor   int
+	modelKeys     []string   // Keys from the Models map for easier navigation
+	deletingModel string     // Key of the provider awaiting delete confirmation, if any
+	modelFilter   listFilter // Narrows the providers shown
+	profiles      []string   // Profiles that can be switched to, see listProfiles
+	modelStatus   string     // Warning or error from switching profile
+
+	// For adding a provider from the model select screen:
+	addingModel      bool
+	newModelInput    textinput.Model // Key for the new entry
+	newModelTemplate int             // Index into newModelTemplates
+	newModelErr      string
+	selectedModel    string // Currently selected model key
+
+	width  int // Terminal size from the last tea.WindowSizeMsg
+	height int
+
+	// For style selection:
+	styleThemeIndex int
+	styleThemes     []StyleTheme
+}
+
+// initialModel sets up the choicebox, selection data, and an uninitialized viewport.
+func initialModel(store ConfigStore) model {
+	// Load config with model information
+	config, err := store.Load()
+	if err != nil {
+		log.Printf("Warning: Failed to load config: %v\n", err)
+		config = Config{
+			ActiveModel: "", // No default model selected
+			Models:      make(map[string]llm.ModelConfig),
+			dir:         config.dir, // Still set when loading fails
+			profile:     config.profile,
+		}
+		for k, v := range DefaultModelConfigs {
+			config.Models[k] = v
+		}
+		applyEnvAPIKeys(&config)
+	}
+
+	// Create sorted list of model keys for UI navigation
+	modelKeys := sortedModelKeys(config)
+
+	// Set up API key input field
+	tiKey := textinput.New()
+	tiKey.Placeholder = "Enter API key here..."
+	tiKey.Focus()
+	tiKey.CharLimit = 1000
+	tiKey.Width = 60
+	tiKey.EchoMode = textinput.EchoPassword // Shown as dots unless revealed with Ctrl+r
+	tiKey.EchoCharacter = '•'
+
+	// Set up API base URL input field
+	tiBase := textinput.New()
+	tiBase.Placeholder = "http://localhost:8000/v1"
+	tiBase.CharLimit = 100
+	tiBase.Width = 60
+
+	// Set up model name input field
+	tiModelName := textinput.New()
+	tiModelName.Placeholder = "Model name for API requests (e.g., llama3)"
+	tiModelName.CharLimit = 100
+	tiModelName.Width = 60
+
+	// Set up the multi-line answer input used in question mode
+	taAnswer := textarea.New()
+	taAnswer.Placeholder = "Type your answer..."
+	taAnswer.ShowLineNumbers = false
+	taAnswer.SetWidth(60)
+	taAnswer.SetHeight(6)
+	taAnswer.Focus()
+
+	// Set up the editor for overriding a form's prompt
+	taPrompt := textarea.New()
+	taPrompt.ShowLineNumbers = false
+	taPrompt.CharLimit = 0
+	taPrompt.SetWidth(60)
+	taPrompt.SetHeight(8)
+
+	// Set up the spinner shown while waiting for the LLM
+	sp := spinner.New()
+	sp.Spinner, _ = config.spinnerStyle()
+	if _, known := spinnerStyles[config.Spinner]; !known && config.Spinner != "" && config.Spinner != noSpinner {
+		logf("WARNING: unknown spinner %q, using dot", config.Spinner)
+	}
+	if !slices.Contains(hyperlinkSettings, config.Hyperlinks) {
+		warnf("unknown hyperlinks setting %q, using auto", config.Hyperlinks)
+	}
+
+	// Set up the name input used when adding a provider
+	tiNewModel := textinput.New()
+	tiNewModel.Placeholder = "e.g. vllm-gpu1"
+	tiNewModel.CharLimit = 64
+	tiNewModel.Width = 40
+
+	// Set up the follow-up instruction input used in display mode
+	tiFollowUp := textinput.New()
+	tiFollowUp.Placeholder = "e.g. make it shorter, add the root cause"
+	tiFollowUp.CharLimit = 1000
+	tiFollowUp.Width = 60
+
+	// Set up the note input used to annotate the summary in display mode
+	tiNote := textinput.New()
+	tiNote.Placeholder = "e.g. customer confirmed the workaround"
+	tiNote.CharLimit = 500
+	tiNote.Width = 60
+
+	// Set up the filename input used when saving output from display mode
+	tiFileName := textinput.New()
+	tiFileName.Placeholder = "summary.md"
+	tiFileName.CharLimit = 255
+	tiFileName.Width = 60
+
+	// Set up the path input used when importing answers on the main menu
+	tiJira := textinput.New()
+	tiJira.Placeholder = "PROJ-123, or empty for a new issue"
+	tiJira.CharLimit = 64
+	tiJira.Width = 60
+
+	tiImport := textinput.New()
+	tiImport.Placeholder = "answers.yaml or answers.json"
+	tiImport.CharLimit = 1024
+	tiImport.Width = 60
+
+	// Always start with selection mode, let the user navigate to model selection if needed
+	initialMode := selectionMode
+
+	// If no active model is set, or it names a provider that isn't configured, go to model selection first
+	if _, ok := config.activeModelConfig(); !ok {
+		initialMode = modelSelectMode
+	}
+
+	// On the very first run, walk through setup instead
+	onboarding := !config.Onboarded && !configFileExists(config.dir, config.profile)
+	if onboarding {
+		initialMode = welcomeMode
+	}
+
+	// Add any custom themes and restore the theme picked last time
+	themes := loadStyleThemes(config.Themes)
+	themeIndex := styleThemeIndex(themes, config.ActiveTheme)
+	if noColorRequested() {
+		// Not saved, so the usual theme comes back when colors are available again
+		logf("NO_COLOR or TERM=dumb is set, using the %s theme", monochromeThemeName)
+		themeIndex = styleThemeIndex(themes, monochromeThemeName)
+	}
+
+	m := model{
+		currentMode:     initialMode,
+		onboarding:      onboarding,
+		formTypes:       loadFormTypes(config.dir),
+		selectedIndex:   -1,
+		answers:         []string{},
+		answerInput:     taAnswer,
+		promptInput:     taPrompt,
+		spinner:         sp,
+		viewport:        viewport.Model{}, // We'll configure this later
+		apiKeyInput:     tiKey,
+		apiBaseInput:    tiBase,
+		modelNameInput:  tiModelName,
+		fileNameInput:   tiFileName,
+		jiraInput:       tiJira,
+		importInput:     tiImport,
+		followUpInput:   tiFollowUp,
+		noteInput:       tiNote,
+		newModelInput:   tiNewModel,
+		focusedInput:    0,
+		saveConfig:      true,
+		modelListCache:  make(map[string][]string),
+		config:          config,
+		store:           store,
+		modelKeys:       modelKeys,
+		profiles:        listProfiles(config.dir),
+		selectedModel:   config.ActiveModel,
+		modelCursor:     indexOf(modelKeys, config.ActiveModel),
+		styleThemes:     themes,
+		styleThemeIndex: themeIndex,
+		styles:          NewStyles(lipgloss.DefaultRenderer(), themes[themeIndex]),
+		width:           80, // Assuming a default width
+
+		clipboard:         systemClipboard{},
+		terminalClipboard: osc52Clipboard{out: terminal},
+		keys:              config.keymap(),
+	}
+
+	// Offer to pick up a form that was left unfinished last time
+	if draft, ok := loadDraft(config.dir); ok && !onboarding {
+		if m.draftFormIndex(draft) >= 0 {
+			m.resumeDraft = &draft
+		} else {
+			logf("Discarding draft of %q: the form has changed or is no longer available", draft.FormType)
+			clearDraft(config.dir)
+		}
+	}
+
+	return m
+}
+
+// styleThemeIndex returns the index of the named theme, or 0 (the default theme) if it no longer exists
+func styleThemeIndex(themes []StyleTheme, name string) int {
+	for i, theme := range themes {
+		if theme.Name == name {
+			return i
+		}
+	}
+	if name != "" {
+		logf("Saved theme %q not found, using %s", name, themes[0].Name)
+	}
+	return 0
+}
+
+// sortedModelKeys returns the config's model keys in display order
+func sortedModelKeys(config Config) []string {
+	keys := make([]string, 0, len(config.Models))
+	for k := range config.Models {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// indexOf returns the index of a string in a slice, or 0 if not found
+func indexOf(slice []string, item string) int {
+	for i, s := range slice {
+		if s == item {
+			return i
+		}
+	}
+	return 0
+}
+
+func (m model) Init() tea.Cmd {
+	return nil
+}
+
+// Update handles a message, recovering from panics in the handlers so a bug shows an error
+// screen instead of taking the TUI down
+func (m model) Update(msg tea.Msg) (result tea.Model, cmd tea.Cmd) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.crashErr = panicError(fmt.Sprintf("Update (%T)", msg), r).Error()
+			m.generating = false
+			m.showSpinner = false
+			result, cmd = m, nil
+		}
+	}()
+
+	// After a recovered panic only Esc (back to the menu) and the quit keys do anything
+	if m.crashErr != "" {
+		if key, ok := msg.(tea.KeyMsg); ok {
+			switch key.String() {
+			case "esc":
+				m.crashErr = ""
+				m.currentMode = selectionMode
+			case "q", "ctrl+q", "ctrl+c":
+				return m.quit()
+			}
+			return m, nil
+		}
+	}
+
+	return m.update(msg)
+}
+
+// update dispatches a message to the handler for the current mode
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	// Handle terminal resize events
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.layout()
+
+		// If in display mode, re-render the markdown to adjust wrapping
+		if m.currentMode == displayMode {
+			if err := m.renderContent(); err != nil {
+				log.Printf("Error re-rendering markdown on resize: %v\n", err)
+			}
+		}
+		if m.currentMode == compareMode {
+			m.layoutComparePanes()
+		}
+		// Return without further commands, as resizing is now handled.
+		return m, nil
+
+	// Handle chunks and results from a request running in the background
+	case llmChunkMsg:
+		return m.handleLLMChunk(msg)
+	case llmRetryMsg:
+		return m.handleLLMRetry(msg)
+	case llmResultMsg:
+		return m.handleLLMResult(msg)
+	case compareResultMsg:
+		return m.handleCompareResult(msg)
+
+	// Reload the summary after editing it in $EDITOR
+	case editorFinishedMsg:
+		return m.handleEditorFinished(msg)
+
+	// Keep the spinner ticking while waiting for output
+	case spinner.TickMsg:
+		if !m.showSpinner {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	// Handle the provider's model list once it has been fetched
+	case modelListMsg:
+		return m.handleModelList(msg), nil
+	case connectionTestMsg:
+		return m.handleConnectionTest(msg), nil
+	case slackPostMsg:
+		return m.handleSlackPost(msg), nil
+	case githubIssueMsg:
+		return m.handleGitHubIssue(msg), nil
+	case jiraPostMsg:
+		return m.handleJiraPost(msg), nil
+
+	// Handle other message types based on current mode
+	case tea.KeyMsg:
+		// Nothing but the size warning is on screen, so keys mustn't reach hidden inputs
+		if m.tooSmall() && !m.confirmingQuit {
+			switch msg.String() {
+			case "q", "ctrl+q", "ctrl+c":
+				return m.requestQuit()
+			}
+			return m, nil
+		}
+
+		// While asking whether to quit, only y quits; n or Esc goes back
+		if m.confirmingQuit {
+			switch msg.String() {
+			case "y", "Y":
+				return m.quit()
+			case "n", "N", "esc":
+				m.confirmingQuit = false
+			}
+			return m, nil
+		}
+
+		// While typing a filename, only Ctrl+q and Ctrl+c are treated as global keys
+		if m.currentMode == displayMode && (m.savingToFile || m.askingFollowUp || m.askingNote || m.askingJiraKey) && msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updateDisplayMode(msg)
+		}
+
+		// A pending delete confirmation or the new provider prompt gets the next key
+		if m.currentMode == modelSelectMode && (m.deletingModel != "" || m.addingModel) &&
+			msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updateModelSelectMode(msg)
+		}
+		if m.currentMode == selectionMode && m.editingPrompt && msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updatePromptEditor(msg)
+		}
+		if m.currentMode == selectionMode && m.importingAnswers && msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updateAnswerImport(msg)
+		}
+		if m.currentMode == selectionMode && m.formFilter.typing && msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updateFormFilter(msg)
+		}
+		if m.currentMode == modelSelectMode && m.modelFilter.typing && msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updateModelFilter(msg)
+		}
+		if m.currentMode == historyMode && m.deletingHistory && msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updateHistoryMode(msg)
+		}
+		if m.currentMode == questionMode && m.jumpingTo && msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updateQuestionJump(msg)
+		}
+		if m.currentMode == selectionMode && m.resumeDraft != nil && msg.Type != tea.KeyCtrlQ && msg.Type != tea.KeyCtrlC {
+			return m.updateDraftOffer(msg)
+		}
+
+		// Esc backs out of the prompt preview or model picker to the answers rather than the main menu
+		if m.currentMode == reviewMode && (m.previewingPrompt || m.choosingCompare) && msg.Type == tea.KeyEsc {
+			return m.updateReviewMode(msg)
+		}
+
+		// While the help overlay is open, keys only close it (or quit)
+		if m.showHelp {
+			switch msg.String() {
+			case "ctrl+q", "ctrl+c":
+				m.showHelp = false
+				return m.requestQuit()
+			case "?", "esc", "f1":
+				m.showHelp = false
+			}
+			return m, nil
+		}
+
+		// "?" is typed as text while entering an answer or API settings, so F1 opens help there
+		if msg.String() == "f1" || (msg.String() == "?" && !m.typingText()) {
+			m.showHelp = true
+			return m, nil
+		}
+
+		// Global key handlers that work in any mode. Esc never quits; quitting takes Ctrl+q
+		// (or Ctrl+c), or q (or the keymap's quit keys) when nothing is being typed.
+		if m.keys.action(scopeGlobal, msg.String()) == actionQuit && !m.typingText() {
+			return m.requestQuit()
+		}
+		switch msg.Type {
+		case tea.KeyCtrlQ, tea.KeyCtrlC:
+			return m.requestQuit()
+		case tea.KeyEsc:
+			// During first-run setup, Esc skips the rest of it
+			if m.onboarding {
+				return m.finishOnboarding(), nil
+			}
+			// Return to main menu from any mode except selection mode, abandoning a failed request
+			// or one that's still running
+			if m.currentMode != selectionMode {
+				m = m.abandonRequest()
+				m.requestErr = ""
+				m.currentMode = selectionMode
+				return m, nil
+			}
+		case tea.KeyRunes:
+			// Letters typed into an answer or API setting are left alone
+			if msg.String() == "~" && !m.typingText() {
+				// Add global shortcut to switch to model selection mode
+				m.currentMode = modelSelectMode
+				return m, nil
+			}
+		case tea.KeyCtrlT:
+			// Add global shortcut to switch to style selection mode (the answer input uses
+			// Ctrl+t to transpose characters)
+			if !m.typingText() {
+				m.currentMode = styleSelectMode
+				return m, nil
+			}
+		}
+
+		// Mode-specific key handlers
+		switch m.currentMode {
+		case selectionMode:
+			return m.updateSelectionMode(msg)
+		case questionMode:
+			return m.updateQuestionMode(msg)
+		case displayMode:
+			return m.updateDisplayMode(msg)
+		case apiKeyInputMode:
+			return m.updateAPIKeyInputMode(msg)
+		case modelSelectMode:
+			return m.updateModelSelectMode(msg)
+		case styleSelectMode:
+			return m.updateStyleSelectMode(msg)
+		case reviewMode:
+			return m.updateReviewMode(msg)
+		case historyMode:
+			return m.updateHistoryMode(msg)
+		case compareMode:
+			return m.updateCompareMode(msg)
+		case welcomeMode:
+			return m.updateWelcomeMode(msg)
+		case errorMode:
+			return m.updateErrorMode(msg)
+		}
+	}
+	return m, nil
+}
+
+// Rows taken by the parts of the screen around the content: the header and status bar lines,
+// and the content box's top and bottom padding
+const (
+	headerHeight      = 1
+	statusBarHeight   = 1
+	contentPaddingV   = 2
+	contentPaddingH   = 2
+	contentBorder     = 2
+	minViewportWidth  = 20
+	minViewportHeight = 3
+
+	// Below this size the views can't be laid out, so only a warning is shown
+	minTerminalWidth  = 40
+	minTerminalHeight = 12
+)
+
+// contentWidth returns the width inside the bordered content box
+func (m model) contentWidth() int {
+	return max(m.width-contentBorder-contentPaddingH, 0)
+}
+
+// layout sizes the viewport and inputs to fill the terminal. The viewport sits between the
+// header and status bar, with room below it for a status line and the display help.
+func (m *model) layout() {
+	width := m.width - contentPaddingH
+	helpHeight := lipgloss.Height(m.styles.Help.Width(max(width, 1)).Render(m.displayHelp()))
+	footerHeight := max(1+helpHeight, 3) // The follow-up and filename prompts take three lines
+	height := m.height - headerHeight - statusBarHeight - contentPaddingV - footerHeight
+
+	m.viewport.Width = max(width, minViewportWidth)
+	m.viewport.Height = max(height, minViewportHeight)
+	m.viewport.Style = lipgloss.NewStyle().
+		BorderStyle(lipgloss.RoundedBorder()).
+		BorderForeground(m.styleThemes[m.styleThemeIndex].Base).
+		PaddingLeft(2).
+		PaddingRight(2)
+
+	// Let the answer input use the width of the bordered content box, and keep the single-line
+	// inputs (plus their prompt and cursor) inside it
+	m.answerInput.SetWidth(max(m.contentWidth(), minViewportWidth))
+	m.promptInput.SetWidth(max(m.contentWidth(), minViewportWidth))
+	inputWidth := max(m.contentWidth()-3, 1)
+	m.apiKeyInput.Width = min(60, inputWidth)
+	m.apiBaseInput.Width = min(60, inputWidth)
+	m.modelNameInput.Width = min(60, inputWidth)
+	m.newModelInput.Width = min(40, inputWidth)
+	m.followUpInput.Width = min(60, inputWidth)
+	m.noteInput.Width = min(60, inputWidth)
+	m.fileNameInput.Width = min(60, inputWidth)
+	m.jiraInput.Width = min(60, inputWidth)
+	m.importInput.Width = min(60, inputWidth)
+}
+
+// tooSmall reports whether the terminal is below the size the views need. Until the first
+// tea.WindowSizeMsg arrives the size isn't known, so it isn't too small.
+func (m model) tooSmall() bool {
+	return m.height > 0 && (m.width < minTerminalWidth || m.height < minTerminalHeight)
+}
+
+// viewTooSmall replaces every view while the terminal is too small to lay them out
+func (m model) viewTooSmall() string {
+	msg := fmt.Sprintf("Terminal too small (%dx%d).\nTicketDuck needs at least %dx%d; enlarge the window, or press q to quit.",
+		m.width, m.height, minTerminalWidth, minTerminalHeight)
+	if m.confirmingQuit {
+		msg += "\n\nQuit without saving? (y/n)"
+	}
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		m.styles.ErrorHeaderText.Width(m.width).Align(lipgloss.Center).Render(msg))
+}
+
+// hasUnsavedWork reports whether quitting now would lose answers or output
+func (m model) hasUnsavedWork() bool {
+	switch m.currentMode {
+	case displayMode:
+		return (m.gptRawOutput != "" || m.generating) && !m.outputSaved
+	case questionMode, reviewMode, errorMode:
+		if strings.TrimSpace(m.answerInput.Value()) != "" {
+			return true
+		}
+		for _, answer := range m.answers {
+			if answer != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestQuit quits, asking first if there's unsaved work
+func (m model) requestQuit() (tea.Model, tea.Cmd) {
+	// Keep an unfinished form, including the answer being typed, so it can be resumed
+	if m.currentMode == questionMode {
+		m.saveFormDraft()
+	}
+	if m.hasUnsavedWork() {
+		m.confirmingQuit = true
+		return m, nil
+	}
+	return m.quit()
+}
+
+// quit cancels any request still running, so its retries and fallbacks stop, and quits
+func (m model) quit() (tea.Model, tea.Cmd) {
+	m = m.stopRequest()
+	return m, tea.Quit
+}
+
+// updateAPIKeyInputMode handles user input in the API key input mode
+func (m model) updateAPIKeyInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	// Get the currently selected model config
+	modelConfig := m.config.Models[m.selectedModel]
+	isLocalModel := modelConfig.Provider == llm.ProviderLocal
+
+	switch msg.Type {
+	case tea.KeyEnter:
+		// The API key is optional for local models; an empty field keeps using a key from the
+		// environment, if there is one
+		apiKey := strings.TrimSpace(m.apiKeyInput.Value())
+		fromEnv := false
+		if apiKey == "" && modelConfig.APIKeyFromEnv {
+			apiKey = modelConfig.APIKey
+			fromEnv = true
+		}
+
+		// Stay on the form, with the problems shown under their fields, until everything checks out
+		m.fieldErrors = m.validateAPIConfig(fromEnv)
+		if len(m.fieldErrors) > 0 {
+			for i, field := range m.apiConfigFields() {
+				if m.fieldErrors[field] != "" {
+					m.focusedInput = i
+					break
+				}
+			}
+			m.focusAPIConfigField()
+			return m, nil
+		}
+
+		if isLocalModel {
+			// For local models, we need to save the API base URL and model name
+			baseURL := strings.TrimSpace(m.apiBaseInput.Value())
+			modelName := m.selectedModelName()
+
+			logf("Saved local model %s at %s (API key: %t)", modelName, baseURL, apiKey != "")
+
+			// Update the existing entry so settings that aren't edited here are kept
+			modelConfig.ModelName = modelName
+			modelConfig.APIBaseURL = baseURL
+		} else {
+			// For remote models, we need to save the API key and model name
+			modelName := m.selectedModelName()
+
+			logf("Saved API key length: %d characters, model name: %s", len(apiKey), modelName)
+
+			modelConfig.ModelName = modelName
+		}
+		modelConfig.APIKey = apiKey
+		modelConfig.APIKeyFromEnv = fromEnv
+		m.config.Models[m.selectedModel] = modelConfig
+
+		// Save the config if the checkbox is checked
+		if m.saveConfig {
+			if err := m.store.Save(m.config); err != nil {
+				log.Printf("Failed to save config: %v\n", err)
+			}
+		}
+
+		// Switch to selection mode, or on to the theme during first-run setup, or back to
+		// the error screen when these settings were fixed after a failed request
+		m.currentMode = selectionMode
+		if m.onboarding {
+			m.currentMode = styleSelectMode
+		} else if m.requestErr != "" {
+			m.currentMode = errorMode
+		}
+		return m, nil
+
+	case tea.KeyTab, tea.KeyShiftTab, tea.KeyUp, tea.KeyDown:
+		// Move between input fields and save checkbox, wrapping around at either end.
+		// The fields are single-line, so the arrow keys move between them too.
+		leaving := m.focusedField()
+		fieldCount := len(m.apiConfigFields())
+		if msg.Type == tea.KeyShiftTab || msg.Type == tea.KeyUp {
+			m.focusedInput = (m.focusedInput - 1 + fieldCount) % fieldCount
+		} else {
+			m.focusedInput = (m.focusedInput + 1) % fieldCount
+		}
+		m.focusAPIConfigField()
+
+		// Once a key or server address has been entered, offer the provider's models as a pick list
+		if leaving == fieldAPIKey || leaving == fieldBaseURL {
+			return m.fetchModelList()
+		}
+		return m, nil
+
+	case tea.KeyLeft, tea.KeyRight:
+		// Move through the fetched model list when the model field is focused
+		if m.focusedField() == fieldModelName && len(m.availableModels) > 0 {
+			if msg.Type == tea.KeyLeft && m.modelListCursor > 0 {
+				m.modelListCursor--
+			} else if msg.Type == tea.KeyRight && m.modelListCursor < len(m.availableModels)-1 {
+				m.modelListCursor++
+			}
+			return m, nil
+		}
+
+	case tea.KeyCtrlT:
+		// Check the settings as entered, before saving them
+		return m.testConnection()
+
+	case tea.KeyCtrlR:
+		// Show or hide the API key
+		if m.apiKeyInput.EchoMode == textinput.EchoPassword {
+			m.apiKeyInput.EchoMode = textinput.EchoNormal
+		} else {
+			m.apiKeyInput.EchoMode = textinput.EchoPassword
+		}
+		return m, nil
+
+	case tea.KeySpace:
+		// Toggle save config option when focused on it
+		if m.focusedField() == fieldSaveConfig {
+			m.saveConfig = !m.saveConfig
+		}
+		return m, nil
+	}
+
+	// Handle input for the focused field
+	switch m.focusedField() {
+	case fieldAPIKey:
+		m.apiKeyInput, cmd = m.apiKeyInput.Update(msg)
+	case fieldBaseURL:
+		m.apiBaseInput, cmd = m.apiBaseInput.Update(msg)
+	case fieldModelName:
+		if len(m.availableModels) == 0 {
+			m.modelNameInput, cmd = m.modelNameInput.Update(msg)
+		}
+	}
+
+	return m, cmd
+}
+
+// minAPIKeyLength is the shortest key accepted for a cloud provider. Real keys are far
+// longer; this only catches a partial paste or a stray character.
+const minAPIKeyLength = 20
+
+// validateAPIConfig checks the values on the API configuration screen and returns a message
+// for each field that can't be saved as entered. keyFromEnv skips the key check when the key
+// comes from the environment rather than the form.
+func (m model) validateAPIConfig(keyFromEnv bool) map[apiConfigField]string {
+	errs := map[apiConfigField]string{}
+
+	if m.config.Models[m.selectedModel].Provider == llm.ProviderLocal {
+		baseURL := strings.TrimSpace(m.apiBaseInput.Value())
+		if baseURL == "" {
+			errs[fieldBaseURL] = "Enter the server address, e.g. http://localhost:11434"
+		} else if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs[fieldBaseURL] = "The address must start with http:// or https://, e.g. http://localhost:11434"
+		}
+	} else if !keyFromEnv {
+		apiKey := strings.TrimSpace(m.apiKeyInput.Value())
+		switch {
+		case apiKey == "":
+			errs[fieldAPIKey] = "Enter an API key"
+		case strings.ContainsAny(apiKey, " \t"):
+			errs[fieldAPIKey] = "The key contains spaces; check that it was pasted correctly"
+		case len(apiKey) < minAPIKeyLength:
+			errs[fieldAPIKey] = fmt.Sprintf("The key is only %d characters long; check that it was pasted in full", len(apiKey))
+		}
+	}
+
+	if m.selectedModelName() == "" {
+		errs[fieldModelName] = "Enter a model name"
+	}
+	return errs
+}
+
+// viewFieldError renders the validation message for a field, if it has one
+func (m model) viewFieldError(field apiConfigField) string {
+	if m.fieldErrors[field] == "" {
+		return ""
+	}
+	return m.styles.ErrorStatus(m.fieldErrors[field]) + "\n"
+}
+
+// apiConfigField identifies an input on the API configuration screen
+type apiConfigField int
+
+const (
+	fieldAPIKey apiConfigField = iota
+	fieldBaseURL
+	fieldModelName
+	fieldSaveConfig
+)
+
+// apiConfigFields returns the inputs shown for the selected model, in display order
+func (m model) apiConfigFields() []apiConfigField {
+	if m.config.Models[m.selectedModel].Provider == llm.ProviderLocal {
+		return []apiConfigField{fieldBaseURL, fieldAPIKey, fieldModelName, fieldSaveConfig}
+	}
+	return []apiConfigField{fieldAPIKey, fieldModelName, fieldSaveConfig}
+}
+
+// focusedField returns the input that m.focusedInput points at
+func (m model) focusedField() apiConfigField {
+	fields := m.apiConfigFields()
+	if m.focusedInput < 0 || m.focusedInput >= len(fields) {
+		return fields[0]
+	}
+	return fields[m.focusedInput]
+}
+
+// focusAPIConfigField moves the text cursor to the focused input
+func (m *model) focusAPIConfigField() {
+	m.apiKeyInput.Blur()
+	m.apiBaseInput.Blur()
+	m.modelNameInput.Blur()
+
+	switch m.focusedField() {
+	case fieldAPIKey:
+		m.apiKeyInput.Focus()
+	case fieldBaseURL:
+		m.apiBaseInput.Focus()
+	case fieldModelName:
+		m.modelNameInput.Focus()
+	}
+}
+
+// enterAPIKeyInputMode switches to the configuration screen for the selected model,
+// filling the inputs with its current settings.
+func (m model) enterAPIKeyInputMode() (model, tea.Cmd) {
+	modelConfig := m.config.Models[m.selectedModel]
+
+	m.currentMode = apiKeyInputMode
+	m.focusedInput = 0
+	m.availableModels = nil
+	m.modelListCursor = 0
+	m.modelListErr = ""
+	m.testStatus = ""
+	m.fieldErrors = nil
+
+	m.apiKeyInput.Reset()
+	m.apiKeyInput.EchoMode = textinput.EchoPassword
+	m.apiBaseInput.Reset()
+	m.modelNameInput.Reset()
+
+	// Keys from the environment are never shown, so they can't be saved by accident
+	if !modelConfig.APIKeyFromEnv {
+		m.apiKeyInput.SetValue(modelConfig.APIKey)
+	}
+	m.apiBaseInput.SetValue(modelConfig.APIBaseURL)
+	m.modelNameInput.SetValue(modelConfig.ModelName)
+
+	m.focusAPIConfigField()
+
+	// If a key or server address is already set, the model list can be loaded right away
+	return m.fetchModelList()
+}
+
+// modelListMsg carries the result of fetching a provider's model list
+type modelListMsg struct {
+	modelKey string // The entry in config.Models the list was fetched for
+	cacheKey string
+	models   []string
+	err      error
+}
+
+// fetchModelList returns a command that loads the provider's available models for the
+// selected entry. Lists are cached for the session, keyed by entry and credentials.
+func (m model) fetchModelList() (model, tea.Cmd) {
+	modelConfig := m.config.Models[m.selectedModel]
+
+	var cacheKey string
+	if modelConfig.Provider == llm.ProviderLocal {
+		// Local servers are identified by their address rather than a key
+		baseURL := strings.TrimSpace(m.apiBaseInput.Value())
+		if baseURL == "" {
+			return m, nil
+		}
+		modelConfig.APIBaseURL = baseURL
+		if apiKey := strings.TrimSpace(m.apiKeyInput.Value()); apiKey != "" {
+			modelConfig.APIKey = apiKey
+		}
+		cacheKey = m.selectedModel + "|" + baseURL + "|" + modelConfig.APIKey
+	} else {
+		apiKey := strings.TrimSpace(m.apiKeyInput.Value())
+		if apiKey == "" && modelConfig.APIKeyFromEnv {
+			apiKey = modelConfig.APIKey
+		}
+		if apiKey == "" {
+			return m, nil
+		}
+		modelConfig.APIKey = apiKey
+		cacheKey = m.selectedModel + "|" + apiKey
+	}
+
+	if models, ok := m.modelListCache[cacheKey]; ok {
+		m.setAvailableModels(models)
+		return m, nil
+	}
+
+	m.fetchingModels = true
+	m.modelListErr = ""
+	modelKey := m.selectedModel
+	proxyURL := m.config.ProxyURL
+	return m, func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		httpClient, err := newHTTPClient(proxyURL)
+		if err != nil {
+			return modelListMsg{modelKey: modelKey, cacheKey: cacheKey, err: err}
+		}
+		models, err := llm.ListModels(ctx, modelConfig, httpClient)
+		return modelListMsg{modelKey: modelKey, cacheKey: cacheKey, models: models, err: err}
+	}
+}
+
+// handleModelList stores a fetched model list and shows it if its entry is still being configured
+func (m model) handleModelList(msg modelListMsg) model {
+	m.fetchingModels = false
+
+	if msg.err != nil {
+		logf("Failed to fetch model list for %s: %v", msg.modelKey, msg.err)
+		if msg.modelKey == m.selectedModel {
+			// Fall back to the free-text field
+			m.availableModels = nil
+			m.modelListErr = msg.err.Error()
+		}
+		return m
+	}
+
+	logf("Fetched %d models for %s", len(msg.models), msg.modelKey)
+	m.modelListCache[msg.cacheKey] = msg.models
+
+	if msg.modelKey == m.selectedModel && m.currentMode == apiKeyInputMode {
+		m.setAvailableModels(msg.models)
+	}
+	return m
+}
+
+// connectionTestMsg carries the result of testing a model's settings
+type connectionTestMsg struct {
+	modelKey string
+	result   string
+	err      error
+}
+
+// pendingModelConfig returns the selected entry with the settings currently entered on the
+// configuration screen, without saving them
+func (m model) pendingModelConfig() llm.ModelConfig {
+	modelConfig := m.config.Models[m.selectedModel]
+	if apiKey := strings.TrimSpace(m.apiKeyInput.Value()); apiKey != "" {
+		modelConfig.APIKey = apiKey
+	} else if !modelConfig.APIKeyFromEnv {
+		modelConfig.APIKey = ""
+	}
+	if modelConfig.Provider == llm.ProviderLocal {
+		if baseURL := strings.TrimSpace(m.apiBaseInput.Value()); baseURL != "" {
+			modelConfig.APIBaseURL = baseURL
+		}
+	}
+	if modelName := m.selectedModelName(); modelName != "" {
+		modelConfig.ModelName = modelName
+	}
+	return modelConfig
+}
+
+// testConnection returns a command that sends a minimal request with the entered settings
+func (m model) testConnection() (model, tea.Cmd) {
+	if m.testingModel {
+		return m, nil
+	}
+	m.testingModel = true
+	m.testStatus = ""
+
+	modelKey := m.selectedModel
+	modelConfig := m.pendingModelConfig()
+	proxyURL := m.config.ProxyURL
+	return m, func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		httpClient, err := newHTTPClient(proxyURL)
+		if err != nil {
+			return connectionTestMsg{modelKey: modelKey, err: err}
+		}
+		result, err := llm.Probe(ctx, modelConfig, httpClient)
+		return connectionTestMsg{modelKey: modelKey, result: result, err: err}
+	}
+}
+
+// handleConnectionTest shows the result of a connection test
+func (m model) handleConnectionTest(msg connectionTestMsg) model {
+	m.testingModel = false
+	if msg.err != nil {
+		logf("Connection test for %s failed: %v", msg.modelKey, msg.err)
+	} else {
+		logf("Connection test for %s: %s", msg.modelKey, msg.result)
+	}
+
+	// The result is stale if another entry is being configured by now
+	if msg.modelKey != m.selectedModel || m.currentMode != apiKeyInputMode {
+		return m
+	}
+	if msg.err != nil {
+		m.testStatus = m.styles.ErrorStatus(fmt.Sprintf("Test failed: %v", msg.err))
+	} else {
+		m.testStatus = m.styles.SuccessStatus(msg.result)
+	}
+	return m
+}
+
+// setAvailableModels shows the given models as a pick list, starting at the configured model
+func (m *model) setAvailableModels(models []string) {
+	if len(models) == 0 {
+		m.availableModels = nil
+		m.modelListErr = "the provider returned no models"
+		return
+	}
+
+	m.availableModels = models
+	m.modelListErr = ""
+	m.modelListCursor = 0
+
+	current := strings.TrimSpace(m.modelNameInput.Value())
+	for i, name := range models {
+		if name == current {
+			m.modelListCursor = i
+			break
+		}
+	}
+}
+
+// selectedModelName returns the model picked from the list, or the free-text value if there is no list
+func (m model) selectedModelName() string {
+	if len(m.availableModels) > 0 {
+		return m.availableModels[m.modelListCursor]
+	}
+	return strings.TrimSpace(m.modelNameInput.Value())
+}
+
+func (m model) updateSelectionMode(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.Type == tea.KeySpace || msg.Type == tea.KeyEnter {
+			if m.currentMode == selectionMode {
+				// Toggle selection: since it's single-selection,
+				// selecting a new item deselects the previous one.
+				if m.selectedIndex == m.cursor {
+					// Deselect if already selected
+					m.selectedIndex = -1
+				} else {
+					m = m.startForm(m.cursor)
+				}
+			}
+			return m, nil
+		}
+		visible := m.visibleForms()
+		if i, ok := listHotkey(msg, len(visible)); ok {
+			m.cursor = visible[i]
+			return m.startForm(visible[i]), nil
+		}
+		switch m.keys.action(scopeSelection, msg.String()) {
+		case actionHistory:
+			return m.enterHistoryMode(), nil
+		case actionFilter:
+			m.formFilter = listFilter{typing: true}
+		case actionEditPrompt:
+			if len(m.formTypes) > 0 {
+				// Edit the prompt of the form at the cursor
+				m.editingPrompt = true
+				m.promptStatus = ""
+				m.promptInput.SetValue(m.config.formPrompt(m.formTypes[m.cursor]))
+				return m, m.promptInput.Focus()
+			}
+		case actionImport:
+			if len(m.formTypes) > 0 {
+				// Fill the form at the cursor from a file of answers
+				m.importingAnswers = true
+				m.importErr = ""
+				m.importInput.Reset()
+				return m, m.importInput.Focus()
+			}
+		case actionMoveUp:
+			m.cursor = moveInList(visible, m.cursor, -1)
+		case actionMoveDown:
+			m.cursor = moveInList(visible, m.cursor, 1)
+		}
+	}
+	return m, nil
+}
+
+// updateAnswerImport handles keys while the path of an answers file is being typed. A file that
+// loads opens the form at the cursor on its review screen, with the imported answers filled in.
+func (m model) updateAnswerImport(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.importingAnswers = false
+		m.importInput.Blur()
+		return m, nil
+	case tea.KeyEnter:
+		path := strings.TrimSpace(m.importInput.Value())
+		if path == "" {
+			return m, nil
+		}
+		if strings.HasPrefix(path, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, path[2:])
+			}
+		}
+
+		i := m.cursor
+		answers, err := loadAnswersFile(path, m.formTypes[i])
+		if err != nil {
+			logf("Failed to import answers: %v", err)
+			m.importErr = err.Error()
+			return m, nil
+		}
+		logf("Imported answers for %q from %s", m.formTypes[i].name, path)
+
+		m.importingAnswers = false
+		m.importInput.Blur()
+		m = m.startForm(i)
+		copy(m.answers, answers)
+		m.currentQuestion = len(m.answers) - 1
+		m.answerInput.Reset()
+		m.reviewCursor = 0
+		m.contextWarning = ""
+		m.previewingPrompt = false
+		m.choosingCompare = false
+		m.currentMode = reviewMode
+		m.saveFormDraft()
+		return m, nil
+	}
+
+	m.importInput, cmd = m.importInput.Update(msg)
+	return m, cmd
+}
+
+// updateFormFilter handles keys while a filter for the form types is being typed
+func (m model) updateFormFilter(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		if len(m.visibleForms()) == 0 {
+			return m, nil
+		}
+		return m.startForm(m.cursor), nil
+	case tea.KeyUp, tea.KeyDown:
+		delta := 1
+		if msg.Type == tea.KeyUp {
+			delta = -1
+		}
+		m.cursor = moveInList(m.visibleForms(), m.cursor, delta)
+		return m, nil
+	}
+
+	m.formFilter = m.formFilter.update(msg)
+	m.cursor = moveInList(m.visibleForms(), m.cursor, 0)
+	return m, nil
+}
+
+// visibleForms returns the indexes of the form types that match the filter
+func (m model) visibleForms() []int {
+	var visible []int
+	for i, form := range m.formTypes {
+		if m.formFilter.matches(form.name) {
+			visible = append(visible, i)
+		}
+	}
+	return visible
+}
+
+// startForm selects the form type at index i and moves on to its first question
+func (m model) startForm(i int) model {
+	m = m.abandonRequest() // A summary still on its way must not pull the user out of the form
+	m.formFilter = listFilter{}
+	m.selectedIndex = i
+	m.currentForm = m.formTypes[i]
+	m.currentMode = questionMode
+	m.requestErr = ""
+	m.answerWarning = ""
+	m.jumpingTo = false
+	m.answers = make([]string, len(m.currentForm.questions))
+	m.currentQuestion = 0
+	m.answerInput.Reset()
+	return m
+}
+
+// listHotkey returns the list index chosen by pressing 1-9, if the list has that many entries
+func listHotkey(msg tea.KeyMsg, n int) (int, bool) {
+	if msg.Type != tea.KeyRunes || len(msg.Runes) != 1 {
+		return 0, false
+	}
+	r := msg.Runes[0]
+	if r < '1' || r > '9' || int(r-'1') >= n {
+		return 0, false
+	}
+	return int(r - '1'), true
+}
+
+// listFilter narrows a list to the entries whose names contain the query
+type listFilter struct {
+	query  string
+	typing bool // True while the query is being typed
+}
+
+// matches reports whether name passes the filter, ignoring case
+func (f listFilter) matches(name string) bool {
+	return strings.Contains(strings.ToLower(name), strings.ToLower(f.query))
+}
+
+// update applies a key typed into the filter; Esc clears it
+func (f listFilter) update(msg tea.KeyMsg) listFilter {
+	switch msg.Type {
+	case tea.KeyEsc:
+		return listFilter{}
+	case tea.KeyBackspace:
+		if f.query != "" {
+			runes := []rune(f.query)
+			f.query = string(runes[:len(runes)-1])
+		}
+	case tea.KeySpace:
+		f.query += " "
+	case tea.KeyRunes:
+		f.query += string(msg.Runes)
+	}
+	return f
+}
+
+// filterHelp is the key help shown while a list filter is being typed
+const filterHelp = "Type to filter • ↑/↓ to move • Enter to select • Esc to clear"
+
+// view renders the filter line shown above a list while filtering
+func (f listFilter) view(s *Styles) string {
+	if !f.typing {
+		return ""
+	}
+	return s.Highlight.Render("/") + f.query + s.Help.Render("█") + "\n\n"
+}
+
+// moveInList moves cursor by delta among the visible indexes, staying within them.
+// A cursor on an entry that's been filtered out moves to the first visible one.
+func moveInList(visible []int, cursor, delta int) int {
+	if len(visible) == 0 {
+		return cursor
+	}
+	pos := -1
+	for i, idx := range visible {
+		if idx == cursor {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return visible[0]
+	}
+	pos += delta
+	if pos < 0 {
+		pos = 0
+	}
+	if pos >= len(visible) {
+		pos = len(visible) - 1
+	}
+	return visible[pos]
+}
+
+// hotkeyLabel returns the number shown before list entry i, blank past the ninth
+func hotkeyLabel(i int) string {
+	if i >= 9 {
+		return "  "
+	}
+	return fmt.Sprintf("%d.", i+1)
+}
+
+// updatePromptEditor handles keys while a form's prompt is being edited from the selection menu
+func (m model) updatePromptEditor(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	form := m.formTypes[m.cursor]
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.editingPrompt = false
+		m.promptInput.Blur()
+		m.promptStatus = ""
+		return m, nil
+
+	case tea.KeyCtrlS:
+		prompt := strings.TrimSpace(m.promptInput.Value())
+		if prompt == "" || prompt == strings.TrimSpace(form.prompt) {
+			// An empty prompt, or the built-in one, means no override
+			delete(m.config.PromptOverrides, form.name)
+		} else {
+			if m.config.PromptOverrides == nil {
+				m.config.PromptOverrides = make(map[string]string)
+			}
+			m.config.PromptOverrides[form.name] = prompt
+		}
+		m.editingPrompt = false
+		m.promptInput.Blur()
+		return m.savePromptOverrides(fmt.Sprintf("Saved the %s prompt", form.name)), nil
+
+	case tea.KeyCtrlR:
+		delete(m.config.PromptOverrides, form.name)
+		m.editingPrompt = false
+		m.promptInput.Blur()
+		return m.savePromptOverrides(fmt.Sprintf("Reset the %s prompt to the default", form.name)), nil
+	}
+
+	var cmd tea.Cmd
+	m.promptInput, cmd = m.promptInput.Update(msg)
+	return m, cmd
+}
+
+// savePromptOverrides writes the prompt overrides to the config file and reports the result
+func (m model) savePromptOverrides(done string) model {
+	if err := m.store.Save(m.config); err != nil {
+		logf("Failed to save prompt override: %v", err)
+		m.promptStatus = m.styles.ErrorStatus(fmt.Sprintf("Save failed: %v", err))
+		return m
+	}
+	logf("%s", done)
+	m.promptStatus = m.styles.SuccessStatus(done)
+	return m
+}
+
+func (m model) updateQuestionMode(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		// A warning about a required question lasts until the next key
+		m.answerWarning = ""
+
+		switch msg.Type {
+		case tea.KeyCtrlD: // ← Submit the answer on Ctrl+D; Enter inserts a newline
+			// Required questions can't be left blank
+			if m.currentForm.isRequired(m.currentQuestion) && strings.TrimSpace(m.answerInput.Value()) == "" {
+				m.answerWarning = "This question is required; type an answer to continue"
+				return m, nil
+			}
+
+			// Save the current input as an answer
+			m.answers[m.currentQuestion] = strings.TrimSpace(m.answerInput.Value())
+
+			// Move on to the next question or finish
+			m = advanceQuestion(m)
+			m.saveFormDraft()
+			return m, nil
+		case tea.KeyCtrlS: // ← Skip question on Ctrl+S
+			if m.currentForm.isRequired(m.currentQuestion) {
+				m.answerWarning = "This question is required and can't be skipped"
+				return m, nil
+			}
+
+			// Don't store anything (or store empty string).
+			m.answers[m.currentQuestion] = ""
+
+			m = advanceQuestion(m)
+			m.saveFormDraft()
+			return m, nil
+		case tea.KeyCtrlB, tea.KeyShiftTab: // ← Go back one question
+			if m.currentQuestion > 0 {
+				// Keep whatever was typed so far, then restore the previous answer for editing
+				m.answers[m.currentQuestion] = strings.TrimSpace(m.answerInput.Value())
+				m.currentQuestion--
+				m.answerInput.SetValue(m.answers[m.currentQuestion])
+				m.saveFormDraft()
+			}
+			return m, nil
+		case tea.KeyCtrlG: // ← Go to a question by number
+			m.jumpingTo = true
+			m.jumpQuery = ""
+			return m, nil
+		case tea.KeyCtrlV: // ← Paste from the system clipboard at the cursor
+			text, err := clipboard.ReadAll()
+			if err != nil {
+				logf("Failed to read the clipboard: %v", err)
+				return m, nil
+			}
+			m.answerInput.InsertString(sanitizePastedText(text))
+			return m, nil
+		case tea.KeyCtrlU: // ← Clear the current line; the textarea alone only deletes up to the cursor
+			m.answerInput.CursorEnd()
+			m.answerInput, cmd = m.answerInput.Update(msg)
+			return m, cmd
+		}
+
+		// Everything else is regular text editing, handled by the textarea (including Ctrl+w,
+		// which deletes the word before the cursor)
+		m.answerInput, cmd = m.answerInput.Update(msg)
+	}
+	return m, cmd
+}
+
+// advanceQuestion moves to the next question, restoring any answer it already has,
+// or moves on to the review screen after the last question.
+func advanceQuestion(m model) model {
+	// Edits started from the review screen go straight back to it
+	if m.editingFromReview {
+		m.editingFromReview = false
+		m.reviewCursor = m.currentQuestion
+		m.answerInput.Reset()
+		m.contextWarning = ""
+		m.previewingPrompt = false
+		m.choosingCompare = false
+		m.currentMode = reviewMode
+		return m
+	}
+
+	if m.currentQuestion < len(m.currentForm.questions)-1 {
+		m.currentQuestion++
+		m.answerInput.SetValue(m.answers[m.currentQuestion])
+		return m
+	}
+
+	m.answerInput.Reset()
+	m.reviewCursor = 0
+	m.contextWarning = ""
+	m.previewingPrompt = false
+	m.choosingCompare = false
+	m.currentMode = reviewMode
+	return m
+}
+
+// updateReviewMode handles user input on the review screen shown before the request is sent
+func (m model) updateReviewMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.reviewWarning = ""
+
+	// While the context window warning is shown, only ask whether to send anyway
+	if m.contextWarning != "" {
+		switch msg.String() {
+		case "y", "Y":
+			m.contextWarning = ""
+			return handleFormCompletion(m)
+		case "n", "N":
+			m.contextWarning = ""
+		}
+		return m, nil
+	}
+
+	if m.previewingPrompt {
+		return m.updatePromptPreview(msg)
+	}
+	if m.choosingCompare {
+		return m.updateComparePicker(msg)
+	}
+
+	if msg.Type == tea.KeyEnter {
+		// Edit the selected answer using the regular question input
+		m.currentQuestion = m.reviewCursor
+		m.answerInput.SetValue(m.answers[m.currentQuestion])
+		m.editingFromReview = true
+		m.currentMode = questionMode
+		return m, nil
+	}
+
+	switch m.keys.action(scopeReview, msg.String()) {
+	case actionMoveUp:
+		if m.reviewCursor > 0 {
+			m.reviewCursor--
+		}
+	case actionMoveDown:
+		if m.reviewCursor < len(m.currentForm.questions)-1 {
+			m.reviewCursor++
+		}
+	case actionPreview:
+		// Show the prompt without sending it
+		return m.showPromptPreview(), nil
+	case actionCompare:
+		// Pick a second model to send the same answers to
+		m.choosingCompare = true
+		m.compareCursor = 0
+		m.compareChoices = nil
+		for _, key := range m.configuredModelKeys() {
+			if key != m.config.ActiveModel {
+				m.compareChoices = append(m.compareChoices, key)
+			}
+		}
+	case actionCycleLength:
+		m.config.Length = nextOption(lengthOptions, m.config.Length)
+		m.saveSummaryStyle()
+	case actionCycleTone:
+		m.config.Tone = nextOption(toneOptions, m.config.Tone)
+		m.saveSummaryStyle()
+	case actionSend:
+		return sendAnswers(m)
+	}
+	return m, nil
+}
+
+// updateComparePicker handles keys while choosing the model to compare the active one with
+func (m model) updateComparePicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.choosingCompare = false
+		return m, nil
+	case "enter":
+		if len(m.compareChoices) > 0 {
+			m, ok := m.requireAnswers()
+			if !ok {
+				return m, nil
+			}
+			return startComparison(m, m.compareChoices[m.compareCursor])
+		}
+		return m, nil
+	}
+
+	switch m.keys.action(scopeReview, msg.String()) {
+	case actionMoveUp:
+		if m.compareCursor > 0 {
+			m.compareCursor--
+		}
+	case actionMoveDown:
+		if m.compareCursor < len(m.compareChoices)-1 {
+			m.compareCursor++
+		}
+	}
+	return m, nil
+}
+
+// saveSummaryStyle saves the length and tone choices so they're used next time too
+func (m model) saveSummaryStyle() {
+	logf("Summary length: %s, tone: %s", optionOrDefault(lengthOptions, m.config.Length), optionOrDefault(toneOptions, m.config.Tone))
+	if err := m.store.Save(m.config); err != nil {
+		logf("Failed to save config: %v", err)
+	}
+}
+
+// sendAnswers sends the answers to the LLM, checking first that they're likely to fit
+func sendAnswers(m model) (tea.Model, tea.Cmd) {
+	m, ok := m.requireAnswers()
+	if !ok {
+		return m, nil
+	}
+	activeModelConfig := m.config.Models[m.config.ActiveModel]
+	prompt := combinePrompt(m.config.requestPrompt(m.currentForm), buildSelectedMarkdown(m))
+	if warning := contextWindowWarning(activeModelConfig, prompt); warning != "" {
+		logf("Context window warning: %s", warning)
+		m.contextWarning = warning
+		return m, nil
+	}
+	m.previewingPrompt = false
+	return handleFormCompletion(m)
+}
+
+// updatePromptPreview handles keys while the review screen shows the prompt that would be sent.
+// Nothing is sent until the send key; Esc goes back to the answers.
+func (m model) updatePromptPreview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.Type == tea.KeyEsc {
+		m.previewingPrompt = false
+		return m, nil
+	}
+	if m.keys.action(scopeReview, msg.String()) == actionSend {
+		return sendAnswers(m)
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+// showPromptPreview puts the exact prompt that would be sent into the viewport
+func (m model) showPromptPreview() model {
+	prompt := combinePrompt(m.config.requestPrompt(m.currentForm), buildSelectedMarkdown(m))
+	m.viewport.SetContent(lipgloss.NewStyle().Width(viewportContentWidth(&m.viewport)).Render(prompt))
+	m.viewport.GotoTop()
+	m.previewingPrompt = true
+	return m
+}
+
+func (m model) updateDisplayMode(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.savingToFile {
+			return m.updateSaveFilePrompt(msg)
+		}
+		if m.askingFollowUp {
+			return m.updateFollowUpPrompt(msg)
+		}
+		if m.askingNote {
+			return m.updateNotePrompt(msg)
+		}
+		if m.askingJiraKey {
+			return m.updateJiraPrompt(msg)
+		}
+
+		// Keys go through the keymap. The first key of a sequence such as gg waits for the
+		// second; if the two aren't bound together, the second key counts on its own.
+		key := msg.String()
+		if m.pendingKey != "" {
+			if sequence := m.pendingKey + " " + key; m.keys.action(scopeDisplay, sequence) != "" {
+				key = sequence
+			}
+			m.pendingKey = ""
+		}
+		if m.keys.startsSequence(scopeDisplay, key) {
+			m.pendingKey = key
+			return m, nil
+		}
+
+		switch action := m.keys.action(scopeDisplay, key); action {
+		// Scroll up one line
+		case actionScrollUp:
+			m.viewport.LineUp(1)
+			return m, nil
+
+		// Scroll down one line
+		case actionScrollDown:
+			m.viewport.LineDown(1)
+			return m, nil
+
+		// Page up: scroll up by the height of the viewport.
+		case actionPageUp:
+			m.viewport.ViewUp()
+			return m, nil
+
+		// Page down: scroll down by the height of the viewport.
+		case actionPageDown:
+			m.viewport.ViewDown()
+			return m, nil
+
+		// Half page down/up, as in pagers and vim
+		case actionHalfPageDown:
+			m.viewport.HalfViewDown()
+			return m, nil
+
+		case actionHalfPageUp:
+			m.viewport.HalfViewUp()
+			return m, nil
+
+		// Jump to bottom
+		case actionBottom:
+			m.viewport.GotoBottom()
+			return m, nil
+
+		// Jump to top
+		case actionTop:
+			m.viewport.GotoTop()
+			return m, nil
+
+		// Copy the summary to the clipboard in the chosen format
+		case actionCopy:
+			plainText := convertMarkdown(stripansi.Strip(m.gptRawOutput), m.copyFormat)
+			viaTerminal, err := m.copyToClipboard(plainText)
+			if err != nil {
+				logf("Failed to copy to clipboard: %v", err)
+				m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Copy failed: %v", err))
+			} else if viaTerminal {
+				m.outputSaved = true
+				m.displayStatus = m.styles.SuccessStatus(fmt.Sprintf("Sent to the terminal clipboard as %s (needs OSC 52 support)", m.copyFormat.name()))
+			} else {
+				m.outputSaved = true
+				m.displayStatus = m.styles.SuccessStatus(fmt.Sprintf("Copied as %s", m.copyFormat.name()))
+			}
+			return m, nil
+
+		// Cycle the format the summary is copied in
+		case actionCopyFormat:
+			m.copyFormat = m.copyFormat.next()
+			m.displayStatus = m.styles.StatusHeader.Render(fmt.Sprintf("Copy format: %s", m.copyFormat.name()))
+			return m, nil
+
+		// Fix the settings of a model whose API key was rejected
+		case actionFixSettings:
+			if m.rejectedKey != "" {
+				m.selectedModel = m.rejectedKey
+				m.rejectedKey = ""
+				return m.enterAPIKeyInputMode()
+			}
+			return m, nil
+
+		// Turn wrapping of long lines off or on; the choice is kept in the config
+		case actionToggleWrap:
+			offset := m.viewport.YOffset
+			m.config.NoWrap = !m.config.NoWrap
+			if err := m.renderContent(); err != nil {
+				logf("Error switching word wrap: %v", err)
+			}
+			m.viewport.SetYOffset(offset)
+			if err := m.store.Save(m.config); err != nil {
+				logf("Failed to save config: %v", err)
+			}
+			if m.config.NoWrap {
+				m.displayStatus = m.styles.StatusHeader.Render(fmt.Sprintf("Word wrap off: %s / %s to scroll sideways", m.keys.label(actionScrollLeft), m.keys.label(actionScrollRight)))
+			} else {
+				m.displayStatus = m.styles.StatusHeader.Render("Word wrap on")
+			}
+			return m, nil
+
+		// Scroll sideways through long lines while wrapping is off
+		case actionScrollLeft:
+			if m.config.NoWrap {
+				m.viewport.ScrollLeft(horizontalScrollStep)
+			}
+			return m, nil
+
+		case actionScrollRight:
+			if m.config.NoWrap {
+				m.viewport.ScrollRight(horizontalScrollStep)
+			}
+			return m, nil
+
+		// Toggle between the rendered output and the raw markdown source
+		case actionToggleMarkdown:
+			offset := m.viewport.YOffset
+			m.showRawMarkdown = !m.showRawMarkdown
+			if err := m.renderContent(); err != nil {
+				logf("Error switching markdown view: %v", err)
+			}
+			m.viewport.SetYOffset(offset)
+			return m, nil
+
+		// Regenerate the summary from the same answers, optionally skipping the response cache
+		case actionRegenerate, actionRegenerateUncached:
+			return regenerateSummary(m, action == actionRegenerateUncached)
+
+		// Edit the summary in $EDITOR
+		case actionEdit:
+			return editSummary(m)
+
+		// Ask for changes to the summary
+		case actionFollowUp:
+			if m.generating || len(m.conversation) == 0 {
+				return m, nil
+			}
+			m.askingFollowUp = true
+			m.displayStatus = ""
+			m.followUpInput.Reset()
+			return m, m.followUpInput.Focus()
+
+		// Add, edit or remove the analyst note
+		case actionNote:
+			if m.generating || m.gptRawOutput == "" {
+				return m, nil
+			}
+			m.askingNote = true
+			m.displayStatus = ""
+			m.noteInput.SetValue(m.analystNote)
+			m.noteInput.CursorEnd()
+			return m, m.noteInput.Focus()
+
+		// Post the summary to the Slack webhook
+		case actionPostSlack:
+			return m.postSummaryToSlack()
+
+		// Create a GitHub issue from the summary
+		case actionGitHubIssue:
+			return m.createIssueFromSummary()
+
+		// Create a Jira issue from the summary, or add it to one as a comment
+		case actionJira:
+			return m.openJiraPrompt()
+
+		// Save the output to a markdown file
+		case actionSave:
+			m.savingToFile = true
+			m.displayStatus = ""
+			m.fileNameInput.SetValue(defaultSummaryFileName(m.currentForm.name, time.Now()))
+			m.fileNameInput.CursorEnd()
+			return m, m.fileNameInput.Focus()
+
+		default:
+			// For any other keys, ignore or implement additional behavior.
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+// updateFollowUpPrompt handles user input while the follow-up instruction prompt is open
+func (m model) updateFollowUpPrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.askingFollowUp = false
+		m.followUpInput.Blur()
+		return m, nil
+
+	case tea.KeyEnter:
+		instruction := strings.TrimSpace(m.followUpInput.Value())
+		if instruction == "" {
+			return m, nil
+		}
+		m.askingFollowUp = false
+		m.followUpInput.Blur()
+		return startFollowUp(m, instruction)
+	}
+
+	var cmd tea.Cmd
+	m.followUpInput, cmd = m.followUpInput.Update(msg)
+	return m, cmd
+}
+
+// horizontalScrollStep is how many columns h and l scroll by when word wrap is off
+const horizontalScrollStep = 8
+
+// analystNoteHeading introduces the analyst's note at the end of a summary
+const analystNoteHeading = "## Analyst Note"
+
+// analystNoteSection returns the markdown appended to a summary for note
+func analystNoteSection(note string) string {
+	return "\n\n" + analystNoteHeading + "\n\n" + note + "\n"
+}
+
+// updateNotePrompt handles user input while the analyst note prompt is open
+func (m model) updateNotePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.askingNote = false
+		m.noteInput.Blur()
+		return m, nil
+
+	case tea.KeyEnter:
+		m.askingNote = false
+		m.noteInput.Blur()
+		return m.setAnalystNote(strings.TrimSpace(m.noteInput.Value())), nil
+	}
+
+	var cmd tea.Cmd
+	m.noteInput, cmd = m.noteInput.Update(msg)
+	return m, cmd
+}
+
+// setAnalystNote replaces the note at the end of the summary, so it's part of what is copied,
+// saved and posted. An empty note removes it. The note is never sent to the model.
+func (m model) setAnalystNote(note string) model {
+	if note == m.analystNote {
+		return m
+	}
+
+	summary := m.gptRawOutput
+	if m.analystNote != "" {
+		summary = strings.TrimSuffix(summary, analystNoteSection(m.analystNote))
+	}
+	switch {
+	case note == "":
+		m.displayStatus = m.styles.SuccessStatus("Note removed")
+	case m.analystNote == "":
+		summary = strings.TrimRight(summary, "\n")
+		m.displayStatus = m.styles.SuccessStatus("Note added")
+	default:
+		m.displayStatus = m.styles.SuccessStatus("Note updated")
+	}
+	if note != "" {
+		summary += analystNoteSection(note)
+	}
+
+	m.analystNote = note
+	m.gptRawOutput = summary
+	m.content = appendSummary(m.requestMarkdown, m.gptRawOutput)
+	m.outputSaved = false
+	if err := m.renderContent(); err != nil {
+		logf("Error rendering summary with note: %v", err)
+	}
+	if note != "" {
+		m.viewport.GotoBottom()
+	}
+	return m
+}
+
+// updateSaveFilePrompt handles user input while the save-to-file prompt is open
+func (m model) updateSaveFilePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		// Cancel without writing anything
+		m.savingToFile = false
+		m.fileNameInput.Blur()
+		return m, nil
+
+	case tea.KeyEnter:
+		m.savingToFile = false
+		m.fileNameInput.Blur()
+
+		fileName := strings.TrimSpace(m.fileNameInput.Value())
+		if fileName == "" {
+			fileName = defaultSummaryFileName(m.currentForm.name, time.Now())
+		}
+
+		output := m.gptRawOutput
+		if output == "" {
+			output = m.content
+		}
+
+		path, err := saveSummaryToFile(fileName, m.summaryFrontMatter()+output)
+		if err != nil {
+			logf("Failed to save summary: %v", err)
+			m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Save failed: %v", err))
+		} else {
+			logf("Saved summary to %s", path)
+			m.outputSaved = true
+			m.displayStatus = m.styles.SuccessStatus(fmt.Sprintf("Saved to %s", path))
+		}
+		return m, nil
+	}
+
+	m.fileNameInput, cmd = m.fileNameInput.Update(msg)
+	return m, cmd
+}
+
+// updateModelSelectMode handles user input in the model selection mode
+func (m model) updateModelSelectMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.modelStatus = "" // Shown until the next key
+	if m.addingModel {
+		return m.updateNewModelPrompt(msg)
+	}
+
+	// While confirming a delete, only y deletes; anything else cancels
+	if m.deletingModel != "" {
+		if msg.String() == "y" || msg.String() == "Y" {
+			m = m.deleteModelConfig(m.deletingModel)
+		}
+		m.deletingModel = ""
+		return m, nil
+	}
+
+	visible := m.visibleModels()
+	if msg.Type == tea.KeySpace || msg.Type == tea.KeyEnter {
+		if len(visible) > 0 {
+			return m.chooseModel()
+		}
+		return m, nil
+	}
+	if i, ok := listHotkey(msg, len(visible)); ok {
+		m.modelCursor = visible[i]
+		return m.chooseModel()
+	}
+
+	switch m.keys.action(scopeModelSelect, msg.String()) {
+	case actionMoveUp:
+		m.modelCursor = moveInList(visible, m.modelCursor, -1)
+	case actionMoveDown:
+		m.modelCursor = moveInList(visible, m.modelCursor, 1)
+	case actionFilter:
+		m.modelFilter = listFilter{typing: true}
+	case actionConfigure:
+		// Configure the model at the current cursor position
+		m.selectedModel = m.modelKeys[m.modelCursor]
+		m.config.ActiveModel = m.selectedModel
+		return m.enterAPIKeyInputMode()
+	case actionAddProvider:
+		// Add a new provider entry
+		m.addingModel = true
+		m.newModelTemplate = 0
+		m.newModelErr = ""
+		m.newModelInput.Reset()
+		m.newModelInput.Focus()
+		return m, textinput.Blink
+	case actionDelete:
+		// Delete the provider at the cursor, after confirmation; built-in entries stay
+		if len(m.modelKeys) > 0 && !isBuiltinModel(m.modelKeys[m.modelCursor]) {
+			m.deletingModel = m.modelKeys[m.modelCursor]
+		}
+	case actionNextProfile:
+		return m.nextProfile(), nil
+	}
+	return m, nil
+}
+
+// nextProfile switches to the profile after the current one, reloading its providers and active model
+func (m model) nextProfile() model {
+	m.profiles = listProfiles(m.config.dir)
+	if slices.Index(m.profiles, m.config.profile) < 0 {
+		// Started with --profile but not saved yet
+		m.profiles = append(m.profiles, m.config.profile)
+	}
+	if len(m.profiles) < 2 {
+		return m
+	}
+	profile := m.profiles[(slices.Index(m.profiles, m.config.profile)+1)%len(m.profiles)]
+
+	store := fileConfigStore{dir: m.config.dir, profile: profile}
+	config, err := store.Load()
+	if err != nil {
+		logf("Failed to load profile %s: %v", profileLabel(profile), err)
+		m.modelStatus = m.styles.ErrorStatus(fmt.Sprintf("Failed to load profile %s: %v", profileLabel(profile), err))
+		return m
+	}
+	logf("Switched to profile %s", profileLabel(profile))
+	m.modelStatus = ""
+	if warnings := takeWarnings(); len(warnings) > 0 {
+		m.modelStatus = m.styles.ErrorStatus("Warning: " + strings.Join(warnings, "; "))
+	}
+	m.config = config
+	m.store = store
+	m.spinner.Spinner, _ = config.spinnerStyle()
+	m.keys = config.keymap()
+	m.modelKeys = sortedModelKeys(config)
+	m.modelCursor = 0
+	if i := slices.Index(m.modelKeys, config.ActiveModel); i >= 0 {
+		m.modelCursor = i
+	}
+	m.selectedModel = config.ActiveModel
+	m.modelListCache = nil // Keys can point at different servers in another profile
+	return m
+}
+
+// updateModelFilter handles keys while a filter for the providers is being typed
+func (m model) updateModelFilter(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		if len(m.visibleModels()) == 0 {
+			return m, nil
+		}
+		return m.chooseModel()
+	case tea.KeyUp, tea.KeyDown:
+		delta := 1
+		if msg.Type == tea.KeyUp {
+			delta = -1
+		}
+		m.modelCursor = moveInList(m.visibleModels(), m.modelCursor, delta)
+		return m, nil
+	}
+
+	m.modelFilter = m.modelFilter.update(msg)
+	m.modelCursor = moveInList(m.visibleModels(), m.modelCursor, 0)
+	return m, nil
+}
+
+// visibleModels returns the indexes of the providers that match the filter
+func (m model) visibleModels() []int {
+	var visible []int
+	for i, key := range m.modelKeys {
+		if m.modelFilter.matches(key) {
+			visible = append(visible, i)
+		}
+	}
+	return visible
+}
+
+// chooseModel makes the model at the cursor the active one, asking for its settings
+// first if it isn't configured yet
+func (m model) chooseModel() (tea.Model, tea.Cmd) {
+	m.modelFilter = listFilter{}
+	m.selectedModel = m.modelKeys[m.modelCursor]
+	m.config.ActiveModel = m.selectedModel
+
+	// Save the config
+	if err := m.store.Save(m.config); err != nil {
+		log.Printf("Failed to save config: %v\n", err)
+	}
+
+	// Check if the selected model needs configuration
+	selectedModelConfig := m.config.Models[m.selectedModel]
+	if (selectedModelConfig.Provider != llm.ProviderLocal && selectedModelConfig.APIKey == "") ||
+		(selectedModelConfig.Provider == llm.ProviderLocal && selectedModelConfig.APIBaseURL == "") {
+		// Go to API key input mode if needed
+		return m.enterAPIKeyInputMode()
+	}
+
+	// Otherwise go to form selection mode, or on to the theme during first-run setup.
+	// A model picked after a failed request goes back to the error screen to retry with it.
+	m.currentMode = selectionMode
+	if m.onboarding {
+		m.currentMode = styleSelectMode
+	} else if m.requestErr != "" {
+		m.currentMode = errorMode
+	}
+	return m, nil
+}
+
+// updateStyleSelectMode handles user input in the style selection mode
+func (m model) updateStyleSelectMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.action(scopeStyleSelect, msg.String()) {
+	case actionMoveUp:
+		if m.styleThemeIndex > 0 {
+			m.styleThemeIndex--
+		}
+		return m, nil
+	case actionMoveDown:
+		if m.styleThemeIndex < len(m.styleThemes)-1 {
+			m.styleThemeIndex++
+		}
+		return m, nil
+	}
+
+	switch msg.Type {
+	case tea.KeyEnter:
+		// Apply the selected theme
+		m.styles = NewStyles(lipgloss.DefaultRenderer(), m.styleThemes[m.styleThemeIndex])
+
+		// Remember the theme for next time
+		m.config.ActiveTheme = m.styleThemes[m.styleThemeIndex].Name
+		if m.onboarding {
+			// The last step of first-run setup; the config is saved with it
+			return m.finishOnboarding(), nil
+		}
+		if err := m.store.Save(m.config); err != nil {
+			log.Printf("Failed to save config: %v\n", err)
+		}
+		m.currentMode = selectionMode // Return to selection mode
+	case tea.KeyEsc:
+		m.currentMode = selectionMode // Return to selection mode
+	}
+	return m, nil
+}
+
+// updateHistoryMode handles user input in the list of past summaries
+func (m model) updateHistoryMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// While confirming a delete, only y deletes; anything else cancels
+	if m.deletingHistory {
+		if msg.String() == "y" || msg.String() == "Y" {
+			m = m.deleteHistoryEntry()
+		}
+		m.deletingHistory = false
+		return m, nil
+	}
+
+	if msg.Type == tea.KeyEnter {
+		if len(m.historyEntries) > 0 {
+			return m.openHistoryEntry(m.historyEntries[m.historyCursor]), nil
+		}
+		return m, nil
+	}
+
+	switch m.keys.action(scopeHistory, msg.String()) {
+	case actionMoveUp:
+		if m.historyCursor > 0 {
+			m.historyCursor--
+		}
+	case actionMoveDown:
+		if m.historyCursor < len(m.historyEntries)-1 {
+			m.historyCursor++
+		}
+	case actionDelete:
+		if len(m.historyEntries) > 0 {
+			m.deletingHistory = true
+		}
+	}
+	return m, nil
+}
+
+// updateCompareMode handles user input while two models' results are shown side by side
+func (m model) updateCompareMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.action(scopeCompare, msg.String()) {
+	case actionSwitchPane:
+		m.compareFocus = 1 - m.compareFocus
+		m.layoutComparePanes()
+		return m, nil
+
+	// Copy the focused pane in the chosen format
+	case actionCopy:
+		pane := m.comparePanes[m.compareFocus]
+		if pane.pending || pane.err != nil {
+			return m, nil
+		}
+		viaTerminal, err := m.copyToClipboard(convertMarkdown(pane.output, m.copyFormat))
+		switch {
+		case err != nil:
+			logf("Failed to copy to clipboard: %v", err)
+			m.compareStatus = m.styles.ErrorStatus(fmt.Sprintf("Copy failed: %v", err))
+		case viaTerminal:
+			m.compareStatus = m.styles.SuccessStatus(fmt.Sprintf("Sent the %s summary to the terminal clipboard as %s (needs OSC 52 support)", pane.modelKey, m.copyFormat.name()))
+		default:
+			m.compareStatus = m.styles.SuccessStatus(fmt.Sprintf("Copied the %s summary as %s", pane.modelKey, m.copyFormat.name()))
+		}
+		return m, nil
+
+	// Cycle the format the copy key copies in
+	case actionCopyFormat:
+		m.copyFormat = m.copyFormat.next()
+		m.compareStatus = m.styles.StatusHeader.Render(fmt.Sprintf("Copy format: %s", m.copyFormat.name()))
+		return m, nil
+	}
+
+	// Anything else scrolls the focused pane
+	var cmd tea.Cmd
+	m.comparePanes[m.compareFocus].viewport, cmd = m.comparePanes[m.compareFocus].viewport.Update(msg)
+	return m, cmd
+}
+
+// --- [View] ----------------------------------------------------------------
+
+func (m model) View() string {
+	if m.tooSmall() {
+		return m.viewTooSmall()
+	}
+
+	var content string
+
+	switch m.currentMode {
+	case selectionMode:
+		content = m.viewSelectionMode()
+	case questionMode:
+		content = m.viewQuestionMode()
+	case displayMode:
+		content = m.viewDisplayMode()
+	case apiKeyInputMode:
+		content = m.viewAPIKeyInputMode()
+	case modelSelectMode:
+		content = m.viewModelSelectMode()
+	case styleSelectMode:
+		content = m.viewStyleSelectMode()
+	case reviewMode:
+		content = m.viewReviewMode()
+	case historyMode:
+		content = m.viewHistoryMode()
+	case compareMode:
+		content = m.viewCompareMode()
+	case welcomeMode:
+		content = m.viewWelcomeMode()
+	case errorMode:
+		content = m.viewErrorMode()
+	default:
+		content = "Unknown mode."
+	}
+
+	// Show where first-run setup is up to
+	if step := m.onboardingStep(); step != "" {
+		content = m.styles.StatusHeader.Render(step) + m.styles.Help.Render(" • Esc to skip the rest of setup") + "\n\n" + content
+	}
+
+	// The help overlay takes the place of the current view until it's dismissed
+	if m.showHelp {
+		content = m.viewHelp()
+	}
+
+	// A recovered panic replaces the view until Esc is pressed
+	if m.crashErr != "" {
+		content = m.appErrorBoundaryView("Something went wrong") + "\n\n" +
+			m.styles.ErrorStatus(m.crashErr) + "\n\n" +
+			m.styles.Help.Render("Esc to return to the menu • q to quit") + "\n"
+	}
+
+	// Combine all components using vertical layout
+	theme := m.styleThemes[m.styleThemeIndex]
+
+	// Create the header with a simple divider across the terminal
+	header := m.boundaryView(m.styles.HeaderText.Render("TicketDuck"), theme.Base, m.width)
+
+	// Create the status bar
+	statusBar := m.renderStatusBar()
+
+	// Ask before quitting with unsaved work
+	if m.confirmingQuit {
+		statusBar = m.styles.ErrorHeaderText.Render("Quit without saving? (y/n)") + "\n" + statusBar
+	}
+
+	// Only add border to content if not in display mode (since viewport has its own border)
+	contentStyle := lipgloss.NewStyle().Padding(1)
+	bordered := (m.currentMode != displayMode && m.currentMode != compareMode && !(m.currentMode == reviewMode && m.previewingPrompt)) || m.showHelp
+	if bordered {
+		contentStyle = contentStyle.
+			BorderStyle(lipgloss.RoundedBorder()).
+			BorderForeground(theme.Base)
+	}
+
+	// Fill the height between the header and status bar so the status bar stays at the bottom.
+	// Content too tall for the terminal is cut at the bottom rather than pushing the header off.
+	if m.height > 0 {
+		bodyHeight := max(m.height-lipgloss.Height(header)-lipgloss.Height(statusBar), 0)
+		if bordered {
+			contentStyle = contentStyle.Width(m.width - contentBorder).Height(max(bodyHeight-contentBorder, 0))
+		} else {
+			contentStyle = contentStyle.Height(bodyHeight)
+		}
+		contentStyle = contentStyle.MaxHeight(bodyHeight)
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		header,
+		contentStyle.Render(content),
+		statusBar,
+	)
+}
+
+// View rendering for API Key Input Mode
+func (m model) viewAPIKeyInputMode() string {
+	modelConfig := m.config.Models[m.selectedModel]
+	isLocalModel := modelConfig.Provider == llm.ProviderLocal
+
+	var title string
+
+	if isLocalModel {
+		title = fmt.Sprintf("Configure local model: %s", m.selectedModel)
+
+		// Initialize input field values if they're empty
+		if m.apiBaseInput.Placeholder == "" {
+			m.apiBaseInput.Placeholder = "http://localhost:11434"
+		}
+
+		if m.modelNameInput.Placeholder == "" {
+			m.modelNameInput.Placeholder = "Model name as shown in 'ollama list' (e.g., llama3)"
+		}
+
+		m.apiKeyInput.Placeholder = "Optional; leave empty if the server doesn't need one..."
+		if modelConfig.APIKeyFromEnv {
+			m.apiKeyInput.Placeholder = "Using key from environment (type to override)..."
+		}
+	} else {
+		providerName := string(modelConfig.Provider)
+		providerName = strings.ToUpper(providerName[:1]) + providerName[1:]
+
+		title = fmt.Sprintf("Configure %s API", providerName)
+
+		// Set model name input placeholder
+		m.modelNameInput.Placeholder = fmt.Sprintf("Model name for %s (e.g., %s)", providerName, modelConfig.ModelName)
+
+		// Set API key placeholder based on provider
+		switch modelConfig.Provider {
+		case llm.ProviderOpenAI:
+			m.apiKeyInput.Placeholder = "Enter your OpenAI API key..."
+		case llm.ProviderAnthropic:
+			m.apiKeyInput.Placeholder = "Enter your Claude API key..."
+		default:
+			m.apiKeyInput.Placeholder = "Enter your API key..."
+		}
+
+		// Keys from the environment are never shown, so they can't be saved by accident
+		if modelConfig.APIKeyFromEnv {
+			m.apiKeyInput.Placeholder = "Using key from environment (type to override)..."
+		}
+	}
+
+	s := m.appBoundaryView(title) + "\n\n"
+
+	if isLocalModel {
+		// For local models, show the base URL, an optional API key, and the model name
+		baseURLFocused := m.focusedField() == fieldBaseURL
+		apiKeyFocused := m.focusedField() == fieldAPIKey
+		modelNameFocused := m.focusedField() == fieldModelName
+
+		// API Base URL field
+		if baseURLFocused {
+			s += m.styles.Highlight.Render("API Base URL:") + "\n"
+		} else {
+			s += "API Base URL:" + "\n"
+		}
+		s += m.apiBaseInput.View() + "\n"
+		s += m.viewFieldError(fieldBaseURL)
+
+		// Add URL hint for Ollama users
+		s += m.styles.Help.Render("For Ollama: Use http://localhost:11434 (without path segments)") + "\n\n"
+
+		// Optional API key field
+		if apiKeyFocused {
+			s += m.styles.Highlight.Render("API Key (optional):") + "\n"
+		} else {
+			s += "API Key (optional):" + "\n"
+		}
+		s += m.apiKeyInput.View() + "\n"
+		s += m.styles.Help.Render("Sent as a Bearer token, for servers behind an authenticating proxy") + "\n\n"
+
+		// Model Name field
+		if modelNameFocused {
+			s += m.styles.Highlight.Render("Model Name:") + "\n"
+		} else {
+			s += "Model Name:" + "\n"
+		}
+		s += m.viewModelNameField()
+		s += m.viewFieldError(fieldModelName)
+
+		// Add model name hint for Ollama users
+		if len(m.availableModels) > 0 {
+			s += "\n"
+		} else {
+			s += m.styles.Help.Render("For Ollama: Use exactly the model name shown in 'ollama list'") + "\n\n"
+		}
+	} else {
+		// For cloud models, show both API key and model name inputs
+		apiKeyFocused := m.focusedField() == fieldAPIKey
+		modelNameFocused := m.focusedField() == fieldModelName
+
+		// API Key field
+		if apiKeyFocused {
+			s += m.styles.Highlight.Render("API Key:") + "\n"
+		} else {
+			s += "API Key:" + "\n"
+		}
+		s += m.apiKeyInput.View() + "\n"
+		s += m.viewFieldError(fieldAPIKey) + "\n"
+
+		// Model Name field
+		if modelNameFocused {
+			s += m.styles.Highlight.Render("Model Name:") + "\n"
+		} else {
+			s += "Model Name:" + "\n"
+		}
+		s += m.viewModelNameField()
+		s += m.viewFieldError(fieldModelName)
+
+		if len(m.availableModels) > 0 {
+			s += "\n"
+		} else if modelConfig.Provider == llm.ProviderAnthropic {
+			s += m.styles.Help.Render("For Claude: Examples include claude-3-opus-20240229, claude-3-sonnet-20240229, claude-3-haiku-20240307") + "\n\n"
+		} else if modelConfig.Provider == llm.ProviderOpenAI {
+			s += m.styles.Help.Render("For OpenAI: Examples include gpt-3.5-turbo, gpt-4, gpt-4-turbo") + "\n\n"
+		}
+	}
+
+	// Save configuration checkbox
+	saveText := "[ ] Save configuration to config file"
+	if m.saveConfig {
+		saveText = "[x] Save configuration to config file"
+	}
+
+	saveFocused := m.focusedField() == fieldSaveConfig
+	if saveFocused {
+		s += m.styles.Highlight.Render(saveText) + "\n\n"
+	} else {
+		s += saveText + "\n\n"
+	}
+
+	if m.testingModel {
+		s += m.styles.Help.Render("Testing connection...") + "\n\n"
+	} else if m.testStatus != "" {
+		s += m.testStatus + "\n\n"
+	}
+
+	// Help text
+	s += m.styles.Help.Render("Tab/Shift+Tab: Next/previous field • Ctrl+r: Show/hide key • Ctrl+t: Test connection • Space: Toggle checkbox • Enter: Confirm") + "\n"
+	s += m.styles.Help.Render("Esc to return to menu • Ctrl+q to quit")
+
+	return s
+}
+
+// viewModelNameField renders the model name as a pick list when the provider's models
+// have been fetched, or as the free-text input otherwise.
+func (m model) viewModelNameField() string {
+	if m.fetchingModels {
+		return m.modelNameInput.View() + "\n" + m.styles.Help.Render("Loading available models...") + "\n"
+	}
+
+	if len(m.availableModels) == 0 {
+		s := m.modelNameInput.View() + "\n"
+		if m.modelListErr != "" {
+			if m.config.Models[m.selectedModel].Provider == llm.ProviderLocal {
+				s += m.styles.Help.Render("Couldn't list installed models; Ollama may not be running (try 'ollama serve'). Type the name instead.") + "\n"
+			} else {
+				s += m.styles.Help.Render(fmt.Sprintf("Couldn't load the model list (%s); type the name instead", m.modelListErr)) + "\n"
+			}
+		}
+		return s
+	}
+
+	// Show a window of the list around the cursor
+	const visible = 7
+	start := m.modelListCursor - visible/2
+	if start > len(m.availableModels)-visible {
+		start = len(m.availableModels) - visible
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + visible
+	if end > len(m.availableModels) {
+		end = len(m.availableModels)
+	}
+
+	var s string
+	for i := start; i < end; i++ {
+		line := "  " + m.availableModels[i]
+		if i == m.modelListCursor {
+			line = m.styles.Highlight.Render("> " + m.availableModels[i])
+		} else {
+			line = m.styles.Help.Render(line)
+		}
+		s += line + "\n"
+	}
+	s += m.styles.Help.Render(fmt.Sprintf("←/→: Choose a model (%d/%d)", m.modelListCursor+1, len(m.availableModels))) + "\n"
+	return s
+}
+
+// View rendering for Selection Mode
+func (m model) viewSelectionMode() string {
+	key := m.keys.shortLabel
+	s := m.appBoundaryView("Select Report Type") + "\n\n"
+	if d := m.resumeDraft; d != nil {
+		s += m.styles.Highlight.Render(fmt.Sprintf("Resume your unfinished %s form from %s (question %d/%d)? (y/n)",
+			d.FormType, d.Saved.Local().Format("2006-01-02 15:04"), d.CurrentQuestion+1, len(d.Answers))) + "\n\n"
+	}
+	s += m.formFilter.view(m.styles)
+
+	visible := m.visibleForms()
+	if len(visible) == 0 {
+		s += m.styles.Help.Render("  No matching forms") + "\n"
+	}
+	for n, i := range visible {
+		rt := m.formTypes[i]
+		cursor := "  "
+		if m.cursor == i {
+			cursor = m.styles.Highlight.Render(">")
+		}
+
+		line := fmt.Sprintf("%s %s %s", cursor, hotkeyLabel(n), rt.name)
+
+		if m.cursor == i {
+			line = m.styles.Highlight.Render(line)
+		} else {
+			line = m.styles.Help.Render(line)
+		}
+
+		s += line + "\n"
+	}
+
+	if m.editingPrompt {
+		form := m.formTypes[m.cursor]
+		label := fmt.Sprintf("Prompt for %s:", form.name)
+		if _, ok := m.config.PromptOverrides[form.name]; ok {
+			label = fmt.Sprintf("Prompt for %s (customized):", form.name)
+		}
+		s += "\n" + m.styles.Highlight.Render(label) + "\n"
+		s += m.promptInput.View() + "\n\n"
+		s += m.styles.Help.Render("Ctrl+s to save • Ctrl+r to reset to the default • Esc to cancel") + "\n"
+		return s
+	}
+
+	if m.importingAnswers {
+		s += "\n" + m.styles.Highlight.Render(fmt.Sprintf("Import answers for %s from:", m.formTypes[m.cursor].name)) + "\n"
+		s += m.importInput.View() + "\n"
+		if m.importErr != "" {
+			s += m.styles.ErrorStatus(m.importErr) + "\n"
+		}
+		s += m.styles.Help.Render("Enter to import and review • Esc to cancel") + "\n"
+		return s
+	}
+
+	if m.formFilter.typing {
+		s += "\n" + m.styles.Help.Render(filterHelp) + "\n"
+		return s
+	}
+
+	if m.promptStatus != "" {
+		s += "\n" + m.promptStatus + "\n"
+	}
+
+	s += "\n" + m.styles.Help.Render("Use "+key(actionMoveUp)+"/"+key(actionMoveDown)+" to navigate • Enter or 1-9 to select • "+key(actionFilter)+" to filter • "+key(actionEditPrompt)+" to edit the prompt • "+key(actionImport)+" to import answers • "+key(actionHistory)+" for past summaries") + "\n"
+	s += m.styles.Help.Render(fmt.Sprintf("Current model: %s", m.config.ActiveModel)) + "\n"
+	s += m.styles.Help.Render("~ to change model • Ctrl+t to change theme • ? for help • "+key(actionQuit)+" or Ctrl+q to quit") + "\n"
+
+	return s
+}
+
+// View rendering for Question Mode
+func (m model) viewQuestionMode() string {
+	currentQ := m.currentForm.questions[m.currentQuestion]
+
+	s := m.appBoundaryView(fmt.Sprintf("%s - Question %d/%d", m.currentForm.name, m.currentQuestion+1, len(m.currentForm.questions))) + "\n"
+	s += m.viewQuestionProgress() + "\n\n"
+	required := m.currentForm.isRequired(m.currentQuestion)
+	s += m.styles.Highlight.Render(fmt.Sprintf("**%s**", currentQ))
+	if required {
+		s += m.styles.Help.Render(" (required)")
+	}
+	s += "\n\n"
+	s += m.answerInput.View() + "\n"
+	s += m.viewAnswerCount()
+	if m.answerWarning != "" {
+		s += "\n" + m.styles.ErrorStatus(m.answerWarning)
+	}
+	if m.jumpingTo {
+		s += "\n" + m.styles.Highlight.Render(fmt.Sprintf("Go to question (1-%d): %s", len(m.currentForm.questions), m.jumpQuery)) +
+			m.styles.Help.Render(" • Enter to go • Esc to cancel")
+	}
+
+	help := "Enter for a new line • Ctrl+d to submit • Ctrl+s to skip • Ctrl+b to go back • Ctrl+g to go to a question"
+	if required {
+		help = "Enter for a new line • Ctrl+d to submit • Ctrl+b to go back • Ctrl+g to go to a question"
+	}
+	s += "\n\n" + m.styles.Help.Render(help) + "\n"
+	s += m.styles.Help.Render("Esc to return to menu • Ctrl+q to quit") + "\n"
+
+	return s
+}
+
+// updateQuestionJump handles keys while the go-to-question prompt is open: digits build the
+// number, Enter jumps to it, and Esc closes the prompt
+func (m model) updateQuestionJump(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.jumpingTo = false
+	case tea.KeyBackspace:
+		if m.jumpQuery != "" {
+			m.jumpQuery = m.jumpQuery[:len(m.jumpQuery)-1]
+		}
+	case tea.KeyEnter:
+		n, err := strconv.Atoi(m.jumpQuery)
+		if err != nil || n < 1 || n > len(m.currentForm.questions) {
+			m.answerWarning = fmt.Sprintf("Enter a question number from 1 to %d", len(m.currentForm.questions))
+			m.jumpQuery = ""
+			return m, nil
+		}
+		m.jumpingTo = false
+		m.answerWarning = ""
+
+		// Keep whatever was typed so far, then load the target's answer for editing
+		m.answers[m.currentQuestion] = strings.TrimSpace(m.answerInput.Value())
+		m.currentQuestion = n - 1
+		m.answerInput.SetValue(m.answers[m.currentQuestion])
+		m.saveFormDraft()
+	case tea.KeyRunes:
+		for _, r := range msg.Runes {
+			if r >= '0' && r <= '9' && len(m.jumpQuery) < 3 {
+				m.jumpQuery += string(r)
+			}
+		}
+	}
+	return m, nil
+}
+
+// viewQuestionProgress renders a bar showing how many of the form's questions are behind
+// the current one, in the theme's colors. The glyphs differ too, for monochrome themes.
+func (m model) viewQuestionProgress() string {
+	theme := m.styleThemes[m.styleThemeIndex]
+	total := len(m.currentForm.questions)
+	width := 40
+	if m.width > 0 {
+		width = max(min(m.contentWidth()-5, 60), 10) // Room for the percentage
+	}
+
+	filled := width * m.currentQuestion / total
+	bar := lipgloss.NewStyle().Foreground(theme.Accent).Render(strings.Repeat("█", filled)) +
+		lipgloss.NewStyle().Foreground(theme.Base).Render(strings.Repeat("░", width-filled))
+	return bar + m.styles.Help.Render(fmt.Sprintf(" %d%%", 100*m.currentQuestion/total))
+}
+
+// shortAnswerChars is the length under which an answer gets a nudge to say more
+const shortAnswerChars = 10
+
+// viewAnswerCount renders the character and word count of the answer being typed, with a
+// nudge when a started answer is very short. It's only a hint; any answer can be submitted.
+func (m model) viewAnswerCount() string {
+	answer := strings.TrimSpace(m.answerInput.Value())
+	chars := len([]rune(answer))
+	words := len(strings.Fields(answer))
+	count := m.styles.Help.Render(fmt.Sprintf("%d characters • %d words", chars, words))
+	if chars > 0 && chars < shortAnswerChars {
+		count += m.styles.Help.Render(" • ") + m.styles.Highlight.Render("Short answers make for thin summaries; add some detail if you can")
+	}
+	return count
+}
+
+// View rendering for Review Mode
+func (m model) viewReviewMode() string {
+	key := m.keys.shortLabel
+	if m.previewingPrompt {
+		return m.viewPromptPreview()
+	}
+
+	s := m.appBoundaryView(fmt.Sprintf("%s - Review", m.currentForm.name)) + "\n\n"
+
+	for i, question := range m.currentForm.questions {
+		cursor := "  "
+		if m.reviewCursor == i {
+			cursor = m.styles.Highlight.Render(">")
+		}
+
+		line := fmt.Sprintf("%s %d. %s", cursor, i+1, question)
+		if m.reviewCursor == i {
+			line = m.styles.Highlight.Render(line)
+		}
+		s += line + "\n"
+
+		answer := ""
+		if i < len(m.answers) {
+			answer = m.answers[i]
+		}
+		if answer == "" && m.currentForm.isRequired(i) {
+			s += m.styles.ErrorHeaderText.Render("     (required, not answered)") + "\n"
+		} else if answer == "" {
+			s += m.styles.Help.Render("     (skipped)") + "\n"
+		} else {
+			for _, answerLine := range strings.Split(answer, "\n") {
+				s += m.styles.Help.Render("     "+answerLine) + "\n"
+			}
+		}
+	}
+
+	s += "\n" + m.styles.Help.Render("Length: ") + m.styles.Highlight.Render(optionOrDefault(lengthOptions, m.config.Length)) +
+		m.styles.Help.Render("   Tone: ") + m.styles.Highlight.Render(optionOrDefault(toneOptions, m.config.Tone)) + "\n"
+
+	if m.contextWarning != "" {
+		s += "\n" + m.styles.ErrorHeaderText.Render("Warning: "+m.contextWarning) + "\n"
+		s += m.styles.Help.Render("y to send anyway • n to go back and edit") + "\n"
+		return s
+	}
+
+	if m.choosingCompare {
+		s += "\n" + m.styles.Highlight.Render(fmt.Sprintf("Compare %s with:", m.config.ActiveModel)) + "\n"
+		if len(m.compareChoices) == 0 {
+			s += m.styles.Help.Render("  No other model is configured. Add one from the model selection screen (~).") + "\n"
+		}
+		for i, key := range m.compareChoices {
+			line := fmt.Sprintf("  %s (%s)", key, m.config.Models[key].ModelName)
+			if i == m.compareCursor {
+				line = m.styles.Highlight.Render("> " + line[2:])
+			} else {
+				line = m.styles.Help.Render(line)
+			}
+			s += line + "\n"
+		}
+		s += "\n" + m.styles.Help.Render("Use "+key(actionMoveUp)+"/"+key(actionMoveDown)+" to choose • Enter to send to both • Esc to cancel") + "\n"
+		return s
+	}
+
+	if m.reviewWarning != "" {
+		s += "\n" + m.styles.ErrorHeaderText.Render(m.reviewWarning) + "\n"
+	}
+	s += "\n" + m.styles.Help.Render("Use "+key(actionMoveUp)+"/"+key(actionMoveDown)+" to navigate • Enter to edit • "+key(actionCompare)+" to compare models • "+key(actionCycleLength)+"/"+key(actionCycleTone)+" to change length/tone • "+key(actionPreview)+" to preview the prompt • "+key(actionSend)+" to send") + "\n"
+	s += m.styles.Help.Render("Esc to return to menu • "+key(actionQuit)+" or Ctrl+q to quit") + "\n"
+
+	return s
+}
+
+// viewPromptPreview renders the prompt that would be sent, in place of the review list
+func (m model) viewPromptPreview() string {
+	key := m.keys.shortLabel
+	s := m.viewport.View() + "\n"
+
+	if m.contextWarning != "" {
+		s += m.styles.ErrorHeaderText.Render("Warning: "+m.contextWarning) + "\n"
+		s += m.styles.Help.Render("y to send anyway • n to go back") + "\n"
+		return s
+	}
+
+	s += m.styles.Help.Render(fmt.Sprintf("Prompt preview, nothing has been sent (~%d tokens)", estimateTokens(combinePrompt(m.config.requestPrompt(m.currentForm), buildSelectedMarkdown(m))))) + "\n"
+	s += m.styles.Help.Render("↑/↓: Scroll • "+key(actionSend)+" to send • Esc to go back and edit • "+key(actionQuit)+" or Ctrl+q to quit") + "\n"
+	return s
+}
+
+// View rendering for Display Mode
+func (m model) viewDisplayMode() string {
+	s := m.viewport.View()
+
+	if m.showSpinner {
+		if _, animated := m.config.spinnerStyle(); animated {
+			elapsed := time.Since(m.requestStart).Truncate(time.Second)
+			s += "\n" + m.styles.Highlight.Render(fmt.Sprintf("%s Waiting for %s... %s", m.spinner.View(), m.config.ActiveModel, elapsed))
+		} else {
+			// Without ticks the view isn't redrawn, so there's no elapsed time to keep current
+			s += "\n" + m.styles.Highlight.Render(fmt.Sprintf("Processing with %s...", m.config.ActiveModel))
+		}
+	}
+
+	if m.askingFollowUp {
+		s += "\n" + m.styles.Highlight.Render("What should change?") + "\n"
+		s += m.followUpInput.View() + "\n"
+		s += m.styles.Help.Render("Enter to send • Esc to cancel\n")
+		return s
+	}
+
+	if m.askingNote {
+		s += "\n" + m.styles.Highlight.Render("Analyst note:") + "\n"
+		s += m.noteInput.View() + "\n"
+		s += m.styles.Help.Render("Enter to save (empty removes the note) • Esc to cancel\n")
+		return s
+	}
+
+	if m.savingToFile {
+		s += "\n" + m.styles.Highlight.Render("Save as:") + "\n"
+		s += m.fileNameInput.View() + "\n"
+		s += m.styles.Help.Render("Enter to save • Esc to cancel\n")
+		return s
+	}
+
+	if m.askingJiraKey {
+		s += "\n" + m.styles.Highlight.Render("Jira issue to comment on:") + "\n"
+		s += m.jiraInput.View() + "\n"
+		if m.displayStatus != "" {
+			s += m.displayStatus + "\n"
+		}
+		newIssue := "Enter with no key creates an issue"
+		if project := m.config.JiraProject; project != "" {
+			newIssue += " in " + project
+		} else {
+			newIssue = "Set jira_project to create issues"
+		}
+		s += m.styles.Help.Render(newIssue + " • Esc to cancel\n")
+		return s
+	}
+
+	if m.displayStatus != "" {
+		s += "\n" + m.displayStatus
+	}
+	s += "\n" + m.styles.Help.Width(m.viewport.Width).Render(m.displayHelp())
+	return s
+}
+
+// displayHelp returns the key help shown under the viewport in display mode
+func (m model) displayHelp() string {
+	key := m.keys.shortLabel
+	regenerateHelp := key(actionRegenerate) + " to regenerate"
+	if m.config.CacheTTLMinutes > 0 {
+		regenerateHelp += " (" + key(actionRegenerateUncached) + " skips the cache)"
+	}
+	saveHelp := key(actionSave) + " to save"
+	if m.config.slackWebhookURL() != "" {
+		saveHelp += " • " + key(actionPostSlack) + " to post to Slack"
+	}
+	if m.config.githubToken() != "" && m.config.GitHubRepo != "" {
+		saveHelp += " • " + key(actionGitHubIssue) + " to create a GitHub issue"
+	}
+	if m.config.jiraConfigured() {
+		saveHelp += " • " + key(actionJira) + " to post to Jira"
+	}
+	wrapHelp := key(actionToggleWrap) + " to turn word wrap off"
+	if m.config.NoWrap {
+		wrapHelp = "No wrap: " + key(actionScrollLeft) + "/" + key(actionScrollRight) + " to scroll sideways, " + key(actionToggleWrap) + " to wrap"
+	}
+	return key(actionScrollUp) + "/" + key(actionScrollDown) + ": Scroll • " + wrapHelp + " • " + key(actionToggleMarkdown) + " to toggle raw markdown • " + regenerateHelp + " • " + key(actionFollowUp) + " to ask for changes • " + key(actionNote) + " to add a note • " + key(actionEdit) + " to edit • " + key(actionCopy) + " to copy as " + m.copyFormat.name() + " (" + key(actionCopyFormat) + " to change) • " + saveHelp + " • Esc to return to menu • " + key(actionQuit) + " or Ctrl+q to quit"
+}
+
+// typingText reports whether keys in the current mode go to a text input
+func (m model) typingText() bool {
+	switch m.currentMode {
+	case questionMode, apiKeyInputMode:
+		return true
+	case selectionMode:
+		return m.editingPrompt || m.formFilter.typing || m.importingAnswers
+	case modelSelectMode:
+		return m.modelFilter.typing
+	case displayMode:
+		return m.savingToFile || m.askingFollowUp || m.askingNote || m.askingJiraKey
+	}
+	return false
+}
+
+// viewHelp renders the key bindings for the current mode, followed by the global ones
+func (m model) viewHelp() string {
+	keyStyle := m.styles.Highlight.Width(20)
+
+	var b strings.Builder
+	b.WriteString(m.styles.HeaderText.Render(m.currentMode.name()+" key bindings") + "\n\n")
+	for _, h := range m.keys.help(modeKeyHelp[m.currentMode]) {
+		b.WriteString(keyStyle.Render(h.key) + h.desc + "\n")
+	}
+	b.WriteString("\n" + m.styles.HeaderText.Render("Global") + "\n\n")
+	quitHelp := keyHelp{m.keys.label(actionQuit), "quit (not while typing)"}
+	for _, h := range append([]keyHelp{globalKeyHelp[0], quitHelp}, globalKeyHelp[1:]...) {
+		b.WriteString(keyStyle.Render(h.key) + h.desc + "\n")
+	}
+	b.WriteString(m.styles.Help.Render("\n? or Esc to close"))
+	return b.String()
+}
+
+// newModelTemplates are the kinds of provider that can be added from the model select screen
+var newModelTemplates = []struct {
+	label  string
+	config llm.ModelConfig
+}{
+	{"OpenAI", llm.ModelConfig{Provider: llm.ProviderOpenAI, ModelName: "gpt-3.5-turbo"}},
+	{"Anthropic (Claude)", llm.ModelConfig{Provider: llm.ProviderAnthropic, ModelName: "claude-3-sonnet-20240229"}},
+	{"Ollama", llm.ModelConfig{Provider: llm.ProviderLocal, ModelName: "llama3", APIBaseURL: "http://localhost:11434", APIStyle: llm.APIStyleOllama}},
+	{"OpenAI-compatible server", llm.ModelConfig{Provider: llm.ProviderLocal, APIBaseURL: "http://localhost:8000", APIStyle: llm.APIStyleOpenAI}},
+}
+
+// updateNewModelPrompt handles input while a new provider is being named
+func (m model) updateNewModelPrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.addingModel = false
+		m.newModelInput.Blur()
+		return m, nil
+
+	case tea.KeyLeft, tea.KeyRight, tea.KeyTab, tea.KeyShiftTab:
+		// Choose the kind of provider
+		n := len(newModelTemplates)
+		if msg.Type == tea.KeyLeft || msg.Type == tea.KeyShiftTab {
+			m.newModelTemplate = (m.newModelTemplate - 1 + n) % n
+		} else {
+			m.newModelTemplate = (m.newModelTemplate + 1) % n
+		}
+		return m, nil
+
+	case tea.KeyEnter:
+		key := strings.TrimSpace(m.newModelInput.Value())
+		if key == "" {
+			m.newModelErr = "Enter a name for the provider"
+			return m, nil
+		}
+		for existing := range m.config.Models {
+			if strings.EqualFold(existing, key) {
+				m.newModelErr = fmt.Sprintf("%q is already in use", existing)
+				return m, nil
+			}
+		}
+
+		m.config.Models[key] = newModelTemplates[m.newModelTemplate].config
+		applyEnvAPIKeys(&m.config)
+		m.modelKeys = append(m.modelKeys, key)
+		sort.Strings(m.modelKeys)
+		m.modelCursor = indexOf(m.modelKeys, key)
+
+		logf("Added model configuration %s (%s)", key, newModelTemplates[m.newModelTemplate].label)
+		if err := m.store.Save(m.config); err != nil {
+			log.Printf("Failed to save config: %v\n", err)
+		}
+
+		// Fill in the details
+		m.addingModel = false
+		m.newModelInput.Blur()
+		m.selectedModel = key
+		return m.enterAPIKeyInputMode()
+	}
+
+	m.newModelInput, cmd = m.newModelInput.Update(msg)
+	m.newModelErr = ""
+	return m, cmd
+}
+
+// maskAPIKey hides all but the last four characters of a key for display
+func maskAPIKey(key string) string {
+	if len(key) <= 8 {
+		return "••••"
+	}
+	return "••••" + key[len(key)-4:]
+}
+
+// isBuiltinModel reports whether key is one of the providers in DefaultModelConfigs
+func isBuiltinModel(key string) bool {
+	_, ok := DefaultModelConfigs[key]
+	return ok
+}
+
+// deleteModelConfig removes a provider from the config and saves it
+func (m model) deleteModelConfig(key string) model {
+	delete(m.config.Models, key)
+
+	keys := make([]string, 0, len(m.modelKeys))
+	for _, k := range m.modelKeys {
+		if k != key {
+			keys = append(keys, k)
+		}
+	}
+	m.modelKeys = keys
+	if m.modelCursor >= len(m.modelKeys) {
+		m.modelCursor = len(m.modelKeys) - 1
+	}
+	if m.modelCursor < 0 {
+		m.modelCursor = 0
+	}
+
+	if m.config.ActiveModel == key {
+		m.config.ActiveModel = ""
+	}
+	if m.selectedModel == key {
+		m.selectedModel = ""
+	}
+
+	logf("Deleted model configuration %s", key)
+	if err := m.store.Save(m.config); err != nil {
+		log.Printf("Failed to save config: %v\n", err)
+	}
+	return m
+}
+
+// viewModelSelectMode renders the model selection interface
+func (m model) viewModelSelectMode() string {
+	key := m.keys.shortLabel
+	s := m.appBoundaryView("Select AI Provider") + "\n\n"
+	s += m.modelFilter.view(m.styles)
+
+	visible := m.visibleModels()
+	if len(visible) == 0 {
+		s += m.styles.Help.Render("  No matching providers") + "\n"
+	}
+	for n, i := range visible {
+		key := m.modelKeys[i]
+		modelConfig := m.config.Models[key]
+
+		cursor := "  "
+		if m.modelCursor == i {
+			cursor = m.styles.Highlight.Render(">")
+		}
+
+		// Get a user-friendly provider name
+		var providerDisplay string
+		switch modelConfig.Provider {
+		case llm.ProviderOpenAI:
+			providerDisplay = "OpenAI"
+		case llm.ProviderAnthropic:
+			providerDisplay = "Anthropic (Claude)"
+		case llm.ProviderLocal:
+			if modelConfig.LocalAPIStyle() == llm.APIStyleOpenAI {
+				providerDisplay = "OpenAI-compatible (Local)"
+			} else {
+				providerDisplay = "Ollama (Local)"
+			}
+		default:
+			providerDisplay = string(modelConfig.Provider)
+		}
+
+		// Format model info to show current model name or configuration status
+		var modelInfo string
+		if isBuiltinModel(key) {
+			// For the main providers, show model name if configured
+			if (modelConfig.Provider != llm.ProviderLocal && modelConfig.APIKey != "") ||
+				(modelConfig.Provider == llm.ProviderLocal && modelConfig.APIBaseURL != "") {
+				modelInfo = fmt.Sprintf("%s - %s", providerDisplay, modelConfig.ModelName)
+			} else {
+				modelInfo = fmt.Sprintf("%s (not configured)", providerDisplay)
+			}
+		} else {
+			// For custom configurations, show provider and model name
+			modelInfo = fmt.Sprintf("%s (%s)", key, providerDisplay)
+		}
+
+		// Show configuration status
+		status := ""
+		if modelConfig.Provider != llm.ProviderLocal && modelConfig.APIKey != "" {
+			status = m.styles.StatusHeader.Render(" ✓") + m.styles.Help.Render(" key "+maskAPIKey(modelConfig.APIKey))
+		} else if modelConfig.Provider == llm.ProviderLocal && modelConfig.APIBaseURL != "" {
+			status = m.styles.StatusHeader.Render(" ✓")
+		}
+
+		line := fmt.Sprintf("%s %s %s%s", cursor, hotkeyLabel(n), modelInfo, status)
+
+		if m.modelCursor == i {
+			line = m.styles.Highlight.Render(line)
+		} else {
+			line = m.styles.Help.Render(line)
+		}
+
+		s += line + "\n"
+		s += "      " + m.styles.Help.Render(modelSummary(modelConfig)) + "\n"
+	}
+
+	if m.deletingModel != "" {
+		s += "\n" + m.styles.ErrorHeaderText.Render(fmt.Sprintf("Delete %s? (y/n)", m.deletingModel)) + "\n"
+		return s
+	}
+
+	if m.addingModel {
+		s += "\n" + m.styles.Highlight.Render("New provider name:") + "\n"
+		s += m.newModelInput.View() + "\n"
+		if m.newModelErr != "" {
+			s += m.styles.ErrorStatus(m.newModelErr) + "\n"
+		}
+		s += "\nType: " + m.styles.Highlight.Render("< "+newModelTemplates[m.newModelTemplate].label+" >") + "\n\n"
+		s += m.styles.Help.Render("←/→: Choose type • Enter to create and configure • Esc to cancel") + "\n"
+		return s
+	}
+
+	if m.modelFilter.typing {
+		s += "\n" + m.styles.Help.Render(filterHelp) + "\n"
+		return s
+	}
+
+	if m.modelStatus != "" {
+		s += "\n" + m.modelStatus + "\n"
+	}
+	s += "\n" + m.styles.Help.Render("Use "+key(actionMoveUp)+"/"+key(actionMoveDown)+" to navigate • Enter or 1-9 to select • "+key(actionFilter)+" to filter") + "\n"
+	s += m.styles.Help.Render(key(actionConfigure)+" to configure provider • "+key(actionAddProvider)+" to add a provider • "+key(actionDelete)+" to delete a custom provider • Ctrl+t to change theme") + "\n"
+	if activeModelConfig, ok := m.config.activeModelConfig(); ok {
+		s += m.styles.Help.Render(fmt.Sprintf("Current model: %s - %s", m.config.ActiveModel, activeModelConfig.ModelName)) + "\n"
+	}
+	if len(m.profiles) > 1 || m.config.profile != "" {
+		s += m.styles.Help.Render(fmt.Sprintf("Profile: %s • %s to switch profile", profileLabel(m.config.profile), key(actionNextProfile))) + "\n"
+	}
+	s += m.styles.Help.Render("Esc to return to menu • "+key(actionQuit)+" or Ctrl+q to quit") + "\n"
+
+	return s
+}
+
+// viewStyleSelectMode renders the style selection interface
+func (m model) viewStyleSelectMode() string {
+	key := m.keys.shortLabel
+	s := m.appBoundaryView("Select Style Theme") + "\n\n"
+
+	for i, theme := range m.styleThemes {
+		cursor := "  "
+		if m.styleThemeIndex == i {
+			cursor = m.styles.Highlight.Render(">")
+		}
+
+		line := fmt.Sprintf("%s %s", cursor, theme.Name)
+		if m.styleThemeIndex == i {
+			line = m.styles.Highlight.Render(line)
+		}
+
+		s += line + "\n"
+	}
+
+	s += "\n" + m.styles.Help.Render("Use "+key(actionMoveUp)+"/"+key(actionMoveDown)+" to navigate • Enter to select") + "\n"
+	s += m.styles.Help.Render("Esc to return to menu • "+key(actionQuit)+" or Ctrl+q to quit") + "\n"
+
+	return s
+}
+
+// viewHistoryMode renders the list of past summaries
+func (m model) viewHistoryMode() string {
+	key := m.keys.shortLabel
+	s := m.appBoundaryView("Past Summaries") + "\n\n"
+
+	if len(m.historyEntries) == 0 {
+		s += m.styles.Help.Render("No summaries yet. They are saved here each time one is generated.") + "\n"
+	}
+
+	for i, entry := range m.historyEntries {
+		cursor := "  "
+		if m.historyCursor == i {
+			cursor = m.styles.Highlight.Render(">")
+		}
+
+		line := fmt.Sprintf("%s %s  %s (%s)", cursor, entry.Timestamp.Local().Format("2006-01-02 15:04"), entry.FormType, entry.Model)
+		if m.historyCursor == i {
+			line = m.styles.Highlight.Render(line)
+		} else {
+			line = m.styles.Help.Render(line)
+		}
+
+		s += line + "\n"
+	}
+
+	if m.historyErr != "" {
+		s += "\n" + m.styles.ErrorStatus(m.historyErr) + "\n"
+	}
+
+	if m.deletingHistory {
+		entry := m.historyEntries[m.historyCursor]
+		s += "\n" + m.styles.ErrorHeaderText.Render(fmt.Sprintf("Delete the %s summary from %s? (y/n)", entry.FormType, entry.Timestamp.Local().Format("2006-01-02 15:04"))) + "\n"
+		return s
+	}
+
+	s += "\n" + m.styles.Help.Render("Use "+key(actionMoveUp)+"/"+key(actionMoveDown)+" to navigate • Enter to open • "+key(actionDelete)+" to delete") + "\n"
+	s += m.styles.Help.Render("Esc to return to menu • "+key(actionQuit)+" or Ctrl+q to quit") + "\n"
+
+	return s
+}
+
+// viewCompareMode renders the two comparison panes with their model labels
+func (m model) viewCompareMode() string {
+	key := m.keys.shortLabel
+	var panes []string
+	for i, pane := range m.comparePanes {
+		label := fmt.Sprintf("%s (%s)", pane.modelKey, m.config.Models[pane.modelKey].ModelName)
+		if pane.pending {
+			if _, animated := m.config.spinnerStyle(); animated {
+				label += " " + m.spinner.View()
+			} else {
+				label += " (processing...)"
+			}
+		}
+		if i == m.compareFocus {
+			label = m.styles.Highlight.Render("> " + label)
+		} else {
+			label = m.styles.Help.Render("  " + label)
+		}
+		panes = append(panes, lipgloss.JoinVertical(lipgloss.Left, label, pane.viewport.View()))
+	}
+
+	var s string
+	if m.viewport.Width >= 100 {
+		s = lipgloss.JoinHorizontal(lipgloss.Top, panes[0], " ", panes[1])
+	} else {
+		s = lipgloss.JoinVertical(lipgloss.Left, panes...)
+	}
+
+	if m.compareStatus != "" {
+		s += "\n" + m.compareStatus
+	}
+	s += m.styles.Help.Render("\n" + key(actionSwitchPane) + " to switch pane • ↑/↓: Scroll • " + key(actionCopy) + " to copy as " + m.copyFormat.name() + " (" + key(actionCopyFormat) + " to change) • Esc to return to menu • " + key(actionQuit) + " or Ctrl+q to quit\n")
+	return s
+}
+
+// appBoundaryView renders a consistent header for the application
+func (m model) appBoundaryView(text string) string {
+	theme := m.styleThemes[m.styleThemeIndex]
+	return m.boundaryView(m.styles.HeaderText.Render(text), theme.Base, m.contentWidth())
+}
+
+// appErrorBoundaryView renders a consistent error header for the application
+func (m model) appErrorBoundaryView(text string) string {
+	theme := m.styleThemes[m.styleThemeIndex]
+	return m.boundaryView(m.styles.ErrorHeaderText.Render(text), theme.Error, m.contentWidth())
+}
+
+// boundaryView follows a rendered label with a rule of slashes out to width
+func (m model) boundaryView(label string, rule lipgloss.AdaptiveColor, width int) string {
+	return lipgloss.PlaceHorizontal(
+		width,
+		lipgloss.Left,
+		label,
+		lipgloss.WithWhitespaceChars("/"),
+		lipgloss.WithWhitespaceForeground(rule),
+	)
+}
+
+// --- [ Model Comparison ] ------------------------------------
+//
+// Send the same answers to two models at once and show the results side by side.
+//
+
+// compareResultMsg carries the result for one pane of a comparison
+type compareResultMsg struct {
+	id      int
+	pane    int
+	content string
+	err     error
+}
+
+// comparePane holds one model's side of a comparison
+type comparePane struct {
+	modelKey string
+	output   string
+	err      error
+	pending  bool
+	viewport viewport.Model
+}
+
+// configuredModelKeys returns the models that have what they need to send a request, in sorted order
+func (m model) configuredModelKeys() []string {
+	var keys []string
+	for _, key := range m.modelKeys {
+		modelConfig := m.config.Models[key]
+		if (modelConfig.Provider != llm.ProviderLocal && modelConfig.APIKey != "") ||
+			(modelConfig.Provider == llm.ProviderLocal && modelConfig.APIBaseURL != "") {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// startComparison sends the answers to the active model and to other, one request each,
+// and switches to the comparison view. Each result arrives as its own compareResultMsg.
+func startComparison(m model, other string) (model, tea.Cmd) {
+	md := buildSelectedMarkdown(m)
+	formPrompt := m.config.requestPrompt(m.currentForm)
+	settings := m.config.requestSettings()
+
+	m = m.stopRequest()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.requestCancel = cancel
+	m.requestID++ // Results of any earlier request are now stale
+	clearDraft(m.config.dir)
+	m.generating = false
+	m.compareStatus = ""
+	m.compareFocus = 0
+	m.choosingCompare = false
+	m.currentMode = compareMode
+	m.showSpinner = true
+
+	var cmds []tea.Cmd
+	for i, key := range []string{m.config.ActiveModel, other} {
+		m.comparePanes[i] = comparePane{modelKey: key, pending: true}
+
+		id, pane, modelConfig := m.requestID, i, m.config.Models[key]
+		logf("Comparing: sending request %d to %s", id, key)
+		cmds = append(cmds, func() tea.Msg {
+			response, _, err := makeLLMRequest(ctx, modelConfig, settings, formPrompt, md, nil, nil)
+			return compareResultMsg{id: id, pane: pane, content: response, err: err}
+		})
+	}
+	m.layoutComparePanes()
+
+	return m, tea.Batch(append(cmds, m.spinnerTick())...)
+}
+
+// handleCompareResult fills in the pane a result belongs to
+func (m model) handleCompareResult(msg compareResultMsg) (tea.Model, tea.Cmd) {
+	if msg.id != m.requestID {
+		return m, nil
+	}
+
+	pane := &m.comparePanes[msg.pane]
+	pane.pending = false
+	pane.output = msg.content
+	pane.err = msg.err
+	if msg.err != nil {
+		logf("Comparing: %s failed: %v", pane.modelKey, msg.err)
+	} else {
+		logf("Comparing: %s finished", pane.modelKey)
+	}
+	m.renderComparePane(msg.pane)
+
+	m.showSpinner = m.comparePanes[0].pending || m.comparePanes[1].pending
+	return m, nil
+}
+
+// layoutComparePanes sizes the panes to fit the space the main viewport gets: side by side
+// when there is room, otherwise one above the other. The focused pane gets the accent border.
+func (m *model) layoutComparePanes() {
+	width, height := m.viewport.Width, m.viewport.Height-1 // One line for each pane's label
+	sideBySide := width >= 100
+	if sideBySide {
+		width = (width - 1) / 2
+	} else {
+		height = height/2 - 1
+	}
+
+	theme := m.styleThemes[m.styleThemeIndex]
+	for i := range m.comparePanes {
+		border := theme.Base
+		if i == m.compareFocus {
+			border = theme.Accent
+		}
+		m.comparePanes[i].viewport.Width = width
+		m.comparePanes[i].viewport.Height = height
+		m.comparePanes[i].viewport.Style = lipgloss.NewStyle().
+			BorderStyle(lipgloss.RoundedBorder()).
+			BorderForeground(border).
+			PaddingLeft(2).
+			PaddingRight(2)
+		m.renderComparePane(i)
+	}
+}
+
+// renderComparePane shows a pane's output, error, or waiting message in its viewport
+func (m *model) renderComparePane(i int) {
+	pane := &m.comparePanes[i]
+
+	var md string
+	switch {
+	case pane.pending:
+		md = fmt.Sprintf("Waiting for %s...", pane.modelKey)
+	case pane.err != nil:
+		md = fmt.Sprintf("## Error\n\nFailed to get response from %s: %v", pane.modelKey, pane.err)
+	default:
+		md = pane.output
+	}
+
+	if err := renderMarkdownToViewportWidth(md, &pane.viewport, m.styleThemes[m.styleThemeIndex], m.config.wrapWidth(&pane.viewport), m.hyperlinks()); err != nil {
+		logf("Error rendering comparison pane: %v", err)
+	}
+}
+
+// --- [ Request Errors ] ---
+
+// updateErrorMode handles keys on the screen shown when a request fails
+func (m model) updateErrorMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.action(scopeError, msg.String()) {
+	// Send the same answers again, to whichever model is now active
+	case actionRetry:
+		if _, ok := m.config.activeModelConfig(); !ok {
+			m.currentMode = modelSelectMode
+			return m, nil
+		}
+		return startLLMRequest(m, m.requestMarkdown)
+
+	// Fix the settings of a model whose API key was rejected
+	case actionFixSettings:
+		if m.rejectedKey != "" {
+			m.selectedModel = m.rejectedKey
+			m.rejectedKey = ""
+			return m.enterAPIKeyInputMode()
+		}
+	}
+	return m, nil
+}
+
+// viewErrorMode renders the screen shown when a request fails
+func (m model) viewErrorMode() string {
+	key := m.keys.shortLabel
+	s := m.appErrorBoundaryView("Request failed") + "\n\n"
+	s += m.styles.ErrorStatus(m.requestErr) + "\n\n"
+
+	if m.rejectedKey != "" {
+		s += fmt.Sprintf("The provider rejected the API key for %s. Press %s to update its settings, then %s to try again.\n", m.rejectedKey, key(actionFixSettings), key(actionRetry))
+	} else {
+		s += fmt.Sprintf("Your answers are kept. Press %s to send them again, or ~ to choose another model first.\n", key(actionRetry))
+	}
+	s += "Check the log file for details.\n\n"
+
+	help := key(actionRetry) + " to retry • ~ to switch model • Esc to return to menu • " + key(actionQuit) + " to quit"
+	if m.rejectedKey != "" {
+		help = key(actionRetry) + " to retry • " + key(actionFixSettings) + " to update settings • ~ to switch model • Esc to return to menu • " + key(actionQuit) + " to quit"
+	}
+	s += m.styles.Help.Render(help) + "\n"
+	return s
+}
+
+// --- [ Onboarding ] ---
+
+// updateWelcomeMode handles keys on the first-run welcome screen
+func (m model) updateWelcomeMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.Type == tea.KeyEnter {
+		m.currentMode = modelSelectMode
+		return m, nil
+	}
+	if m.keys.action(scopeWelcome, msg.String()) == actionSkipSetup {
+		return m.finishOnboarding(), nil
+	}
+	return m, nil
+}
+
+// viewWelcomeMode renders the first-run welcome screen
+func (m model) viewWelcomeMode() string {
+	key := m.keys.shortLabel
+	s := m.appBoundaryView("Welcome to TicketDuck") + "\n\n"
+	s += "TicketDuck turns your stream-of-consciousness notes into a clear write-up.\n"
+	s += "Pick a form (incident report, commit message, service request, or development\n"
+	s += "ticket), answer its questions in your own words, and a language model writes\n"
+	s += "the summary for you to copy, edit, or save.\n\n"
+	s += "Setup takes three quick steps:\n\n"
+	s += "  1. Choose a provider: OpenAI, Anthropic, or a local model server\n"
+	s += "  2. Enter its API key (or server address) and model\n"
+	s += "  3. Pick a color theme\n\n"
+	s += m.styles.Help.Render("Everything can be changed later: ~ for models, Ctrl+t for themes, ? for help") + "\n\n"
+	s += m.styles.Help.Render("Enter to start • "+key(actionSkipSetup)+" to skip setup • "+key(actionQuit)+" or Ctrl+q to quit") + "\n"
+	return s
+}
+
+// onboardingStep describes the current first-run setup step, or returns "" outside of setup
+func (m model) onboardingStep() string {
+	if !m.onboarding {
+		return ""
+	}
+	switch m.currentMode {
+	case modelSelectMode:
+		return "Setup 1/3: choose a provider"
+	case apiKeyInputMode:
+		return "Setup 2/3: enter its settings"
+	case styleSelectMode:
+		return "Setup 3/3: pick a theme"
+	}
+	return ""
+}
+
+// finishOnboarding ends first-run setup, whether it was completed or skipped, so that it
+// isn't shown again
+func (m model) finishOnboarding() model {
+	m.onboarding = false
+	m.config.Onboarded = true
+	if err := m.store.Save(m.config); err != nil {
+		log.Printf("Failed to save config: %v\n", err)
+	}
+
+	// Without a model there's nothing to send the answers to yet
+	m.currentMode = selectionMode
+	if _, ok := m.config.activeModelConfig(); !ok {
+		m.currentMode = modelSelectMode
+	}
+	return m
+}
+
+// --- [ Key Bindings ] ------------------------------------
+
+// keyAction is something a key can be bound to in the keymap. Text input, Enter, Space, Esc,
+// the 1-9 hotkeys and the global keys in reservedKeys are fixed; the rest go through the keymap.
+type keyAction string
+
+const (
+	actionQuit               keyAction = "quit"
+	actionScrollUp           keyAction = "scroll-up"
+	actionScrollDown         keyAction = "scroll-down"
+	actionPageUp             keyAction = "page-up"
+	actionPageDown           keyAction = "page-down"
+	actionHalfPageUp         keyAction = "half-page-up"
+	actionHalfPageDown       keyAction = "half-page-down"
+	actionTop                keyAction = "top"
+	actionBottom             keyAction = "bottom"
+	actionScrollLeft         keyAction = "scroll-left"
+	actionScrollRight        keyAction = "scroll-right"
+	actionToggleMarkdown     keyAction = "toggle-markdown"
+	actionToggleWrap         keyAction = "toggle-wrap"
+	actionRegenerate         keyAction = "regenerate"
+	actionRegenerateUncached keyAction = "regenerate-uncached"
+	actionFollowUp           keyAction = "follow-up"
+	actionNote               keyAction = "note"
+	actionEdit               keyAction = "edit"
+	actionCopy               keyAction = "copy"
+	actionCopyFormat         keyAction = "copy-format"
+	actionSave               keyAction = "save"
+	actionPostSlack          keyAction = "post-slack"
+	actionGitHubIssue        keyAction = "github-issue"
+	actionJira               keyAction = "jira"
+	actionFixSettings        keyAction = "fix-settings"
+
+	// Keys for moving through the lists on the other screens
+	actionMoveUp   keyAction = "move-up"
+	actionMoveDown keyAction = "move-down"
+
+	actionFilter      keyAction = "filter"
+	actionEditPrompt  keyAction = "edit-prompt"
+	actionImport      keyAction = "import-answers"
+	actionHistory     keyAction = "history"
+	actionSend        keyAction = "send"
+	actionPreview     keyAction = "preview-prompt"
+	actionCompare     keyAction = "compare"
+	actionCycleLength keyAction = "cycle-length"
+	actionCycleTone   keyAction = "cycle-tone"
+	actionConfigure   keyAction = "configure"
+	actionAddProvider keyAction = "add-provider"
+	actionDelete      keyAction = "delete"
+	actionNextProfile keyAction = "next-profile"
+	actionSwitchPane  keyAction = "switch-pane"
+	actionRetry       keyAction = "retry"
+	actionSkipSetup   keyAction = "skip-setup"
+)
+
+// keyScope is a screen whose keys are looked up together. Actions in different scopes can
+// share keys; global actions work everywhere, so they can't share a key with any other.
+type keyScope int
+
+const (
+	scopeGlobal keyScope = iota
+	scopeDisplay
+	scopeSelection
+	scopeReview
+	scopeModelSelect
+	scopeStyleSelect
+	scopeHistory
+	scopeCompare
+	scopeError
+	scopeWelcome
+)
+
+// listScopes are the screens with a list moved through by move-up and move-down
+var listScopes = []keyScope{scopeSelection, scopeReview, scopeModelSelect, scopeStyleSelect, scopeHistory}
+
+// actionScopes lists the screens each action works on; display mode has the rest
+var actionScopes = map[keyAction][]keyScope{
+	actionQuit:        {scopeGlobal},
+	actionCopy:        {scopeDisplay, scopeCompare},
+	actionCopyFormat:  {scopeDisplay, scopeCompare},
+	actionFixSettings: {scopeDisplay, scopeError},
+	actionMoveUp:      listScopes,
+	actionMoveDown:    listScopes,
+	actionFilter:      {scopeSelection, scopeModelSelect},
+	actionEditPrompt:  {scopeSelection},
+	actionImport:      {scopeSelection},
+	actionHistory:     {scopeSelection},
+	actionSend:        {scopeReview},
+	actionPreview:     {scopeReview},
+	actionCompare:     {scopeReview},
+	actionCycleLength: {scopeReview},
+	actionCycleTone:   {scopeReview},
+	actionConfigure:   {scopeModelSelect},
+	actionAddProvider: {scopeModelSelect},
+	actionDelete:      {scopeModelSelect, scopeHistory},
+	actionNextProfile: {scopeModelSelect},
+	actionSwitchPane:  {scopeCompare},
+	actionRetry:       {scopeError},
+	actionSkipSetup:   {scopeWelcome},
+}
+
+// scopesOf returns the screens action works on
+func scopesOf(action keyAction) []keyScope {
+	if scopes, ok := actionScopes[action]; ok {
+		return scopes
+	}
+	return []keyScope{scopeDisplay}
+}
+
+// modeKeyScopes maps each mode to the screen its keys are looked up in. Modes without one
+// only have fixed keys.
+var modeKeyScopes = map[mode]keyScope{
+	displayMode:     scopeDisplay,
+	selectionMode:   scopeSelection,
+	reviewMode:      scopeReview,
+	modelSelectMode: scopeModelSelect,
+	styleSelectMode: scopeStyleSelect,
+	historyMode:     scopeHistory,
+	compareMode:     scopeCompare,
+	errorMode:       scopeError,
+	welcomeMode:     scopeWelcome,
+}
+
+// displayKeyActions are the display mode actions in the order the help overlay lists them
+var displayKeyActions = []keyHelp{
+	{string(actionScrollUp), "scroll up one line"},
+	{string(actionScrollDown), "scroll down one line"},
+	{string(actionPageUp), "scroll up one page"},
+	{string(actionPageDown), "scroll down one page"},
+	{string(actionHalfPageUp), "scroll up half a page"},
+	{string(actionHalfPageDown), "scroll down half a page"},
+	{string(actionTop), "jump to top"},
+	{string(actionBottom), "jump to bottom"},
+	{string(actionToggleMarkdown), "toggle raw markdown"},
+	{string(actionToggleWrap), "toggle word wrap (remembered)"},
+	{string(actionScrollLeft), "scroll left while word wrap is off"},
+	{string(actionScrollRight), "scroll right while word wrap is off"},
+	{string(actionRegenerate), "regenerate the summary"},
+	{string(actionRegenerateUncached), "regenerate, skipping the response cache"},
+	{string(actionFollowUp), "ask for changes to the summary (follow-up)"},
+	{string(actionNote), "add, edit or remove an analyst note"},
+	{string(actionEdit), "edit the summary in $EDITOR"},
+	{string(actionCopy), "copy to clipboard"},
+	{string(actionCopyFormat), "cycle copy format (Markdown, Jira, Slack)"},
+	{string(actionSave), "save to a markdown file"},
+	{string(actionPostSlack), "post to Slack (needs slack_webhook_url)"},
+	{string(actionGitHubIssue), "create a GitHub issue (needs github_token and github_repo)"},
+	{string(actionJira), "create a Jira issue or comment on one (needs jira_url and jira_token)"},
+	{string(actionFixSettings), "update the model's settings after its API key was rejected"},
+}
+
+// defaultKeyBindings are the keys of the default preset. A two-key sequence is written with a
+// space between the keys, as in "g g".
+var defaultKeyBindings = map[keyAction][]string{
+	actionQuit:               {"q"},
+	actionScrollUp:           {"up", "k"},
+	actionScrollDown:         {"down", "j"},
+	actionPageUp:             {"pgup"},
+	actionPageDown:           {"pgdown"},
+	actionHalfPageUp:         {"ctrl+u"},
+	actionHalfPageDown:       {"ctrl+d"},
+	actionTop:                {"g g", "home"},
+	actionBottom:             {"G", "end"},
+	actionScrollLeft:         {"left", "h"},
+	actionScrollRight:        {"right", "l"},
+	actionToggleMarkdown:     {"m"},
+	actionToggleWrap:         {"w"},
+	actionRegenerate:         {"r"},
+	actionRegenerateUncached: {"R"},
+	actionFollowUp:           {"a"},
+	actionNote:               {"n"},
+	actionEdit:               {"e"},
+	actionCopy:               {"ctrl+y"},
+	actionCopyFormat:         {"f"},
+	actionSave:               {"ctrl+s"},
+	actionPostSlack:          {"p"},
+	actionGitHubIssue:        {"i"},
+	actionJira:               {"J"},
+	actionFixSettings:        {"c"},
+
+	actionMoveUp:      {"up", "k"},
+	actionMoveDown:    {"down", "j"},
+	actionFilter:      {"/"},
+	actionEditPrompt:  {"p"},
+	actionImport:      {"i"},
+	actionHistory:     {"h"},
+	actionSend:        {"ctrl+d"},
+	actionPreview:     {"p"},
+	actionCompare:     {"c"},
+	actionCycleLength: {"l"},
+	actionCycleTone:   {"t"},
+	actionConfigure:   {"c"},
+	actionAddProvider: {"n"},
+	actionDelete:      {"d"},
+	actionNextProfile: {"p"},
+	actionSwitchPane:  {"tab", "shift+tab", "left", "right", "h", "l"},
+	actionRetry:       {"r"},
+	actionSkipSetup:   {"s"},
+}
+
+// keyPresets are the sets of bindings key_preset chooses from; keys in the config change
+// single actions on top of the preset
+var keyPresets = map[string]map[keyAction][]string{
+	"default": defaultKeyBindings,
+	// vim adds its paging keys, with ctrl+y scrolling as in vim and y yanking (copying)
+	"vim": withKeyBindings(defaultKeyBindings, map[keyAction][]string{
+		actionScrollUp:   {"k", "up", "ctrl+y"},
+		actionScrollDown: {"j", "down", "ctrl+e"},
+		actionPageUp:     {"ctrl+b", "pgup"},
+		actionPageDown:   {"ctrl+f", "pgdown"},
+		actionCopy:       {"y"},
+	}),
+}
+
+// withKeyBindings returns a copy of bindings with some actions' keys replaced
+func withKeyBindings(bindings, changes map[keyAction][]string) map[keyAction][]string {
+	merged := make(map[keyAction][]string, len(bindings))
+	for action, keys := range bindings {
+		merged[action] = keys
+	}
+	for action, keys := range changes {
+		merged[action] = keys
+	}
+	return merged
+}
+
+// reservedKeys are handled before the keymap is consulted, so they can't be rebound
+var reservedKeys = map[string]bool{
+	"esc": true, "ctrl+c": true, "ctrl+q": true, "ctrl+t": true, "f1": true, "?": true, "~": true,
+}
+
+// listKeys choose and open items on the screens besides display mode, so only display mode
+// actions can use them
+var listKeys = map[string]bool{
+	"enter": true, "1": true, "2": true, "3": true, "4": true, "5": true, "6": true, "7": true,
+	"8": true, "9": true,
+}
+
+// namedKeys are the keys, besides single characters and ctrl+ or alt+ combinations, that a
+// binding can use. The names are the ones Bubble Tea reports.
+var namedKeys = map[string]bool{
+	"up": true, "down": true, "left": true, "right": true, "home": true, "end": true,
+	"pgup": true, "pgdown": true, "enter": true, "tab": true, "shift+tab": true,
+	"backspace": true, "delete": true, "insert": true,
+	"f2": true, "f3": true, "f4": true, "f5": true, "f6": true, "f7": true,
+	"f8": true, "f9": true, "f10": true, "f11": true, "f12": true,
+}
+
+// validKey reports whether key names a single key press
+func validKey(key string) bool {
+	if namedKeys[key] {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(key, "ctrl+"); ok {
+		return len(rest) == 1 && rest[0] >= 'a' && rest[0] <= 'z'
+	}
+	if rest, ok := strings.CutPrefix(key, "alt+"); ok {
+		key = rest
+		if namedKeys[key] {
+			return true
+		}
+	}
+	runes := []rune(key)
+	return len(runes) == 1 && unicode.IsPrint(runes[0]) && !unicode.IsSpace(runes[0])
+}
+
+// checkKeys validates the keys configured for action, returning them without duplicates
+func checkKeys(action keyAction, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys given")
+	}
+	displayOnly := slices.Equal(scopesOf(action), []keyScope{scopeDisplay})
+	var checked []string
+	for _, key := range keys {
+		parts := strings.Fields(key)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("%q is not a key or a two-key sequence", key)
+		}
+		for _, part := range parts {
+			if !validKey(part) {
+				return nil, fmt.Errorf("%q is not a key", part)
+			}
+			if reservedKeys[part] {
+				return nil, fmt.Errorf("%s is reserved", part)
+			}
+			if listKeys[part] && !displayOnly {
+				return nil, fmt.Errorf("%q is reserved outside display mode", part)
+			}
+		}
+		// Only display mode waits for the second key of a sequence
+		if len(parts) > 1 && !displayOnly {
+			return nil, fmt.Errorf("two-key sequences like %q only work for display mode actions", key)
+		}
+		// Quit works in every mode, where the other screens use plain keys for their own things
+		if action == actionQuit && (len(parts) > 1 || (key != "q" && !strings.Contains(key, "+") && !(namedKeys[key] && key[0] == 'f'))) {
+			return nil, fmt.Errorf("quit can only be bound to q and ctrl, alt or function keys, not %q", key)
+		}
+		if key = strings.Join(parts, " "); !slices.Contains(checked, key) {
+			checked = append(checked, key)
+		}
+	}
+	return checked, nil
+}
+
+// conflictingActions returns the actions sharing a key with another action on the same screen,
+// sorted. A key that starts a sequence can't also be bound on its own, since it would never get
+// to the second key.
+func conflictingActions(bindings map[keyAction][]string) []keyAction {
+	conflicting := map[keyAction]bool{}
+	mark := func(actions []keyAction) {
+		for _, action := range actions {
+			conflicting[action] = true
+		}
+	}
+
+	// Each screen is checked with the global actions, which work on all of them
+	for scope := scopeDisplay; scope <= scopeWelcome; scope++ {
+		singles := map[string][]keyAction{}
+		sequences := map[string][]keyAction{}
+		prefixes := map[string][]keyAction{}
+		for action, keys := range bindings {
+			scopes := scopesOf(action)
+			if !slices.Contains(scopes, scope) && !slices.Contains(scopes, scopeGlobal) {
+				continue
+			}
+			for _, key := range keys {
+				if first, _, isSequence := strings.Cut(key, " "); isSequence {
+					sequences[key] = append(sequences[key], action)
+					prefixes[first] = append(prefixes[first], action)
+				} else {
+					singles[key] = append(singles[key], action)
+				}
+			}
+		}
+
+		for key, actions := range singles {
+			if len(actions) > 1 {
+				mark(actions)
+			}
+			if len(prefixes[key]) > 0 {
+				mark(actions)
+				mark(prefixes[key])
+			}
+		}
+		for _, actions := range sequences {
+			if len(actions) > 1 {
+				mark(actions)
+			}
+		}
+	}
+
+	var result []keyAction
+	for action := range conflicting {
+		result = append(result, action)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// keymap maps keys, and two-key sequences such as "g g", to the actions they're bound to on
+// each screen
+type keymap struct {
+	bindings map[keyAction][]string
+	actions  map[keyScope]map[string]keyAction
+	prefixes map[keyScope]map[string]bool // First keys of the sequences
+}
+
+// newKeymap builds the keymap from a preset and the per-action keys in the config. Anything
+// that doesn't check out is described in the returned warnings and left as the preset has it:
+// an unknown preset gives the default one, unknown actions are ignored, and actions with no
+// keys, invalid keys, or keys another action also uses keep the preset's keys.
+func newKeymap(preset string, keys map[string][]string) (keymap, []string) {
+	var warnings []string
+	base, ok := keyPresets[preset]
+	if !ok {
+		if preset != "" {
+			warnings = append(warnings, fmt.Sprintf("unknown key preset %q, using default", preset))
+		}
+		base = defaultKeyBindings
+	}
+
+	bindings := withKeyBindings(base, nil)
+	changed := map[keyAction]bool{}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		action := keyAction(name)
+		if _, known := base[action]; !known {
+			warnings = append(warnings, fmt.Sprintf("unknown key action %q, ignoring it", name))
+			continue
+		}
+		checked, err := checkKeys(action, keys[name])
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("keys for %s: %v; using %s", name, err, strings.Join(base[action], ", ")))
+			continue
+		}
+		bindings[action] = checked
+		changed[action] = true
+	}
+
+	// The presets don't conflict, so putting back the changed actions involved in a conflict
+	// ends up with none, though putting one back can clash with another change
+	for {
+		reverted := false
+		for _, action := range conflictingActions(bindings) {
+			if changed[action] {
+				warnings = append(warnings, fmt.Sprintf("keys for %s conflict with another action's; using %s", action, strings.Join(base[action], ", ")))
+				bindings[action] = base[action]
+				changed[action] = false
+				reverted = true
+			}
+		}
+		if !reverted {
+			break
+		}
+	}
+
+	km := keymap{bindings: bindings, actions: map[keyScope]map[string]keyAction{}, prefixes: map[keyScope]map[string]bool{}}
+	for action, keys := range bindings {
+		for _, scope := range scopesOf(action) {
+			if km.actions[scope] == nil {
+				km.actions[scope] = map[string]keyAction{}
+				km.prefixes[scope] = map[string]bool{}
+			}
+			for _, key := range keys {
+				km.actions[scope][key] = action
+				if first, _, isSequence := strings.Cut(key, " "); isSequence {
+					km.prefixes[scope][first] = true
+				}
+			}
+		}
+	}
+	return km, warnings
+}
+
+// keymap builds the keymap the config asks for, warning about anything wrong with it
+func (c Config) keymap() keymap {
+	km, warnings := newKeymap(c.KeyPreset, c.Keys)
+	for _, warning := range warnings {
+		warnf("keymap: %s", warning)
+	}
+	return km
+}
+
+// action returns the action bound to key (or sequence) on a screen, or "" if there is none
+func (k keymap) action(scope keyScope, key string) keyAction {
+	return k.actions[scope][key]
+}
+
+// startsSequence reports whether key is the first key of a two-key sequence bound on a screen
+func (k keymap) startsSequence(scope keyScope, key string) bool {
+	return k.prefixes[scope][key]
+}
+
+// label returns the keys bound to action the way help text shows them, e.g. "↑, k"
+func (k keymap) label(action keyAction) string {
+	labels := make([]string, len(k.bindings[action]))
+	for i, key := range k.bindings[action] {
+		labels[i] = keyLabel(key)
+	}
+	return strings.Join(labels, ", ")
+}
+
+// shortLabel returns the first key bound to action, for the one-line help
+func (k keymap) shortLabel(action keyAction) string {
+	if keys := k.bindings[action]; len(keys) > 0 {
+		return keyLabel(keys[0])
+	}
+	return ""
+}
+
+// help returns bindings for the help overlay with the actions among them replaced by their keys.
+// Entries for fixed keys are kept as they are.
+func (k keymap) help(bindings []keyHelp) []keyHelp {
+	help := make([]keyHelp, len(bindings))
+	for i, h := range bindings {
+		help[i] = h
+		if _, ok := k.bindings[keyAction(h.key)]; ok {
+			help[i].key = k.label(keyAction(h.key))
+		}
+	}
+	return help
+}
+
+// keyArrows are how the arrow keys are shown in help text
+var keyArrows = map[string]string{"up": "↑", "down": "↓", "left": "←", "right": "→"}
+
+// keyLabel formats a key or sequence from the keymap for help text
+func keyLabel(key string) string {
+	if first, second, isSequence := strings.Cut(key, " "); isSequence {
+		return keyLabel(first) + keyLabel(second)
+	}
+	if arrow, ok := keyArrows[key]; ok {
+		return arrow
+	}
+	if rest, ok := strings.CutPrefix(key, "ctrl+"); ok {
+		return "Ctrl+" + rest
+	}
+	if rest, ok := strings.CutPrefix(key, "alt+"); ok {
+		return "Alt+" + keyLabel(rest)
+	}
+	switch key {
+	case "pgup":
+		return "PgUp"
+	case "pgdown":
+		return "PgDown"
+	}
+	return key
+}
+
+// --- [ History ] ------------------------------------
+//
+// Every summary is kept as a JSON file in the history directory under the config directory
+// so it can be browsed and reopened later.
+//
+
+// historyEntry is one generated summary together with what produced it
+type historyEntry struct {
+	FormType  string    `json:"form_type"`
+	Model     string    `json:"model"`      // Key of the model config that was active
+	ModelName string    `json:"model_name"` // The provider's model name, e.g. gpt-4o
+	Timestamp time.Time `json:"timestamp"`
+	Answers   []string  `json:"answers"`
+	Markdown  string    `json:"answers_markdown"` // The answers as sent to the model
+	Output    string    `json:"output"`
+
+	path string // File the entry was loaded from
+}
+
+// getHistoryDir returns the directory summaries are saved to
+func getHistoryDir(configDir string) string {
+	return filepath.Join(configDir, "history")
+}
+
+// saveHistoryEntry writes an entry to the history directory and returns its path
+func saveHistoryEntry(configDir string, entry historyEntry) (string, error) {
+	dir := getHistoryDir(configDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode history entry: %v", err)
+	}
+
+	name := strings.TrimSuffix(defaultSummaryFileName(entry.FormType, entry.Timestamp), ".md") + ".json"
+	path := uniqueFilePath(filepath.Join(dir, name))
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write history entry: %v", err)
+	}
+	return path, nil
+}
+
+// loadHistory reads the saved summaries, newest first. Files that can't be read are logged and skipped.
+func loadHistory(configDir string) ([]historyEntry, error) {
+	files, err := ioutil.ReadDir(getHistoryDir(configDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history directory: %v", err)
+	}
+
+	var entries []historyEntry
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(getHistoryDir(configDir), file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			logf("Skipping history entry %s: %v", path, err)
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			logf("Skipping history entry %s: %v", path, err)
+			continue
+		}
+		entry.path = path
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.After(entries[j].Timestamp)
+	})
+	return entries, nil
+}
+
+// enterHistoryMode loads the saved summaries and switches to the history list
+func (m model) enterHistoryMode() model {
+	entries, err := loadHistory(m.config.dir)
+	if err != nil {
+		logf("Failed to load history: %v", err)
+		m.historyErr = err.Error()
+	} else {
+		m.historyErr = ""
+	}
+	m.historyEntries = entries
+	m.historyCursor = 0
+	m.deletingHistory = false
+	m.currentMode = historyMode
+	return m
+}
+
+// openHistoryEntry shows a saved summary in display mode. The form and answers are restored
+// too, so regenerating and saving work as they do for a fresh summary.
+func (m model) openHistoryEntry(entry historyEntry) model {
+	if form, err := findFormType(m.formTypes, entry.FormType); err == nil && len(entry.Answers) == len(form.questions) {
+		m.currentForm = form
+		m.answers = append([]string(nil), entry.Answers...)
+	} else {
+		// The form has changed or is gone; the summary can still be read, copied and saved
+		m.currentForm = formType{name: entry.FormType}
+		m.answers = nil
+	}
+
+	m = m.stopRequest()
+	m.requestID++ // Ignore anything still arriving from an earlier request
+	m.generating = false
+	m.regenerating = false
+	m.showSpinner = false
+	m.requestMarkdown = entry.Markdown
+	m.gptRawOutput = entry.Output
+	m.analystNote = ""
+	m.generatedBy = entry.ModelName
+	m.generatedAt = entry.Timestamp
+	m.usage = nil // The history doesn't keep it
+	m.conversation = append(formPromptMessages(m.config.requestPrompt(m.currentForm), entry.Markdown),
+		llm.Message{Role: "assistant", Content: entry.Output})
+	m.content = appendSummary(m.requestMarkdown, m.gptRawOutput)
+	m.outputSaved = true // Already kept in the history
+	m.displayStatus = m.styles.SuccessStatus(fmt.Sprintf("Opened summary from %s (%s)", entry.Timestamp.Format("2006-01-02 15:04"), entry.Model))
+	m.currentMode = displayMode
+
+	if err := m.renderContent(); err != nil {
+		logf("Error rendering history entry: %v", err)
+	}
+	m.viewport.GotoTop()
+	return m
+}
+
+// deleteHistoryEntry removes the entry at the cursor from disk and from the list
+func (m model) deleteHistoryEntry() model {
+	entry := m.historyEntries[m.historyCursor]
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		logf("Failed to delete history entry %s: %v", entry.path, err)
+		m.historyErr = fmt.Sprintf("Delete failed: %v", err)
+		return m
+	}
+	logf("Deleted history entry %s", entry.path)
+
+	m.historyEntries = append(m.historyEntries[:m.historyCursor:m.historyCursor], m.historyEntries[m.historyCursor+1:]...)
+	if m.historyCursor >= len(m.historyEntries) && m.historyCursor > 0 {
+		m.historyCursor--
+	}
+	m.historyErr = ""
+	return m
+}
+
+// --- [ Drafts ] ------------------------------------
+//
+// The answers of a form that hasn't been sent yet are kept in draft.json in the config
+// directory, so an interrupted form can be resumed the next time TicketDuck starts.
+//
+
+// formDraft is an unfinished form
+type formDraft struct {
+	FormType        string    `json:"form_type"`
+	Answers         []string  `json:"answers"`
+	CurrentQuestion int       `json:"current_question"`
+	Saved           time.Time `json:"saved"`
+}
+
+// draftPath returns where the draft is kept
+func draftPath(configDir string) string {
+	return filepath.Join(configDir, "draft.json")
+}
+
+// saveDraft writes the draft, replacing any earlier one
+func saveDraft(configDir string, draft formDraft) error {
+	if err := os.MkdirAll(configDir, 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	data, err := json.MarshalIndent(draft, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode draft: %v", err)
+	}
+	if err := ioutil.WriteFile(draftPath(configDir), data, 0600); err != nil {
+		return fmt.Errorf("failed to write draft: %v", err)
+	}
+	return nil
+}
+
+// loadDraft reads the draft, if there is one. A draft that can't be read is logged and ignored.
+func loadDraft(configDir string) (formDraft, bool) {
+	data, err := ioutil.ReadFile(draftPath(configDir))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logf("Failed to read draft: %v", err)
+		}
+		return formDraft{}, false
+	}
+	var draft formDraft
+	if err := json.Unmarshal(data, &draft); err != nil {
+		logf("Failed to parse draft: %v", err)
+		return formDraft{}, false
+	}
+	return draft, true
+}
+
+// clearDraft removes the draft, if there is one
+func clearDraft(configDir string) {
+	if err := os.Remove(draftPath(configDir)); err != nil && !os.IsNotExist(err) {
+		logf("Failed to remove draft: %v", err)
+	}
+}
+
+// saveFormDraft saves the form being answered, including the answer currently being typed
+func (m model) saveFormDraft() {
+	if len(m.answers) != len(m.currentForm.questions) || len(m.answers) == 0 {
+		return
+	}
+	answers := append([]string(nil), m.answers...)
+	if m.currentMode == questionMode {
+		answers[m.currentQuestion] = strings.TrimSpace(m.answerInput.Value())
+	}
+	draft := formDraft{
+		FormType:        m.currentForm.name,
+		Answers:         answers,
+		CurrentQuestion: m.currentQuestion,
+		Saved:           time.Now(),
+	}
+	if err := saveDraft(m.config.dir, draft); err != nil {
+		logf("Failed to save draft: %v", err)
+	}
+}
+
+// draftFormIndex returns the index of the draft's form in m.formTypes, or -1 when the form is
+// gone or its questions no longer line up with the saved answers
+func (m model) draftFormIndex(draft formDraft) int {
+	for i, form := range m.formTypes {
+		if form.name == draft.FormType && len(form.questions) == len(draft.Answers) &&
+			draft.CurrentQuestion >= 0 && draft.CurrentQuestion < len(form.questions) {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateDraftOffer handles the question of whether to resume the draft found at startup:
+// y reopens it at the question it was left on, n or Esc discards it
+func (m model) updateDraftOffer(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "y", "Y":
+		draft := *m.resumeDraft
+		m.resumeDraft = nil
+		m = m.startForm(m.draftFormIndex(draft))
+		copy(m.answers, draft.Answers)
+		m.currentQuestion = draft.CurrentQuestion
+		m.answerInput.SetValue(m.answers[m.currentQuestion])
+		logf("Resumed draft of %q at question %d", draft.FormType, draft.CurrentQuestion+1)
+	case "n", "N", "esc":
+		m.resumeDraft = nil
+		clearDraft(m.config.dir)
+	}
+	return m, nil
 }
 
-var (
-	titleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("#FFFFFF")).
-			Background(lipgloss.Color("#7D56F4")).
-			Padding(0, 1)
+// --- [ Copy Formats ] ------------------------------------
+//
+// Convert the Markdown summary into the markup used by the tools it gets pasted into.
+//
 
-	cursorStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#04B575")).
-			Bold(true)
+// copyFormat is the markup the summary is converted to when copied
+type copyFormat int
 
-	selectedStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FF5F87")).
-			Bold(true)
+const (
+	copyMarkdown copyFormat = iota // Plain Markdown, also right for GitHub
+	copyJira                       // Jira wiki markup
+	copySlack                      // Slack mrkdwn
+	copyFormatCount
+)
 
-	checkedStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#A550DF"))
+// name returns the label shown in the display mode footer
+func (f copyFormat) name() string {
+	switch f {
+	case copyJira:
+		return "Jira"
+	case copySlack:
+		return "Slack"
+	default:
+		return "Markdown/GitHub"
+	}
+}
 
-	dimStyle = lipgloss.NewStyle().
-			Faint(true)
+// next returns the format after f, wrapping back to Markdown
+func (f copyFormat) next() copyFormat {
+	return (f + 1) % copyFormatCount
+}
 
-	helpStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("241"))
+var (
+	mdHeaderRe   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdBulletRe   = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	mdNumberedRe = regexp.MustCompile(`^(\s*)(\d+[.)])\s+(.*)$`)
+	mdFenceRe    = regexp.MustCompile("^\\s*```\\s*(\\S*)")
+	mdRuleRe     = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	mdBoldRe     = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	mdItalicRe   = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*]*?)\*|(^|[^_\w])_([^_\s][^_]*?)_`)
+	mdStrikeRe   = regexp.MustCompile(`~~(.+?)~~`)
+	mdCodeRe     = regexp.MustCompile("`([^`]+)`")
+	mdLinkRe     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
 )
 
-// ---[ Model ]----------------------------------------------------------------
-//
-// This section defines the Model interface (Model as in Model-View-Controller/MVC, not Model as in machine learning model)
-// and its implementation for the bubbletea framework.
+// boldMarker stands in for bold markers while italics are converted
+const boldMarker = "\x00"
+
+// convertMarkdown returns md in the given format
+func convertMarkdown(md string, format copyFormat) string {
+	switch format {
+	case copyJira:
+		return markdownToJira(md)
+	case copySlack:
+		return markdownToSlack(md)
+	default:
+		return md
+	}
+}
+
+// listDepth turns the indentation before a list marker into a nesting level starting at 1
+func listDepth(indent string) int {
+	width := len(strings.ReplaceAll(indent, "\t", "    "))
+	return width/2 + 1
+}
+
+// convertInline rewrites bold, italic, strikethrough, code and links in a single line.
+// Code spans are left alone so their contents are not reformatted.
+func convertInline(line, bold, italic, strike string, code func(string) string, link func(string, string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range mdCodeRe.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(convertEmphasis(line[last:loc[0]], bold, italic, strike, link))
+		b.WriteString(code(line[loc[2]:loc[3]]))
+		last = loc[1]
+	}
+	b.WriteString(convertEmphasis(line[last:], bold, italic, strike, link))
+	return b.String()
+}
+
+// convertEmphasis rewrites the emphasis and links in text that holds no code spans
+func convertEmphasis(text, bold, italic, strike string, link func(string, string) string) string {
+	text = mdLinkRe.ReplaceAllStringFunc(text, func(s string) string {
+		parts := mdLinkRe.FindStringSubmatch(s)
+		return link(parts[1], parts[2])
+	})
+	// Bold is marked with a placeholder first so the italic pass doesn't see its markers
+	text = mdBoldRe.ReplaceAllStringFunc(text, func(s string) string {
+		parts := mdBoldRe.FindStringSubmatch(s)
+		inner := parts[1]
+		if inner == "" {
+			inner = parts[2]
+		}
+		return boldMarker + inner + boldMarker
+	})
+	text = mdItalicRe.ReplaceAllStringFunc(text, func(s string) string {
+		parts := mdItalicRe.FindStringSubmatch(s)
+		if parts[2] != "" {
+			return parts[1] + italic + parts[2] + italic
+		}
+		return parts[3] + italic + parts[4] + italic
+	})
+	text = mdStrikeRe.ReplaceAllString(text, strike+"$1"+strike)
+	return strings.ReplaceAll(text, boldMarker, bold)
+}
+
+// markdownToJira converts Markdown to Jira wiki markup
+func markdownToJira(md string) string {
+	code := func(s string) string { return "{{" + s + "}}" }
+	link := func(text, url string) string { return "[" + text + "|" + url + "]" }
+
+	var out []string
+	inFence := false
+	for _, line := range strings.Split(md, "\n") {
+		if m := mdFenceRe.FindStringSubmatch(line); m != nil {
+			if inFence {
+				out = append(out, "{code}")
+			} else if m[1] != "" {
+				out = append(out, "{code:"+m[1]+"}")
+			} else {
+				out = append(out, "{code}")
+			}
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+
+		switch {
+		case mdHeaderRe.MatchString(line):
+			m := mdHeaderRe.FindStringSubmatch(line)
+			line = fmt.Sprintf("h%d. %s", len(m[1]), convertInline(m[2], "*", "_", "-", code, link))
+		case mdRuleRe.MatchString(line):
+			line = "----"
+		case mdBulletRe.MatchString(line):
+			m := mdBulletRe.FindStringSubmatch(line)
+			line = strings.Repeat("*", listDepth(m[1])) + " " + convertInline(m[2], "*", "_", "-", code, link)
+		case mdNumberedRe.MatchString(line):
+			m := mdNumberedRe.FindStringSubmatch(line)
+			line = strings.Repeat("#", listDepth(m[1])) + " " + convertInline(m[3], "*", "_", "-", code, link)
+		default:
+			line = convertInline(line, "*", "_", "-", code, link)
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// markdownToSlack converts Markdown to Slack mrkdwn
+func markdownToSlack(md string) string {
+	code := func(s string) string { return "`" + s + "`" }
+	link := func(text, url string) string { return "<" + url + "|" + text + ">" }
+
+	var out []string
+	inFence := false
+	for _, line := range strings.Split(md, "\n") {
+		// Slack has code blocks but no language hints
+		if mdFenceRe.MatchString(line) {
+			out = append(out, "```")
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+
+		switch {
+		case mdHeaderRe.MatchString(line):
+			// Slack has no headers, so they become bold lines
+			m := mdHeaderRe.FindStringSubmatch(line)
+			line = "*" + convertInline(m[2], "", "_", "~", code, link) + "*"
+		case mdRuleRe.MatchString(line):
+			line = "———"
+		case mdBulletRe.MatchString(line):
+			m := mdBulletRe.FindStringSubmatch(line)
+			line = strings.Repeat("    ", listDepth(m[1])-1) + "• " + convertInline(m[2], "*", "_", "~", code, link)
+		case mdNumberedRe.MatchString(line):
+			// Numbered items already read fine in Slack; only the inline markup changes
+			m := mdNumberedRe.FindStringSubmatch(line)
+			line = m[1] + m[2] + " " + convertInline(m[3], "*", "_", "~", code, link)
+		default:
+			line = convertInline(line, "*", "_", "~", code, link)
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// --- [ Notifications ] ------------------------------------
+
+// notifyCommand returns the command that shows a desktop notification on this OS, or nil
+// when no notifier is available
+func notifyCommand(title, body string) *exec.Cmd {
+	var name string
+	var args []string
+	switch runtime.GOOS {
+	case "darwin":
+		quote := func(s string) string {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+		}
+		name = "osascript"
+		args = []string{"-e", "display notification " + quote(body) + " with title " + quote(title)}
+	case "windows":
+		quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+		name = "powershell"
+		args = []string{"-NoProfile", "-NonInteractive", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms; " +
+				"$n = New-Object System.Windows.Forms.NotifyIcon; " +
+				"$n.Icon = [System.Drawing.SystemIcons]::Information; $n.Visible = $true; " +
+				"$n.ShowBalloonTip(5000, " + quote(title) + ", " + quote(body) + ", 'Info'); " +
+				"Start-Sleep -Seconds 6; $n.Dispose()"}
+	default:
+		name = "notify-send"
+		args = []string{"--app-name=TicketDuck", title, body}
+	}
+
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil
+	}
+	return exec.Command(path, args...)
+}
+
+// notifyRequestDone tells the desktop that a summary request has finished. The notifier
+// runs in the background, and a missing or failing one is only logged.
+func notifyRequestDone(entry historyEntry, err error) {
+	body := fmt.Sprintf("%s summary from %s (%s) is ready", entry.FormType, entry.Model, entry.ModelName)
+	if err != nil {
+		body = fmt.Sprintf("%s summary from %s (%s) failed", entry.FormType, entry.Model, entry.ModelName)
+	}
+
+	cmd := notifyCommand("TicketDuck", body)
+	if cmd == nil {
+		logf("No desktop notifier found, skipping notification")
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		logf("Failed to show desktop notification: %v", err)
+		return
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			logf("Desktop notifier exited with an error: %v", err)
+		}
+	}()
+}
+
+// --- [ Slack ] ------------------------------------
 //
-// The Model interface is used to manage the state and behavior of the application.
-// It defines the Update method, which is called when a message is received from the terminal.
+// Summaries can be posted to a Slack channel through an incoming webhook, converted to Slack's
+// mrkdwn. The webhook URL is a secret: it's encrypted along with the API keys and never logged.
 //
 
-type model struct {
-	currentMode mode
-	styles      *Styles
+// slackWebhookEnvVar overrides slack_webhook_url from the config file when set
+const slackWebhookEnvVar = "TICKETDUCK_SLACK_WEBHOOK_URL"
 
-	// For selection mode:
-	formTypes     []formType
-	cursor        int
-	selectedIndex int // The index of the selected item, where -1 means no item is selected
+// slackPostTimeout is how long posting to the webhook may take
+const slackPostTimeout = 15 * time.Second
 
-	// For rubric mode:
-	currentForm     formType
-	answers         []string
-	currentQuestion int
-	inputString     string
+// slackWebhookURL returns the webhook summaries are posted to, or "" if none is set.
+// The environment takes precedence over the config file.
+func (c Config) slackWebhookURL() string {
+	if v := strings.TrimSpace(os.Getenv(slackWebhookEnvVar)); v != "" {
+		return v
+	}
+	return strings.TrimSpace(c.SlackWebhookURL)
+}
 
-	// For display mode:
-	viewport viewport.Model
-	// Store the raw output from the LLM so we can re-render if needed.
-	gptRawOutput string
-	// Store the rendered markdown content so we can re-display or update if needed.
-	content string
+// slackPostMsg carries the result of posting a summary to Slack
+type slackPostMsg struct {
+	id  int // requestID of the summary that was posted
+	err error
+}
 
-	gPressed bool // Used only to detect "gg" in display mode
+// postSummaryToSlack returns a command that posts the summary shown to the Slack webhook
+func (m model) postSummaryToSlack() (model, tea.Cmd) {
+	if m.postingSlack || m.generating || m.gptRawOutput == "" {
+		return m, nil
+	}
+	webhookURL := m.config.slackWebhookURL()
+	if webhookURL == "" {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("No Slack webhook set; add slack_webhook_url to %s or set %s", configFileName(m.config.profile), slackWebhookEnvVar))
+		return m, nil
+	}
 
-	// For API key input mode:
-	apiKeyInput    textinput.Model
-	apiBaseInput   textinput.Model
-	modelNameInput textinput.Model
-	focusedInput   int // 0 for API key, 1 for base URL, 2 for model name, 3 for save checkbox
-	saveConfig     bool
+	m.postingSlack = true
+	m.displayStatus = m.styles.StatusHeader.Render("Posting to Slack...")
+	id := m.requestID
+	text := markdownToSlack(stripansi.Strip(m.gptRawOutput))
+	proxyURL := m.config.ProxyURL
+	return m, func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), slackPostTimeout)
+		defer cancel()
 
-	// For model selection:
-	config        Config
-	modelCursor   int
-	modelKeys     []string // Keys from the Models map for easier navigation
-	selectedModel string   // Currently selected model key
+		httpClient, err := newHTTPClient(proxyURL)
+		if err != nil {
+			return slackPostMsg{id: id, err: err}
+		}
+		return slackPostMsg{id: id, err: postToSlack(ctx, httpClient, webhookURL, text)}
+	}
+}
 
-	width int // Added for appBoundaryView
+// handleSlackPost shows whether posting to Slack worked
+func (m model) handleSlackPost(msg slackPostMsg) model {
+	m.postingSlack = false
+	if msg.err != nil {
+		logf("Failed to post summary to Slack: %v", msg.err)
+	} else {
+		logf("Posted summary to Slack")
+	}
 
-	// For style selection:
-	styleThemeIndex int
-	styleThemes     []StyleTheme
+	// Another summary is shown by now, so the status would be about the wrong one
+	if msg.id != m.requestID || m.currentMode != displayMode {
+		return m
+	}
+	if msg.err != nil {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Slack post failed: %v", msg.err))
+	} else {
+		m.outputSaved = true
+		m.displayStatus = m.styles.SuccessStatus("Posted to Slack")
+	}
+	return m
 }
 
-// initialModel sets up the choicebox, selection data, and an uninitialized viewport.
-func initialModel() model {
-	// Load config with model information
-	config, err := loadConfig()
+// postToSlack sends text to a Slack incoming webhook. Errors never include the URL, since
+// it grants access to the channel.
+func postToSlack(ctx context.Context, httpClient *http.Client, webhookURL, text string) error {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("the Slack webhook URL isn't a valid http(s) URL")
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
 	if err != nil {
-		log.Printf("Warning: Failed to load config: %v\n", err)
-		config = Config{
-			ActiveModel: "", // No default model selected
-			Models:      DefaultModelConfigs,
+		return fmt.Errorf("failed to encode message: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("the Slack webhook URL isn't a valid http(s) URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
 		}
+		return fmt.Errorf("couldn't reach Slack: %v", err)
 	}
+	defer resp.Body.Close()
 
-	// Create sorted list of model keys for UI navigation
-	modelKeys := make([]string, 0, len(config.Models))
-	for k := range config.Models {
-		modelKeys = append(modelKeys, k)
+	if resp.StatusCode == http.StatusOK {
+		return nil
 	}
-	sort.Strings(modelKeys)
+	// Slack explains rejections with a short code in the body, e.g. "invalid_payload"
+	detail, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("Slack returned %s%s", resp.Status, slackErrorHint(strings.TrimSpace(string(detail))))
+}
 
-	// Set up API key input field
-	tiKey := textinput.New()
-	tiKey.Placeholder = "Enter API key here..."
-	tiKey.Focus()
-	tiKey.CharLimit = 1000
-	tiKey.Width = 60
+// slackErrorHint explains the error codes Slack's webhooks answer with
+func slackErrorHint(code string) string {
+	switch code {
+	case "":
+		return ""
+	case "invalid_token", "no_service", "no_team", "team_disabled":
+		return " (" + code + "): the webhook URL is no longer valid"
+	case "channel_is_archived", "channel_not_found":
+		return " (" + code + "): the webhook's channel is gone or archived"
+	case "action_prohibited", "posting_to_general_channel_denied":
+		return " (" + code + "): the webhook isn't allowed to post to its channel"
+	case "invalid_payload", "no_text":
+		return " (" + code + "): the message was rejected"
+	}
+	// Anything else may be a page from a proxy rather than Slack's answer
+	if len(code) > 100 || strings.ContainsAny(code, "<\n") {
+		return ""
+	}
+	return ": " + code
+}
 
-	// Set up API base URL input field
-	tiBase := textinput.New()
-	tiBase.Placeholder = "http://localhost:8000/v1"
-	tiBase.CharLimit = 100
-	tiBase.Width = 60
+// --- [ GitHub ] ------------------------------------
+//
+// A summary can be turned into an issue in a GitHub repository: its first heading becomes the
+// title and the rest the body. Like the Slack webhook URL, the token is a secret that's
+// encrypted with the API keys and never logged.
+//
 
-	// Set up model name input field
-	tiModelName := textinput.New()
-	tiModelName.Placeholder = "Model name for API requests (e.g., llama3)"
-	tiModelName.CharLimit = 100
-	tiModelName.Width = 60
+const (
+	// githubTokenEnvVar overrides github_token from the config file when set
+	githubTokenEnvVar = "TICKETDUCK_GITHUB_TOKEN"
 
-	// Always start with selection mode, let the user navigate to model selection if needed
-	initialMode := selectionMode
+	defaultGitHubAPIURL = "https://api.github.com"
+	githubTimeout       = 15 * time.Second
+	maxIssueTitleLength = 256 // GitHub rejects longer titles
+)
 
-	// If no active model is set or it's empty, go to model selection first
-	if config.ActiveModel == "" {
-		initialMode = modelSelectMode
+// githubRepoPattern matches an owner/name repository
+var githubRepoPattern = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+
+// githubToken returns the token issues are created with, or "" if none is set.
+// The environment takes precedence over the config file.
+func (c Config) githubToken() string {
+	if v := strings.TrimSpace(os.Getenv(githubTokenEnvVar)); v != "" {
+		return v
 	}
+	return strings.TrimSpace(c.GitHubToken)
+}
 
-	m := model{
-		currentMode:     initialMode,
-		formTypes:       formTypes,
-		selectedIndex:   -1,
-		answers:         []string{},
-		viewport:        viewport.Model{}, // We'll configure this later
-		apiKeyInput:     tiKey,
-		apiBaseInput:    tiBase,
-		modelNameInput:  tiModelName,
-		focusedInput:    0,
-		saveConfig:      true,
-		config:          config,
-		modelKeys:       modelKeys,
-		selectedModel:   config.ActiveModel,
-		modelCursor:     indexOf(modelKeys, config.ActiveModel),
-		styleThemes:     styleThemes,
-		styleThemeIndex: 0,
-		styles:          NewStyles(lipgloss.DefaultRenderer(), styleThemes[0]),
-		width:           80, // Assuming a default width
+// githubIssueMsg carries the result of creating an issue
+type githubIssueMsg struct {
+	id     int // requestID of the summary the issue was created from
+	repo   string
+	number int
+	url    string
+	err    error
+}
+
+// issueFromSummary splits a summary into an issue title, the text of its first heading, and
+// a body, everything else. Without a heading, the first line is the title.
+func issueFromSummary(md string) (title, body string) {
+	lines := strings.Split(strings.TrimSpace(md), "\n")
+	titleLine := -1
+	for i, line := range lines {
+		if match := mdHeaderRe.FindStringSubmatch(line); match != nil {
+			titleLine = i
+			title = match[2]
+			break
+		}
+	}
+	if titleLine < 0 {
+		titleLine = 0
+		title = strings.TrimSpace(lines[0])
+	}
+	title = strings.Trim(title, "*_ ")
+	if runes := []rune(title); len(runes) > maxIssueTitleLength {
+		title = string(runes[:maxIssueTitleLength-1]) + "…"
+	}
+
+	after := titleLine + 1
+	if after < len(lines) && strings.TrimSpace(lines[after]) == "" {
+		after++ // The heading's blank line would otherwise double up with the one before it
+	}
+	rest := append(append([]string(nil), lines[:titleLine]...), lines[after:]...)
+	return title, strings.TrimSpace(strings.Join(rest, "\n"))
+}
+
+// createIssueFromSummary returns a command that creates a GitHub issue from the summary shown
+func (m model) createIssueFromSummary() (model, tea.Cmd) {
+	if m.creatingIssue || m.generating || m.gptRawOutput == "" {
+		return m, nil
+	}
+	token := m.config.githubToken()
+	repo := strings.TrimSpace(m.config.GitHubRepo)
+	if token == "" || repo == "" {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("GitHub isn't set up; add github_token and github_repo to %s (or set %s for the token)", configFileName(m.config.profile), githubTokenEnvVar))
+		return m, nil
+	}
+	if !githubRepoPattern.MatchString(repo) {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("github_repo should look like owner/name, not %q", repo))
+		return m, nil
+	}
+
+	title, body := issueFromSummary(stripansi.Strip(m.gptRawOutput))
+	if title == "" {
+		title = m.currentForm.name
+	}
+	m.creatingIssue = true
+	m.displayStatus = m.styles.StatusHeader.Render(fmt.Sprintf("Creating an issue in %s...", repo))
+	id := m.requestID
+	apiURL := m.config.GitHubAPIURL
+	proxyURL := m.config.ProxyURL
+	return m, func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), githubTimeout)
+		defer cancel()
+
+		httpClient, err := newHTTPClient(proxyURL)
+		if err != nil {
+			return githubIssueMsg{id: id, repo: repo, err: err}
+		}
+		number, issueURL, err := createGitHubIssue(ctx, httpClient, apiURL, token, repo, title, body)
+		return githubIssueMsg{id: id, repo: repo, number: number, url: issueURL, err: err}
+	}
+}
+
+// handleGitHubIssue shows the new issue's URL, clickable where the terminal allows, or why it
+// couldn't be created
+func (m model) handleGitHubIssue(msg githubIssueMsg) model {
+	m.creatingIssue = false
+	if msg.err != nil {
+		logf("Failed to create GitHub issue in %s: %v", msg.repo, msg.err)
+	} else {
+		logf("Created GitHub issue %s", msg.url)
+	}
+
+	// Another summary is shown by now, so the status would be about the wrong one
+	if msg.id != m.requestID || m.currentMode != displayMode {
+		return m
+	}
+	if msg.err != nil {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Creating the issue failed: %v", msg.err))
+		return m
+	}
+	link := msg.url
+	if m.hyperlinks() {
+		link = osc8Link(msg.url, msg.url)
+	}
+	m.outputSaved = true
+	m.displayStatus = m.styles.SuccessStatus(fmt.Sprintf("Created issue #%d: ", msg.number)) + link
+	return m
+}
+
+// createGitHubIssue opens an issue in repo and returns its number and web URL. apiURL is ""
+// for github.com.
+func createGitHubIssue(ctx context.Context, httpClient *http.Client, apiURL, token, repo, title, body string) (int, string, error) {
+	if apiURL == "" {
+		apiURL = defaultGitHubAPIURL
+	}
+	payload, err := json.Marshal(map[string]string{"title": title, "body": body})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to encode issue: %v", err)
+	}
+	endpoint := strings.TrimRight(apiURL, "/") + "/repos/" + repo + "/issues"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid github_api_url %q", apiURL)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, "", fmt.Errorf("couldn't reach GitHub: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read GitHub's response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &failure)
+		return 0, "", githubError(resp, repo, failure.Message)
+	}
+
+	var issue struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.Unmarshal(data, &issue); err != nil || issue.HTMLURL == "" {
+		return 0, "", fmt.Errorf("unexpected response from GitHub: %s", resp.Status)
+	}
+	return issue.Number, issue.HTMLURL, nil
+}
+
+// githubError explains a failed request to the GitHub API, along with GitHub's own message
+func githubError(resp *http.Response, repo, message string) error {
+	var reason string
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		reason = "GitHub rejected the token; check that github_token is valid and hasn't expired"
+	case http.StatusForbidden:
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			reason = "GitHub's rate limit was reached; try again later"
+		} else {
+			reason = fmt.Sprintf("the token isn't allowed to create issues in %s; it needs the repo scope, or Issues write access for a fine-grained token", repo)
+		}
+	case http.StatusNotFound:
+		reason = fmt.Sprintf("%s wasn't found, or the token can't see it", repo)
+	case http.StatusGone:
+		reason = fmt.Sprintf("issues are turned off for %s", repo)
+	case http.StatusUnprocessableEntity:
+		reason = "GitHub rejected the issue"
+	default:
+		reason = "GitHub returned " + resp.Status
+	}
+	if message != "" {
+		return fmt.Errorf("%s (%s)", reason, message)
+	}
+	return errors.New(reason)
+}
+
+// --- [ Jira ] ------------------------------------
+//
+// A summary can be posted to Jira as a new issue, titled with its first heading, or as a
+// comment on an existing one. Version 2 of the REST API is used because it takes Jira wiki
+// markup, which markdownToJira produces. Jira Cloud is signed in to with an email address and
+// API token; without an email, the token is sent as a Server/Data Center personal access token.
+//
+
+const (
+	// jiraTokenEnvVar overrides jira_token from the config file when set
+	jiraTokenEnvVar = "TICKETDUCK_JIRA_TOKEN"
+
+	defaultJiraIssueType = "Task"
+	jiraTimeout          = 15 * time.Second
+)
+
+// jiraIssueKeyPattern matches an issue key such as PROJ-123
+var jiraIssueKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+
+// jiraSettings is what a request to Jira needs, copied out of the config
+type jiraSettings struct {
+	baseURL   string
+	email     string
+	token     string
+	project   string
+	issueType string
+}
+
+// jiraToken returns the API token for Jira, or "" if none is set.
+// The environment takes precedence over the config file.
+func (c Config) jiraToken() string {
+	if v := strings.TrimSpace(os.Getenv(jiraTokenEnvVar)); v != "" {
+		return v
 	}
+	return strings.TrimSpace(c.JiraToken)
+}
 
-	return m
+// jiraConfigured reports whether there's enough set up to reach Jira
+func (c Config) jiraConfigured() bool {
+	return strings.TrimSpace(c.JiraURL) != "" && c.jiraToken() != ""
 }
 
-// indexOf returns the index of a string in a slice, or 0 if not found
-func indexOf(slice []string, item string) int {
-	for i, s := range slice {
-		if s == item {
-			return i
-		}
+// jiraSettings returns the Jira settings, with defaults filled in
+func (c Config) jiraSettings() jiraSettings {
+	issueType := strings.TrimSpace(c.JiraIssueType)
+	if issueType == "" {
+		issueType = defaultJiraIssueType
+	}
+	return jiraSettings{
+		baseURL:   strings.TrimRight(strings.TrimSpace(c.JiraURL), "/"),
+		email:     strings.TrimSpace(c.JiraEmail),
+		token:     c.jiraToken(),
+		project:   strings.TrimSpace(c.JiraProject),
+		issueType: issueType,
 	}
-	return 0
 }
 
-func (m model) Init() tea.Cmd {
-	return nil
+// jiraPostMsg carries the result of posting a summary to Jira
+type jiraPostMsg struct {
+	id      int    // requestID of the summary that was posted
+	key     string // Issue created or commented on
+	url     string
+	comment bool
+	err     error
 }
 
-func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	// Handle terminal resize events
-	case tea.WindowSizeMsg:
-		// Use the new dimensions provided by msg
-		termWidth := msg.Width
-		termHeight := msg.Height
+// openJiraPrompt asks which issue the summary should be added to, or whether to create one
+func (m model) openJiraPrompt() (model, tea.Cmd) {
+	if m.submittingJira || m.generating || m.gptRawOutput == "" {
+		return m, nil
+	}
+	if !m.config.jiraConfigured() {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Jira isn't set up; add jira_url and jira_token to %s (or set %s for the token)", configFileName(m.config.profile), jiraTokenEnvVar))
+		return m, nil
+	}
+	m.askingJiraKey = true
+	m.displayStatus = ""
+	m.jiraInput.Reset()
+	return m, m.jiraInput.Focus()
+}
 
-		// Define margins or offsets as used previously
-		marginWidth := 4  // e.g., borders, padding
-		marginHeight := 8 // e.g., header/footer
+// updateJiraPrompt handles user input while asking for the Jira issue
+func (m model) updateJiraPrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.askingJiraKey = false
+		m.jiraInput.Blur()
+		return m, nil
 
-		// Calculate new dimensions for the viewport
-		width := termWidth - marginWidth
-		height := termHeight - marginHeight
-		if width < 40 {
-			width = 40
+	case tea.KeyEnter:
+		issueKey := strings.ToUpper(strings.TrimSpace(m.jiraInput.Value()))
+		if issueKey != "" && !jiraIssueKeyPattern.MatchString(issueKey) {
+			m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("%q isn't an issue key like PROJ-123", issueKey))
+			return m, nil
 		}
-		if height < 10 {
-			height = 10
+		if issueKey == "" && m.config.JiraProject == "" {
+			m.displayStatus = m.styles.ErrorStatus("Enter an issue key, or set jira_project to create issues")
+			return m, nil
 		}
+		m.askingJiraKey = false
+		m.jiraInput.Blur()
+		return m.submitToJira(issueKey)
+	}
 
-		// Update the viewport dimensions and style
-		m.viewport.Width = width
-		m.viewport.Height = height
-		m.viewport.Style = lipgloss.NewStyle().
-			BorderStyle(lipgloss.RoundedBorder()).
-			BorderForeground(m.styleThemes[m.styleThemeIndex].Base).
-			PaddingLeft(2).
-			PaddingRight(2)
+	var cmd tea.Cmd
+	m.displayStatus = ""
+	m.jiraInput, cmd = m.jiraInput.Update(msg)
+	return m, cmd
+}
 
-		// If in display mode, re-render the markdown to adjust wrapping
-		if m.currentMode == displayMode {
-			theme := m.styleThemes[m.styleThemeIndex]
-			if err := renderMarkdownToViewport(m.content, &m.viewport, theme); err != nil {
-				log.Printf("Error re-rendering markdown on resize: %v\n", err)
-			}
+// submitToJira returns a command that adds the summary to issueKey as a comment, or creates
+// an issue from it when issueKey is ""
+func (m model) submitToJira(issueKey string) (model, tea.Cmd) {
+	settings := m.config.jiraSettings()
+	summary := stripansi.Strip(m.gptRawOutput)
+
+	m.submittingJira = true
+	id := m.requestID
+	proxyURL := m.config.ProxyURL
+	post := func(ctx context.Context, httpClient *http.Client) jiraPostMsg {
+		key, commentURL, err := commentOnJiraIssue(ctx, httpClient, settings, issueKey, markdownToJira(summary))
+		return jiraPostMsg{id: id, key: key, url: commentURL, comment: true, err: err}
+	}
+	if issueKey == "" {
+		title, body := issueFromSummary(summary)
+		if title == "" {
+			title = m.currentForm.name
 		}
-		// Return without further commands, as resizing is now handled.
-		return m, nil
-
-	// Handle other message types based on current mode
-	case tea.KeyMsg:
-		// Global key handlers that work in any mode
-		switch msg.Type {
-		case tea.KeyCtrlQ:
-			return m, tea.Quit
-		case tea.KeyEsc:
-			// Return to main menu from any mode except selection mode
-			if m.currentMode != selectionMode {
-				m.currentMode = selectionMode
-				return m, nil
-			}
-		case tea.KeyRunes:
-			if msg.String() == "~" {
-				// Add global shortcut to switch to model selection mode
-				m.currentMode = modelSelectMode
-				return m, nil
-			}
-		case tea.KeyCtrlT:
-			// Add global shortcut to switch to style selection mode
-			m.currentMode = styleSelectMode
-			return m, nil
+		post = func(ctx context.Context, httpClient *http.Client) jiraPostMsg {
+			key, issueURL, err := createJiraIssue(ctx, httpClient, settings, title, markdownToJira(body))
+			return jiraPostMsg{id: id, key: key, url: issueURL, err: err}
 		}
+		m.displayStatus = m.styles.StatusHeader.Render(fmt.Sprintf("Creating a Jira issue in %s...", settings.project))
+	} else {
+		m.displayStatus = m.styles.StatusHeader.Render(fmt.Sprintf("Commenting on %s...", issueKey))
+	}
 
-		// Mode-specific key handlers
-		switch m.currentMode {
-		case selectionMode:
-			return m.updateSelectionMode(msg)
-		case questionMode:
-			return m.updateQuestionMode(msg)
-		case displayMode:
-			return m.updateDisplayMode(msg)
-		case apiKeyInputMode:
-			return m.updateAPIKeyInputMode(msg)
-		case modelSelectMode:
-			return m.updateModelSelectMode(msg)
-		case styleSelectMode:
-			return m.updateStyleSelectMode(msg)
+	return m, func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), jiraTimeout)
+		defer cancel()
+
+		httpClient, err := newHTTPClient(proxyURL)
+		if err != nil {
+			return jiraPostMsg{id: id, key: issueKey, comment: issueKey != "", err: err}
 		}
+		return post(ctx, httpClient)
 	}
-	return m, nil
 }
 
-// updateAPIKeyInputMode handles user input in the API key input mode
-func (m model) updateAPIKeyInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
-
-	// Get the currently selected model config
-	modelConfig := m.config.Models[m.selectedModel]
-	isLocalModel := modelConfig.Provider == ProviderLocal
+// handleJiraPost shows the issue the summary was posted to, or why it couldn't be
+func (m model) handleJiraPost(msg jiraPostMsg) model {
+	m.submittingJira = false
+	if msg.err != nil {
+		logf("Failed to post summary to Jira: %v", msg.err)
+	} else {
+		logf("Posted summary to Jira: %s", msg.url)
+	}
 
-	switch msg.Type {
-	case tea.KeyCtrlC, tea.KeyEsc:
-		return m, tea.Quit
+	// Another summary is shown by now, so the status would be about the wrong one
+	if msg.id != m.requestID || m.currentMode != displayMode {
+		return m
+	}
+	if msg.err != nil {
+		action := "Creating the Jira issue"
+		if msg.comment {
+			action = "Commenting on " + msg.key
+		}
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("%s failed: %v", action, msg.err))
+		return m
+	}
+	link := msg.url
+	if m.hyperlinks() {
+		link = osc8Link(msg.url, msg.url)
+	}
+	done := "Created " + msg.key
+	if msg.comment {
+		done = "Commented on " + msg.key
+	}
+	m.outputSaved = true
+	m.displayStatus = m.styles.SuccessStatus(done+": ") + link
+	return m
+}
 
-	case tea.KeyEnter:
-		if isLocalModel {
-			// For local models, we need to save the API base URL and model name
-			baseURL := strings.TrimSpace(m.apiBaseInput.Value())
-			modelName := strings.TrimSpace(m.modelNameInput.Value())
+// createJiraIssue creates an issue in the configured project and returns its key and URL
+func createJiraIssue(ctx context.Context, httpClient *http.Client, settings jiraSettings, title, description string) (string, string, error) {
+	payload := map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": settings.project},
+			"issuetype":   map[string]string{"name": settings.issueType},
+			"summary":     title,
+			"description": description,
+		},
+	}
+	data, err := jiraRequest(ctx, httpClient, settings, "/rest/api/2/issue", payload)
+	if err != nil {
+		return "", "", err
+	}
 
-			// If base URL is empty, keep default
-			if baseURL == "" {
-				baseURL = "http://localhost:11434"
-			}
+	var issue struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(data, &issue); err != nil || issue.Key == "" {
+		return "", "", errors.New("unexpected response from Jira")
+	}
+	return issue.Key, settings.baseURL + "/browse/" + issue.Key, nil
+}
 
-			// If model name is empty, use a default
-			if modelName == "" {
-				modelName = "llama3"
-			}
+// commentOnJiraIssue adds a comment to an issue and returns the issue key and the comment's URL
+func commentOnJiraIssue(ctx context.Context, httpClient *http.Client, settings jiraSettings, issueKey, body string) (string, string, error) {
+	data, err := jiraRequest(ctx, httpClient, settings, "/rest/api/2/issue/"+issueKey+"/comment", map[string]string{"body": body})
+	if err != nil {
+		return "", "", err
+	}
 
-			m.config.Models[m.selectedModel] = ModelConfig{
-				Provider:   modelConfig.Provider,
-				ModelName:  modelName,
-				APIBaseURL: baseURL,
-			}
-		} else {
-			// For remote models, we need to save the API key and model name
-			apiKey := strings.TrimSpace(m.apiKeyInput.Value())
-			modelName := strings.TrimSpace(m.modelNameInput.Value())
-
-			// If model name is empty, use the default from the provider
-			if modelName == "" {
-				if modelConfig.Provider == ProviderOpenAI {
-					modelName = "gpt-3.5-turbo"
-				} else if modelConfig.Provider == ProviderAnthropic {
-					modelName = "claude-3-sonnet-20240229"
-				}
-			}
+	issueURL := settings.baseURL + "/browse/" + issueKey
+	var comment struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(data, &comment) == nil && comment.ID != "" {
+		issueURL += "?focusedCommentId=" + comment.ID
+	}
+	return issueKey, issueURL, nil
+}
 
-			logf("Saved API key length: %d characters, model name: %s", len(apiKey), modelName)
+// jiraRequest posts payload to a Jira REST endpoint and returns the response body. Errors
+// never include the token.
+func jiraRequest(ctx context.Context, httpClient *http.Client, settings jiraSettings, path string, payload interface{}) ([]byte, error) {
+	u, err := url.Parse(settings.baseURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("jira_url should be the site's address, e.g. https://example.atlassian.net, not %q", settings.baseURL)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if settings.email != "" {
+		req.SetBasicAuth(settings.email, settings.token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+settings.token)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
 
-			m.config.Models[m.selectedModel] = ModelConfig{
-				Provider:  modelConfig.Provider,
-				ModelName: modelName,
-				APIKey:    apiKey,
-			}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
 		}
+		return nil, fmt.Errorf("couldn't reach Jira: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Jira's response: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, jiraError(resp, data)
+	}
+	return data, nil
+}
 
-		// Save the config if the checkbox is checked
-		if m.saveConfig {
-			if err := saveConfig(m.config); err != nil {
-				log.Printf("Failed to save config: %v\n", err)
-			}
-		}
+// jiraError explains a failed request to the Jira REST API, along with Jira's own messages
+func jiraError(resp *http.Response, data []byte) error {
+	var reason string
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		reason = "Jira rejected the credentials; check jira_email and jira_token"
+	case http.StatusForbidden:
+		reason = "the Jira account isn't allowed to do that"
+	case http.StatusNotFound:
+		reason = "the issue or project wasn't found, or the account can't see it"
+	case http.StatusBadRequest:
+		reason = "Jira rejected the request"
+	default:
+		reason = "Jira returned " + resp.Status
+	}
 
-		// Switch to selection mode
-		m.currentMode = selectionMode
-		return m, nil
+	// Jira lists general problems in errorMessages and problems with fields in errors
+	var failure struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+	}
+	json.Unmarshal(data, &failure)
+	messages := failure.ErrorMessages
+	fields := make([]string, 0, len(failure.Errors))
+	for field := range failure.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		messages = append(messages, field+": "+failure.Errors[field])
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s (%s)", reason, strings.Join(messages, "; "))
+	}
+	return errors.New(reason)
+}
 
-	case tea.KeyUp, tea.KeyDown:
-		// Cycle between input fields and save checkbox
-		// For all providers, cycle through input fields and save checkbox (3 fields total)
-		m.focusedInput = (m.focusedInput + 1) % 3
+// --- [ I/O ] ------------------------------------
+//
+// This section defines helper functions to take the user input in the viewport and pass it to the LLM.
+//
 
-		// Update focus on input fields
-		m.apiKeyInput.Blur()
-		m.apiBaseInput.Blur()
-		m.modelNameInput.Blur()
+// buildSelectedMarkdown returns a string of Markdown reflecting the selected items.
+func buildSelectedMarkdown(m model) string {
+	var sb strings.Builder
 
-		if isLocalModel {
-			if m.focusedInput == 0 {
-				m.apiBaseInput.Focus()
-			} else if m.focusedInput == 1 {
-				m.modelNameInput.Focus()
-			}
-		} else {
-			if m.focusedInput == 0 {
-				m.apiKeyInput.Focus()
-			} else if m.focusedInput == 1 {
-				m.modelNameInput.Focus()
-			}
+	// Add form name
+	sb.WriteString(fmt.Sprintf("# %s\n\n", m.currentForm.name))
+
+	// Add questions
+	for i, question := range m.currentForm.questions {
+		sb.WriteString(fmt.Sprintf("## %d. %s\n\n", i+1, question))
+		if i < len(m.answers) {
+			sb.WriteString(fmt.Sprintf("%s\n\n", m.answers[i]))
 		}
-		return m, nil
+	}
 
-	case tea.KeySpace:
-		// Toggle save config option when focused on it
-		if m.focusedInput == 2 {
-			m.saveConfig = !m.saveConfig
+	return sb.String()
+}
+
+// defaultSummaryFileName builds a filename like "incident_response_2006-01-02_15-04-05.md".
+func defaultSummaryFileName(formName string, t time.Time) string {
+	name := strings.ToLower(strings.TrimSpace(formName))
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
 		}
-		return m, nil
+		return '_'
+	}, name)
+	if name == "" {
+		name = "summary"
 	}
+	return fmt.Sprintf("%s_%s.md", name, t.Format("2006-01-02_15-04-05"))
+}
 
-	// Handle input for the appropriate field based on model type and focus
-	if isLocalModel {
-		if m.focusedInput == 0 {
-			m.apiBaseInput, cmd = m.apiBaseInput.Update(msg)
-		} else if m.focusedInput == 1 {
-			m.modelNameInput, cmd = m.modelNameInput.Update(msg)
+// uniqueFilePath appends a counter to the filename if the path is already taken,
+// e.g. "summary.md" becomes "summary_1.md".
+func uniqueFilePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
 		}
+	}
+}
+
+// summaryFrontMatter returns the YAML front matter saved summaries start with. Its keys are
+// kept stable for static site generators and other tools: form_type, model, generated_at,
+// prompt_tokens, completion_tokens and tokens_estimated, plus answers (a list of question and
+// answer pairs) when front_matter_answers is set. The token counts are the provider's; for
+// providers that don't report them (and summaries reopened from the history) they're estimates,
+// see estimateTokens, and tokens_estimated is true.
+func (m model) summaryFrontMatter() string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "form_type: %s\n", yamlQuote(m.currentForm.name))
+	fmt.Fprintf(&b, "model: %s\n", yamlQuote(m.generatedBy))
+	if !m.generatedAt.IsZero() {
+		fmt.Fprintf(&b, "generated_at: %s\n", m.generatedAt.Format(time.RFC3339))
+	}
+
+	if m.usage != nil {
+		fmt.Fprintf(&b, "prompt_tokens: %d\n", m.usage.PromptTokens)
+		fmt.Fprintf(&b, "completion_tokens: %d\n", m.usage.CompletionTokens)
+		b.WriteString("tokens_estimated: false\n")
 	} else {
-		if m.focusedInput == 0 {
-			m.apiKeyInput, cmd = m.apiKeyInput.Update(msg)
-		} else if m.focusedInput == 1 {
-			m.modelNameInput, cmd = m.modelNameInput.Update(msg)
+		promptTokens := 0
+		if len(m.conversation) > 1 {
+			// Everything before the last reply was sent to produce it
+			promptTokens = estimateTokens(llm.FlattenConversation(m.conversation[:len(m.conversation)-1]))
 		}
+		fmt.Fprintf(&b, "prompt_tokens: %d\n", promptTokens)
+		fmt.Fprintf(&b, "completion_tokens: %d\n", estimateTokens(m.gptRawOutput))
+		b.WriteString("tokens_estimated: true\n")
 	}
 
-	return m, cmd
+	if m.config.FrontMatterAnswers && len(m.answers) == len(m.currentForm.questions) && len(m.answers) > 0 {
+		b.WriteString("answers:\n")
+		for i, question := range m.currentForm.questions {
+			fmt.Fprintf(&b, "  - question: %s\n", yamlQuote(question))
+			fmt.Fprintf(&b, "    answer: %s\n", yamlQuote(m.answers[i]))
+		}
+	}
+	b.WriteString("---\n\n")
+	return b.String()
 }
 
-func (m model) updateSelectionMode(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyCtrlQ:
-			return m, tea.Quit
-		case tea.KeyUp, tea.KeyDown, tea.KeyRunes:
-			if msg.Type == tea.KeyUp || (msg.Type == tea.KeyRunes && msg.String() == "k") {
-				if m.cursor > 0 {
-					m.cursor--
-				}
-			} else if msg.Type == tea.KeyDown || (msg.Type == tea.KeyRunes && msg.String() == "j") {
-				if m.cursor < len(m.formTypes)-1 {
-					m.cursor++
-				}
-			}
-		case tea.KeySpace, tea.KeyEnter:
-			if m.currentMode == selectionMode {
-				// Toggle selection: since it's single-selection,
-				// selecting a new item deselects the previous one.
-				if m.selectedIndex == m.cursor {
-					// Deselect if already selected
-					m.selectedIndex = -1
-				} else {
-					m.selectedIndex = m.cursor
-					m.currentForm = m.formTypes[m.selectedIndex]
-					m.currentMode = questionMode
-					m.answers = make([]string, len(m.currentForm.questions))
-					m.currentQuestion = 0
-				}
-			}
+// yamlQuote returns s as a YAML double-quoted scalar. Go's escapes are all valid in YAML's
+// double-quoted style, so strconv.Quote does the work.
+func yamlQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+// saveSummaryToFile writes the output to a markdown file without overwriting existing files.
+// It returns the path that was actually written.
+func saveSummaryToFile(fileName, output string) (string, error) {
+	if filepath.Ext(fileName) == "" {
+		fileName += ".md"
+	}
+
+	path := uniqueFilePath(fileName)
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create directory: %v", err)
 		}
 	}
-	return m, nil
+
+	if err := ioutil.WriteFile(path, []byte(output), 0644); err != nil {
+		return "", fmt.Errorf("failed to write file: %v", err)
+	}
+
+	return path, nil
 }
 
-func (m model) updateQuestionMode(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyEsc, tea.KeyCtrlC:
-			return m, tea.Quit
-		case tea.KeyEnter:
-			// Save the current input as an answer
-			m.answers[m.currentQuestion] = strings.TrimSpace(m.inputString)
-			m.inputString = ""
+// isRemoteSession reports whether we are running over SSH, where the system clipboard
+// belongs to the remote machine rather than the user's.
+func isRemoteSession() bool {
+	return os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != ""
+}
 
-			// Move on to the next question or finish
-			if m.currentQuestion < len(m.currentForm.questions)-1 {
-				m.currentQuestion++
-			} else {
-				m = handleFormCompletion(m)
-			}
-		case tea.KeyCtrlS: // ← Skip question on Ctrl+S
-			// Don't store anything (or store empty string).
-			m.answers[m.currentQuestion] = ""
-			m.inputString = ""
+// osc52Sequence returns the escape sequence that asks the terminal to put text on its clipboard.
+// Inside tmux the sequence is wrapped so tmux passes it through to the outer terminal.
+func osc52Sequence(text string) string {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		seq = "\x1bPtmux;\x1b" + seq + "\x1b\\"
+	}
+	return seq
+}
 
-			if m.currentQuestion < len(m.currentForm.questions)-1 {
-				m.currentQuestion++
-			} else {
-				m = handleFormCompletion(m)
-			}
-		case tea.KeyBackspace, tea.KeyDelete:
-			if len(m.inputString) > 0 {
-				m.inputString = m.inputString[:len(m.inputString)-1] // Delete the last character
-			}
+// Clipboard is somewhere copied text can be put
+type Clipboard interface {
+	Write(text string) error
+}
 
-		default:
-			// Runes capture standard alphanumeric input, but not the space key.
-			if msg.Type == tea.KeyRunes {
-				m.inputString += msg.String()
-			} else if msg.Type == tea.KeySpace {
-				// Add explicit space handling
-				m.inputString += " "
-			}
+// systemClipboard is the operating system's clipboard
+type systemClipboard struct{}
+
+func (systemClipboard) Write(text string) error {
+	return clipboard.WriteAll(text)
+}
+
+// osc52Clipboard is the terminal's clipboard, reached by writing an OSC 52 sequence to out.
+// A nil error only means the sequence was written; the terminal can't confirm the copy.
+type osc52Clipboard struct {
+	out io.Writer
+}
+
+func (c osc52Clipboard) Write(text string) error {
+	if _, err := io.WriteString(c.out, osc52Sequence(text)); err != nil {
+		return fmt.Errorf("failed to write to terminal: %v", err)
+	}
+	return nil
+}
+
+// copyToClipboard copies text to the system clipboard, falling back to the terminal's when the
+// system clipboard isn't available. Over SSH, the terminal's is used so the text reaches the local
+// machine. viaTerminal is true when the text was handed to the terminal.
+func (m model) copyToClipboard(text string) (viaTerminal bool, err error) {
+	if !isRemoteSession() {
+		err := m.clipboard.Write(text)
+		if err == nil {
+			return false, nil
 		}
+		logf("System clipboard unavailable, falling back to OSC 52: %v", err)
 	}
-	return m, nil
+
+	if err := m.terminalClipboard.Write(text); err != nil {
+		return true, err
+	}
+	return true, nil
 }
 
-// countLines returns the number of lines in the given string.
-func countLines(s string) int {
-	return len(strings.Split(s, "\n"))
+// sanitizePastedText normalizes clipboard text for the answer input: Windows and old Mac
+// line endings become newlines, and escape sequences and other control characters are
+// dropped, except tabs
+func sanitizePastedText(text string) string {
+	text = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(stripansi.Strip(text))
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// editorFinishedMsg is sent when the external editor opened from display mode exits
+type editorFinishedMsg struct {
+	path string
+	err  error
+}
+
+// editorCommand returns the command line for the user's editor: $EDITOR if set,
+// otherwise the first of vi or nano found on the PATH.
+func editorCommand() ([]string, error) {
+	if args := strings.Fields(os.Getenv("EDITOR")); len(args) > 0 {
+		return args, nil
+	}
+	for _, name := range []string{"vi", "nano"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return []string{path}, nil
+		}
+	}
+	return nil, errors.New("$EDITOR is not set and neither vi nor nano was found")
 }
 
-func (m model) updateDisplayMode(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "q":
-			return m, tea.Quit
+// editSummary writes the summary to a temp file and hands the terminal to the editor
+func editSummary(m model) (model, tea.Cmd) {
+	if m.generating || m.gptRawOutput == "" {
+		return m, nil
+	}
 
-		// Scroll up one line
-		case "up", "k":
-			if m.viewport.YOffset > 0 {
-				m.viewport.YOffset--
-			}
-			return m, nil
+	args, err := editorCommand()
+	if err != nil {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Can't open editor: %v", err))
+		return m, nil
+	}
 
-		// Scroll down one line
-		case "down", "j":
-			// Calculate total number of lines from the viewport's current content.
-			totalLines := countLines(m.content)
-			maxYOffset := totalLines - m.viewport.Height
-			if m.viewport.YOffset < maxYOffset {
-				m.viewport.YOffset++
-			}
-			return m, nil
+	f, err := ioutil.TempFile("", "ticketduck-*.md")
+	if err != nil {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Can't open editor: %v", err))
+		return m, nil
+	}
+	path := f.Name()
+	_, err = f.WriteString(m.gptRawOutput)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Can't open editor: %v", err))
+		return m, nil
+	}
 
-		// Page up: scroll up by the height of the viewport.
-		case "pgup":
-			m.viewport.YOffset -= m.viewport.Height
-			if m.viewport.YOffset < 0 {
-				m.viewport.YOffset = 0
-			}
-			return m, nil
+	logf("Opening summary in %s", args[0])
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	return m, tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorFinishedMsg{path: path, err: err}
+	})
+}
 
-		// Page down: scroll down by the height of the viewport.
-		case "pgdown":
-			totalLines := countLines(m.content)
-			maxYOffset := totalLines - m.viewport.Height
-			m.viewport.YOffset += m.viewport.Height
-			if m.viewport.YOffset > maxYOffset {
-				m.viewport.YOffset = maxYOffset
-			}
-			return m, nil
+// handleEditorFinished loads the edited summary back into the viewport
+func (m model) handleEditorFinished(msg editorFinishedMsg) (tea.Model, tea.Cmd) {
+	defer os.Remove(msg.path)
 
-		// Jump to bottom
-		case "G":
-			totalLines := countLines(m.content)
-			m.viewport.YOffset = totalLines - m.viewport.Height
-			if m.viewport.YOffset < 0 {
-				m.viewport.YOffset = 0
-			}
-			m.gPressed = false
-			return m, nil
+	if msg.err != nil {
+		logf("Editor failed: %v", msg.err)
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Editor failed, summary unchanged: %v", msg.err))
+		return m, nil
+	}
 
-		// Jump to top (with "g" pressed twice)
-		case "g":
-			if m.gPressed {
-				m.viewport.YOffset = 0
-				m.gPressed = false
-			} else {
-				m.gPressed = true
-			}
-			return m, nil
+	data, err := ioutil.ReadFile(msg.path)
+	if err != nil {
+		logf("Failed to read edited summary: %v", err)
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Couldn't read the edited summary: %v", err))
+		return m, nil
+	}
 
-		// Copy plain text to clipboard
-		case "ctrl+y":
-			plainText := stripansi.Strip(m.gptRawOutput)
-			if err := clipboard.WriteAll(plainText); err != nil {
-				log.Printf("Failed to copy to clipboard: %v\n", err)
-			}
-			return m, nil
+	edited := string(data)
+	if edited == m.gptRawOutput {
+		m.displayStatus = m.styles.StatusHeader.Render("Summary unchanged")
+		return m, nil
+	}
 
-		default:
-			// For any other keys, ignore or implement additional behavior.
-			return m, nil
+	m.gptRawOutput = edited
+	if !strings.HasSuffix(edited, analystNoteSection(m.analystNote)) {
+		m.analystNote = "" // Edited along with the rest of the summary
+	}
+	m.content = appendSummary(m.requestMarkdown, m.gptRawOutput)
+	m.outputSaved = false
+	// Follow-ups should build on the edited text, without the note
+	if n := len(m.conversation); n > 0 && m.conversation[n-1].Role == "assistant" {
+		m.conversation[n-1].Content = edited
+		if m.analystNote != "" {
+			m.conversation[n-1].Content = strings.TrimSuffix(edited, analystNoteSection(m.analystNote))
 		}
 	}
+	if err := m.renderContent(); err != nil {
+		logf("Error rendering edited summary: %v", err)
+	}
+	m.displayStatus = m.styles.SuccessStatus("Summary updated from editor")
 	return m, nil
 }
 
-// updateModelSelectMode handles user input in the model selection mode
-func (m model) updateModelSelectMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.Type {
-	case tea.KeyCtrlQ:
-		return m, tea.Quit
-	case tea.KeyUp, tea.KeyDown:
-		if msg.Type == tea.KeyUp {
-			if m.modelCursor > 0 {
-				m.modelCursor--
-			}
-		} else {
-			if m.modelCursor < len(m.modelKeys)-1 {
-				m.modelCursor++
-			}
-		}
-	case tea.KeyRunes:
-		switch msg.String() {
-		case "k":
-			if m.modelCursor > 0 {
-				m.modelCursor--
-			}
-		case "j":
-			if m.modelCursor < len(m.modelKeys)-1 {
-				m.modelCursor++
-			}
-		case "c":
-			// Configure the model at the current cursor position
-			m.selectedModel = m.modelKeys[m.modelCursor]
-			m.config.ActiveModel = m.selectedModel
-			m.currentMode = apiKeyInputMode
-		}
-	case tea.KeySpace, tea.KeyEnter:
-		// Select the model at the current cursor position
-		m.selectedModel = m.modelKeys[m.modelCursor]
-		m.config.ActiveModel = m.selectedModel
-
-		// Save the config
-		if err := saveConfig(m.config); err != nil {
-			log.Printf("Failed to save config: %v\n", err)
-		}
-
-		// Check if the selected model needs configuration
-		selectedModelConfig := m.config.Models[m.selectedModel]
-		if (selectedModelConfig.Provider != ProviderLocal && selectedModelConfig.APIKey == "") ||
-			(selectedModelConfig.Provider == ProviderLocal && selectedModelConfig.APIBaseURL == "") {
-			// Go to API key input mode if needed
-			m.currentMode = apiKeyInputMode
-		} else {
-			// Otherwise go to form selection mode
-			m.currentMode = selectionMode
+// renderContent shows m.content in the viewport, either styled or as raw markdown source
+func (m *model) renderContent() error {
+	if m.config.NoWrap {
+		if m.showRawMarkdown {
+			m.viewport.SetContent(m.content)
+			return nil
 		}
+		return renderMarkdownToViewportWidth(m.content, &m.viewport, m.styleThemes[m.styleThemeIndex], 0, m.hyperlinks())
 	}
-	return m, nil
+	m.viewport.SetXOffset(0)
+	width := m.config.wrapWidth(&m.viewport)
+	if m.showRawMarkdown {
+		// Wrap the source to the viewport so long lines stay visible
+		m.viewport.SetContent(lipgloss.NewStyle().Width(width).Render(m.content))
+		return nil
+	}
+	return renderMarkdownToViewportWidth(m.content, &m.viewport, m.styleThemes[m.styleThemeIndex], width, m.hyperlinks())
 }
 
-// updateStyleSelectMode handles user input in the style selection mode
-func (m model) updateStyleSelectMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.Type {
-	case tea.KeyUp, tea.KeyDown:
-		if msg.Type == tea.KeyUp {
-			if m.styleThemeIndex > 0 {
-				m.styleThemeIndex--
-			}
-		} else {
-			if m.styleThemeIndex < len(m.styleThemes)-1 {
-				m.styleThemeIndex++
-			}
-		}
-	case tea.KeyRunes:
-		switch msg.String() {
-		case "k":
-			if m.styleThemeIndex > 0 {
-				m.styleThemeIndex--
-			}
-		case "j":
-			if m.styleThemeIndex < len(m.styleThemes)-1 {
-				m.styleThemeIndex++
-			}
-		}
-	case tea.KeyEnter:
-		// Apply the selected theme
-		m.styles = NewStyles(lipgloss.DefaultRenderer(), m.styleThemes[m.styleThemeIndex])
-		m.currentMode = selectionMode // Return to selection mode
-	case tea.KeyEsc:
-		m.currentMode = selectionMode // Return to selection mode
+// wrapWidth returns the column summaries are wrapped at in vp: wrap_width when it's set and fits
+// in vp, otherwise the width of vp
+func (c Config) wrapWidth(vp *viewport.Model) int {
+	width := viewportContentWidth(vp)
+	if c.WrapWidth > 0 && c.WrapWidth < width {
+		return c.WrapWidth
 	}
-	return m, nil
+	return width
 }
 
-// --- [View] ----------------------------------------------------------------
+// viewportContentWidth returns the number of columns available for text inside the viewport,
+// i.e. its width minus the border and padding of its style.
+func viewportContentWidth(vp *viewport.Model) int {
+	width := vp.Width - vp.Style.GetHorizontalFrameSize()
+	if width < 10 {
+		width = 10
+	}
+	return width
+}
 
-func (m model) View() string {
-	var content string
+// glamourStyleConfig returns the Glamour style for theme: headers, list markers, and bold text
+// take the base color, while links, emphasis, inline code, quotes, and rules take the accent.
+// Monochrome themes use the plain "notty" style.
+func glamourStyleConfig(theme StyleTheme, dark bool) ansi.StyleConfig {
+	if theme.monochrome() {
+		return styles.NoTTYStyleConfig
+	}
 
-	switch m.currentMode {
-	case selectionMode:
-		content = m.viewSelectionMode()
-	case questionMode:
-		content = m.viewQuestionMode()
-	case displayMode:
-		content = m.viewDisplayMode()
-	case apiKeyInputMode:
-		content = m.viewAPIKeyInputMode()
-	case modelSelectMode:
-		content = m.viewModelSelectMode()
-	case styleSelectMode:
-		content = m.viewStyleSelectMode()
-	default:
-		content = "Unknown mode."
+	cfg := styles.LightStyleConfig
+	base, accent := theme.Base.Light, theme.Accent.Light
+	if dark {
+		cfg = styles.DarkStyleConfig
+		base, accent = theme.Base.Dark, theme.Accent.Dark
 	}
 
-	// Create the header with a simple divider
-	header := m.appBoundaryView("TicketDuck")
+	bold := true
+	cfg.Heading.Color = &base
+	cfg.Heading.Bold = &bold
+	for _, h := range []*ansi.StyleBlock{&cfg.H1, &cfg.H2, &cfg.H3, &cfg.H4, &cfg.H5, &cfg.H6} {
+		h.Color = &base
+		h.BackgroundColor = nil
+		h.Bold = &bold
+	}
+	cfg.Strong.Color = &base
+	cfg.Item.Color = &base
+	cfg.Enumeration.Color = &base
+
+	cfg.Emph.Color = &accent
+	cfg.Link.Color = &accent
+	cfg.LinkText.Color = &accent
+	cfg.Code.Color = &accent
+	cfg.BlockQuote.Color = &accent
+	cfg.HorizontalRule.Color = &accent
+	// Fenced code keeps the base style's Chroma palette, which is tuned for the background
+	return cfg
+}
 
-	// Create the status bar
-	statusBar := m.renderStatusBar()
+// rendererKey identifies a cached Glamour renderer
+type rendererKey struct {
+	theme      StyleTheme
+	width      int
+	dark       bool
+	hyperlinks bool
+}
 
-	// Combine all components using vertical layout
-	theme := m.styleThemes[m.styleThemeIndex]
+// glamourRenderers caches one renderer per theme, wrap width, and background,
+// since building a renderer is much slower than rendering with it
+var glamourRenderers = map[rendererKey]*glamour.TermRenderer{}
 
-	// Only add border to content if not in display mode (since viewport has its own border)
-	contentStyle := lipgloss.NewStyle().Padding(1)
-	if m.currentMode != displayMode {
-		contentStyle = contentStyle.
-			BorderStyle(lipgloss.RoundedBorder()).
-			BorderForeground(theme.Base)
+// glamourRenderer returns a cached renderer for theme that wraps at width
+func glamourRenderer(theme StyleTheme, width int, hyperlinks bool) (*glamour.TermRenderer, error) {
+	key := rendererKey{theme: theme, width: width, dark: lipgloss.HasDarkBackground(), hyperlinks: hyperlinks}
+	if r, ok := glamourRenderers[key]; ok {
+		return r, nil
 	}
-
-	return lipgloss.JoinVertical(
-		lipgloss.Left,
-		header,
-		contentStyle.Render(content),
-		statusBar,
+	cfg := glamourStyleConfig(theme, key.dark)
+	if hyperlinks {
+		// Mark the text of links so linkifyURLs can find it
+		cfg.LinkText.Prefix = linkTextStart
+		cfg.LinkText.Suffix = linkTextEnd
+	}
+	r, err := glamour.NewTermRenderer(
+		glamour.WithStyles(cfg),
+		glamour.WithWordWrap(width),
 	)
+	if err != nil {
+		return nil, err
+	}
+	glamourRenderers[key] = r
+	return r, nil
 }
 
-// View rendering for API Key Input Mode
-func (m model) viewAPIKeyInputMode() string {
-	modelConfig := m.config.Models[m.selectedModel]
-	isLocalModel := modelConfig.Provider == ProviderLocal
+// urlPattern matches web URLs in markdown source and in rendered text
+var urlPattern = regexp.MustCompile(`https?://[^\s\x1b<>()\[\]]+`)
 
-	var title string
+// trimURL drops trailing punctuation that ends a sentence rather than the URL
+func trimURL(u string) string {
+	return strings.TrimRight(u, ".,;:!?'\"")
+}
 
-	if isLocalModel {
-		title = fmt.Sprintf("Configure Ollama: %s", m.selectedModel)
+// hyperlinks reports whether summaries should carry OSC 8 hyperlinks, see Config.hyperlinks
+func (m model) hyperlinks() bool {
+	return m.config.hyperlinks(m.styleThemes[m.styleThemeIndex])
+}
 
-		// Initialize input field values if they're empty
-		if m.apiBaseInput.Placeholder == "" {
-			m.apiBaseInput.Placeholder = "http://localhost:11434"
-		}
+// hyperlinks reports whether rendered links should carry OSC 8 escape sequences: always when
+// the hyperlinks setting is "on", never when it's "off", and otherwise when the terminal is
+// known to support them and the theme isn't monochrome
+func (c Config) hyperlinks(theme StyleTheme) bool {
+	switch c.Hyperlinks {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return !theme.monochrome() && terminalSupportsHyperlinks()
+}
 
-		if m.modelNameInput.Placeholder == "" {
-			m.modelNameInput.Placeholder = "Model name as shown in 'ollama list' (e.g., llama3)"
-		}
+// hyperlinkSettings are the values the hyperlinks setting takes; "" is the same as "auto"
+var hyperlinkSettings = []string{"", "auto", "on", "off"}
 
-		// Set existing values if available
-		if modelConfig.APIBaseURL != "" && m.apiBaseInput.Value() == "" {
-			m.apiBaseInput.SetValue(modelConfig.APIBaseURL)
-		}
+// hyperlinkTerminals are the TERM_PROGRAM values of terminals that support OSC 8 hyperlinks
+var hyperlinkTerminals = map[string]bool{
+	"iTerm.app": true, "WezTerm": true, "vscode": true, "ghostty": true, "Hyper": true, "Tabby": true,
+}
 
-		if modelConfig.ModelName != "" && m.modelNameInput.Value() == "" {
-			m.modelNameInput.SetValue(modelConfig.ModelName)
+// terminalSupportsHyperlinks reports whether the terminal is one known to support OSC 8
+// hyperlinks. Many others ignore the sequences, but some print them, so the rest get plain text.
+func terminalSupportsHyperlinks() bool {
+	if hyperlinkTerminals[os.Getenv("TERM_PROGRAM")] {
+		return true
+	}
+	// Windows Terminal and kitty
+	if os.Getenv("WT_SESSION") != "" || os.Getenv("KITTY_WINDOW_ID") != "" {
+		return true
+	}
+	// GNOME Terminal and the other VTE terminals since VTE 0.50, and Konsole since 20.12
+	if version, err := strconv.Atoi(os.Getenv("VTE_VERSION")); err == nil && version >= 5000 {
+		return true
+	}
+	if version, err := strconv.Atoi(os.Getenv("KONSOLE_VERSION")); err == nil && version >= 201200 {
+		return true
+	}
+	term := os.Getenv("TERM")
+	for _, prefix := range []string{"xterm-kitty", "xterm-ghostty", "wezterm", "foot", "alacritty"} {
+		if strings.HasPrefix(term, prefix) {
+			return true
 		}
-	} else {
-		providerName := string(modelConfig.Provider)
-		providerName = strings.ToUpper(providerName[:1]) + providerName[1:]
+	}
+	return false
+}
 
-		title = fmt.Sprintf("Configure %s API", providerName)
+// osc8Start begins an OSC 8 hyperlink to url, which osc8End ends
+func osc8Start(url string) string {
+	return "\x1b]8;;" + url + "\x1b\\"
+}
 
-		// Set model name input placeholder and value
-		m.modelNameInput.Placeholder = fmt.Sprintf("Model name for %s (e.g., %s)", providerName, modelConfig.ModelName)
-		if modelConfig.ModelName != "" && m.modelNameInput.Value() == "" {
-			m.modelNameInput.SetValue(modelConfig.ModelName)
-		}
+// osc8End ends the hyperlink osc8Start began
+const osc8End = "\x1b]8;;\x1b\\"
 
-		// Set API key placeholder based on provider
-		switch modelConfig.Provider {
-		case ProviderOpenAI:
-			m.apiKeyInput.Placeholder = "Enter your OpenAI API key..."
-		case ProviderAnthropic:
-			m.apiKeyInput.Placeholder = "Enter your Claude API key..."
+// osc8Link returns text wrapped in an OSC 8 hyperlink to url
+func osc8Link(url, text string) string {
+	return osc8Start(url) + text + osc8End
+}
+
+// osc8Pattern matches the sequences that begin and end OSC 8 hyperlinks, capturing the URL,
+// which is empty for the end
+var osc8Pattern = regexp.MustCompile(`\x1b\]8;[^;\x1b]*;([^\x1b]*)\x1b\\`)
+
+// Glamour puts linkTextStart and linkTextEnd around each piece of a link's text when
+// hyperlinks are on. Both are zero width, so they don't change how the text is wrapped.
+const (
+	linkTextStart = "\ufeff"
+	linkTextEnd   = "\u180e"
+)
+
+// linkTokenPattern matches the link text markers and the web URLs in rendered text
+var linkTokenPattern = regexp.MustCompile(linkTextStart + "|" + linkTextEnd + `|https?://[^\s\x1b<>()\[\]` + linkTextStart + linkTextEnd + `]+`)
+
+// linkifyURLs makes the links that Glamour printed clickable, keeping their visible text: the
+// text of [text](url) links and the URLs themselves, also when word wrapping broke them across
+// lines. Only URLs that appear in the markdown source are linked. Run it before the lines are
+// hard-wrapped, see continueLinks.
+func linkifyURLs(rendered, md string) string {
+	var known []string
+	for _, u := range urlPattern.FindAllString(md, -1) {
+		if u = trimURL(u); !slices.Contains(known, u) {
+			known = append(known, u)
+		}
+	}
+	// Try the longest first, in case a URL starts with another
+	sort.Slice(known, func(i, j int) bool { return len(known[i]) > len(known[j]) })
+
+	tokens := linkTokenPattern.FindAllStringIndex(rendered, -1)
+	var b strings.Builder
+	last := 0
+	inLink := false // Inside the text of a link that was made clickable
+	for i, token := range tokens {
+		if token[0] < last {
+			continue // Part of a URL that was already linked
+		}
+		b.WriteString(rendered[last:token[0]])
+		last = token[1]
+		switch match := rendered[token[0]:token[1]]; match {
+		case linkTextStart:
+			if j := linkTarget(rendered, tokens[i:]); j > 0 {
+				if u, _ := wrappedURL(rendered, tokens[i+j], known); u != "" {
+					b.WriteString(osc8Start(u))
+					inLink = true
+				}
+			}
+		case linkTextEnd:
+			if inLink {
+				b.WriteString(osc8End)
+				inLink = false
+			}
 		default:
-			m.apiKeyInput.Placeholder = "Enter your API key..."
+			u, pieces := wrappedURL(rendered, token, known)
+			if u == "" || inLink {
+				b.WriteString(match)
+				break
+			}
+			last = token[0]
+			for _, piece := range pieces {
+				b.WriteString(rendered[last:piece[0]])
+				b.WriteString(osc8Link(u, rendered[piece[0]:piece[1]]))
+				last = piece[1]
+			}
 		}
+	}
+	b.WriteString(rendered[last:])
+	return b.String()
+}
 
-		// Set existing API key if available
-		if modelConfig.APIKey != "" && m.apiKeyInput.Value() == "" {
-			m.apiKeyInput.SetValue(modelConfig.APIKey)
+// linkTarget returns the index of the URL Glamour printed after a link's text among tokens,
+// which start at a piece of that text. Only the link's other pieces of text and white space
+// may come before it; 0 means the link has no web URL.
+func linkTarget(rendered string, tokens [][]int) int {
+	inText := false
+	for i, token := range tokens {
+		if i > 0 && !inText && strings.TrimSpace(xansi.Strip(rendered[tokens[i-1][1]:token[0]])) != "" {
+			return 0
+		}
+		switch rendered[token[0]:token[1]] {
+		case linkTextStart:
+			inText = true
+		case linkTextEnd:
+			inText = false
+		default:
+			if !inText {
+				return i
+			}
 		}
 	}
+	return 0
+}
 
-	s := m.appBoundaryView(title) + "\n\n"
-
-	if isLocalModel {
-		// For local models, show both base URL and model name inputs
-		baseURLFocused := m.focusedInput == 0
-		modelNameFocused := m.focusedInput == 1
-
-		// API Base URL field
-		if baseURLFocused {
-			s += m.styles.Highlight.Render("API Base URL:") + "\n"
-		} else {
-			s += "API Base URL:" + "\n"
+// lineBreakPattern matches the styling, line break and indentation between the pieces of a
+// URL that was wrapped
+var lineBreakPattern = regexp.MustCompile(`^(?:\x1b\[[0-9;]*m| )*\n(?:\x1b\[[0-9;]*m|\s)*`)
+
+// wrappedURL returns which of the known URLs the URL found at token in rendered is, with the
+// byte ranges of its pieces: one, or more when word wrapping continued it on the next lines.
+// It returns "" for URLs that aren't known.
+func wrappedURL(rendered string, token []int, known []string) (string, [][2]int) {
+	start, match := token[0], rendered[token[0]:token[1]]
+	if u := trimURL(match); slices.Contains(known, u) {
+		return u, [][2]int{{start, start + len(u)}}
+	}
+	for _, u := range known {
+		if !strings.HasPrefix(u, match) {
+			continue
 		}
-		s += m.apiBaseInput.View() + "\n"
-
-		// Add URL hint for Ollama users
-		s += m.styles.Help.Render("For Ollama: Use http://localhost:11434 (without path segments)") + "\n\n"
+		pieces := [][2]int{{start, token[1]}}
+		rest, pos := u[len(match):], token[1]
+		for rest != "" {
+			gap := lineBreakPattern.FindStringIndex(rendered[pos:])
+			if gap == nil {
+				break
+			}
+			pos += gap[1]
+			n := 0
+			for n < len(rest) && pos+n < len(rendered) && rendered[pos+n] == rest[n] {
+				n++
+			}
+			if n == 0 {
+				break
+			}
+			pieces = append(pieces, [2]int{pos, pos + n})
+			rest, pos = rest[n:], pos+n
+		}
+		if rest == "" {
+			return u, pieces
+		}
+	}
+	return "", nil
+}
 
-		// Model Name field
-		if modelNameFocused {
-			s += m.styles.Highlight.Render("Model Name:") + "\n"
-		} else {
-			s += "Model Name:" + "\n"
+// continueLinks ends the hyperlinks still open at the end of a line and starts them again on
+// the next, so a link wrapped onto several lines is clickable on each, and the viewport, which
+// draws lines one at a time, never leaves one open past its line
+func continueLinks(rendered string) string {
+	lines := strings.Split(rendered, "\n")
+	open := ""
+	for i, line := range lines {
+		if open != "" {
+			line = osc8Start(open) + line
+		}
+		for _, match := range osc8Pattern.FindAllStringSubmatch(line, -1) {
+			open = match[1]
+		}
+		if open != "" {
+			line += osc8End
 		}
-		s += m.modelNameInput.View() + "\n"
+		lines[i] = trimLinks(line)
+	}
+	return strings.Join(lines, "\n")
+}
 
-		// Add model name hint for Ollama users
-		s += m.styles.Help.Render("For Ollama: Use exactly the model name shown in 'ollama list'") + "\n\n"
-	} else {
-		// For cloud models, show both API key and model name inputs
-		apiKeyFocused := m.focusedInput == 0
-		modelNameFocused := m.focusedInput == 1
+var (
+	linkPaddingStart = regexp.MustCompile(`(\x1b\]8;[^;\x1b]*;[^\x1b]+\x1b\\)((?:\x1b\[[0-9;]*m| )+)`)
+	linkPaddingEnd   = regexp.MustCompile(`((?:\x1b\[[0-9;]*m| )+)(\x1b\]8;;\x1b\\)`)
+	emptyLink        = regexp.MustCompile(`\x1b\]8;[^;\x1b]*;[^\x1b]+\x1b\\\x1b\]8;;\x1b\\`)
+)
 
-		// API Key field
-		if apiKeyFocused {
-			s += m.styles.Highlight.Render("API Key:") + "\n"
-		} else {
-			s += "API Key:" + "\n"
-		}
-		s += m.apiKeyInput.View() + "\n\n"
+// trimLinks moves the ends of the hyperlinks on line inside the spaces around them, so the
+// padding and indentation Glamour puts around wrapped links aren't clickable
+func trimLinks(line string) string {
+	line = linkPaddingStart.ReplaceAllString(line, "$2$1")
+	line = linkPaddingEnd.ReplaceAllString(line, "$2$1")
+	return emptyLink.ReplaceAllString(line, "")
+}
 
-		// Model Name field
-		if modelNameFocused {
-			s += m.styles.Highlight.Render("Model Name:") + "\n"
-		} else {
-			s += "Model Name:" + "\n"
-		}
-		s += m.modelNameInput.View() + "\n"
+// renderMarkdownToViewport uses Glamour to transform the raw markdown into styled text.
+func renderMarkdownToViewport(md string, vp *viewport.Model, theme StyleTheme, hyperlinks bool) error {
+	return renderMarkdownToViewportWidth(md, vp, theme, viewportContentWidth(vp), hyperlinks)
+}
 
-		if modelConfig.Provider == ProviderAnthropic {
-			s += m.styles.Help.Render("For Claude: Examples include claude-3-opus-20240229, claude-3-sonnet-20240229, claude-3-haiku-20240307") + "\n\n"
-		} else if modelConfig.Provider == ProviderOpenAI {
-			s += m.styles.Help.Render("For OpenAI: Examples include gpt-3.5-turbo, gpt-4, gpt-4-turbo") + "\n\n"
-		}
+// renderMarkdownToViewportWidth renders md into vp, wrapping at width columns. A width of 0
+// leaves long lines as they are, for scrolling sideways. Links are made clickable with OSC 8
+// hyperlinks when hyperlinks is set.
+func renderMarkdownToViewportWidth(md string, vp *viewport.Model, theme StyleTheme, width int, hyperlinks bool) error {
+	r, err := glamourRenderer(theme, width, hyperlinks)
+	if err != nil {
+		return err
 	}
 
-	// Save configuration checkbox
-	saveText := "[ ] Save configuration to config file"
-	if m.saveConfig {
-		saveText = "[x] Save configuration to config file"
+	if hyperlinks {
+		// The markers for link text must only come from Glamour
+		md = strings.NewReplacer(linkTextStart, "", linkTextEnd, "").Replace(md)
+	}
+	rendered, err := r.Render(md)
+	if err != nil {
+		return err
+	}
+	if hyperlinks {
+		rendered = linkifyURLs(rendered, md)
 	}
-
-	saveFocused := m.focusedInput == 2
-	if saveFocused {
-		s += m.styles.Highlight.Render(saveText) + "\n\n"
-	} else {
-		s += saveText + "\n\n"
+	if width > 0 {
+		rendered = hardwrapLines(rendered, width)
+	}
+	if hyperlinks {
+		rendered = continueLinks(rendered)
 	}
 
-	// Help text
-	s += m.styles.Help.Render("↑/↓: Cycle through fields • Space: Toggle checkbox • Enter: Confirm") + "\n"
-	s += m.styles.Help.Render("Esc to return to menu • Ctrl+q to quit")
+	// Ensure the rendered content ends with a newline for proper display
+	vp.SetContent(strings.TrimRight(rendered, "\n") + "\n")
+	return nil
+}
 
-	return s
+// hardwrapLines breaks the lines of rendered that are still wider than width. Glamour only
+// wraps at spaces, so a long URL or a run of CJK text would otherwise overflow the viewport.
+func hardwrapLines(rendered string, width int) string {
+	lines := strings.Split(rendered, "\n")
+	for i, line := range lines {
+		if xansi.StringWidth(line) > width {
+			lines[i] = xansi.Hardwrap(line, width, true)
+		}
+	}
+	return strings.Join(lines, "\n")
 }
 
-// View rendering for Selection Mode
-func (m model) viewSelectionMode() string {
-	s := m.appBoundaryView("Select Report Type") + "\n\n"
+// handleFormCompletion combines the other helper functions to pass the input on to the LLM.
+func handleFormCompletion(m model) (model, tea.Cmd) {
+	m, ok := m.requireAnswers()
+	if !ok {
+		return m, nil
+	}
 
-	for i, rt := range m.formTypes {
-		cursor := "  "
-		if m.cursor == i {
-			cursor = m.styles.Highlight.Render(">")
-		}
+	// Build the Markdown
+	md := buildSelectedMarkdown(m)
+	theme := m.styleThemes[m.styleThemeIndex]
 
-		line := fmt.Sprintf("%s %s", cursor, rt.name)
+	// Update viewport style with theme colors first, since it determines the wrap width
+	m.viewport.Style = lipgloss.NewStyle().
+		BorderStyle(lipgloss.RoundedBorder()).
+		BorderForeground(theme.Base).
+		PaddingLeft(2).
+		PaddingRight(2)
 
-		if m.cursor == i {
-			line = m.styles.Highlight.Render(line)
-		} else {
-			line = m.styles.Help.Render(line)
-		}
+	if err := renderMarkdownToViewport(md, &m.viewport, theme, m.hyperlinks()); err != nil {
+		logf("Error rendering markdown: %v", err)
+	}
+	m.content = md
+	m.displayStatus = ""
+
+	// Without a model there's nothing to send to; one has to be picked first
+	activeModelConfig, ok := m.config.activeModelConfig()
+	if !ok {
+		logf("No model selected (active model %q), opening model selection", m.config.ActiveModel)
+		m.config.ActiveModel = ""
+		m.currentMode = modelSelectMode
+		return m, nil
+	}
 
-		s += line + "\n"
+	// Check if the active model has the required API key or base URL
+	if (activeModelConfig.Provider != llm.ProviderLocal && activeModelConfig.APIKey == "") ||
+		(activeModelConfig.Provider == llm.ProviderLocal && activeModelConfig.APIBaseURL == "") {
+		// Go to API key input mode if needed
+		m.selectedModel = m.config.ActiveModel
+		return m.enterAPIKeyInputMode()
 	}
 
-	s += "\n" + m.styles.Help.Render("Use ↑/↓ or j/k to navigate • Enter to select") + "\n"
-	s += m.styles.Help.Render(fmt.Sprintf("Current model: %s", m.config.ActiveModel)) + "\n"
-	s += m.styles.Help.Render("~ to change model • Ctrl+t to change theme • Ctrl+q to quit") + "\n"
+	m.regenerating = false
+	return startLLMRequest(m, md)
+}
 
-	return s
+// requireAnswers checks that every required question has an answer before the answers are
+// sent, however the user got to sending them: the review screen, a jump with Ctrl+g, or an
+// import. If one is blank, it returns to the review screen on that question and reports false.
+func (m model) requireAnswers() (model, bool) {
+	i := m.currentForm.missingAnswer(m.answers)
+	if i < 0 {
+		return m, true
+	}
+	logf("Not sending: required question %d of %q has no answer", i+1, m.currentForm.name)
+	m.currentMode = reviewMode
+	m.reviewCursor = i
+	m.contextWarning = ""
+	m.previewingPrompt = false
+	m.choosingCompare = false
+	m.reviewWarning = fmt.Sprintf("Question %d is required; press Enter to answer it", i+1)
+	return m, false
 }
 
-// View rendering for Question Mode
-func (m model) viewQuestionMode() string {
-	currentQ := m.currentForm.questions[m.currentQuestion]
-	inputLine := "> " + m.inputString
+// noActiveModelStatus is shown when a request is asked for while no model is selected
+const noActiveModelStatus = "No model is selected; press ~ to choose one"
 
-	s := m.appBoundaryView(fmt.Sprintf("%s - Question %d/%d", m.currentForm.name, m.currentQuestion+1, len(m.currentForm.questions))) + "\n\n"
-	s += m.styles.Highlight.Render(fmt.Sprintf("**%s**", currentQ)) + "\n\n"
-	s += inputLine
+// regenerateSummary re-runs the request for the current answers and active model,
+// replacing the summary section. On failure the previous output is restored.
+func regenerateSummary(m model, refresh bool) (model, tea.Cmd) {
+	if m.generating {
+		return m, nil
+	}
+	if _, ok := m.config.activeModelConfig(); !ok {
+		m.displayStatus = m.styles.ErrorStatus(noActiveModelStatus)
+		return m, nil
+	}
+	// Summaries reopened from history may belong to a form that has since changed
+	if m.currentForm.prompt == "" {
+		m.displayStatus = m.styles.ErrorStatus(fmt.Sprintf("Can't regenerate: the %q form has changed or is no longer available", m.currentForm.name))
+		return m, nil
+	}
 
-	s += "\n\n" + m.styles.Help.Render("Enter to submit • Ctrl+s to skip") + "\n"
-	s += m.styles.Help.Render("Esc to return to menu • Ctrl+q to quit") + "\n"
+	// Remember the current output so it can be put back if the request fails
+	m.previousContent = m.content
+	m.previousOutput = m.gptRawOutput
+	m.regenerating = true
+	m.refreshCache = refresh
 
-	return s
+	return startLLMRequest(m, buildSelectedMarkdown(m))
 }
 
-// View rendering for Display Mode
-func (m model) viewDisplayMode() string {
-	s := m.viewport.View()
-	s += m.styles.Help.Render("\n↑/↓: Scroll • Ctrl+y to copy • Esc to return to menu • Ctrl+q to quit\n")
-	return s
+// llmStreamEvent is sent from the request goroutine for each chunk, and once more when it finishes
+type llmStreamEvent struct {
+	chunk    string
+	retry    string // Set while waiting to retry after a transient error
+	done     bool
+	response string
+	usage    *llm.Usage // As reported by the provider, nil if it doesn't
+	model    string     // Key of the model the response came from
+	err      error
 }
 
-// viewModelSelectMode renders the model selection interface
-func (m model) viewModelSelectMode() string {
-	s := m.appBoundaryView("Select AI Provider") + "\n\n"
-
-	for i, key := range m.modelKeys {
-		modelConfig := m.config.Models[key]
+// llmChunkMsg delivers a piece of streamed output to Update, tagged with the request it belongs to
+type llmChunkMsg struct {
+	id     int
+	chunk  string
+	stream <-chan llmStreamEvent
+}
 
-		cursor := "  "
-		if m.modelCursor == i {
-			cursor = m.styles.Highlight.Render(">")
-		}
+// llmRetryMsg tells Update that the request is waiting to be retried
+type llmRetryMsg struct {
+	id     int
+	status string
+	stream <-chan llmStreamEvent
+}
 
-		// Get a user-friendly provider name
-		var providerDisplay string
-		switch modelConfig.Provider {
-		case ProviderOpenAI:
-			providerDisplay = "OpenAI"
-		case ProviderAnthropic:
-			providerDisplay = "Anthropic (Claude)"
-		case ProviderLocal:
-			providerDisplay = "Ollama (Local)"
-		default:
-			providerDisplay = string(modelConfig.Provider)
-		}
+// llmResultMsg delivers the outcome of a finished request to Update
+type llmResultMsg struct {
+	id      int
+	content string
+	usage   *llm.Usage // Token counts the provider reported for content, nil if it doesn't
+	model   string     // Key of the model that produced content, or failed last
+	err     error
+}
 
-		// Format model info to show current model name or configuration status
-		var modelInfo string
-		if key == "openai" || key == "anthropic" || key == "ollama" {
-			// For the main providers, show model name if configured
-			if (modelConfig.Provider != ProviderLocal && modelConfig.APIKey != "") ||
-				(modelConfig.Provider == ProviderLocal && modelConfig.APIBaseURL != "") {
-				modelInfo = fmt.Sprintf("%s - %s", providerDisplay, modelConfig.ModelName)
-			} else {
-				modelInfo = fmt.Sprintf("%s (not configured)", providerDisplay)
-			}
-		} else {
-			// For custom configurations, show provider and model name
-			modelInfo = fmt.Sprintf("%s (%s)", key, providerDisplay)
+// waitForLLMStream returns a command that waits for the next event from a running request
+func waitForLLMStream(id int, stream <-chan llmStreamEvent) tea.Cmd {
+	return func() tea.Msg {
+		event := <-stream
+		if event.done {
+			return llmResultMsg{id: id, content: event.response, usage: event.usage, model: event.model, err: event.err}
 		}
-
-		// Show configuration status
-		status := ""
-		if modelConfig.Provider != ProviderLocal && modelConfig.APIKey != "" {
-			status = m.styles.StatusHeader.Render(" ✓")
-		} else if modelConfig.Provider == ProviderLocal && modelConfig.APIBaseURL != "" {
-			status = m.styles.StatusHeader.Render(" ✓")
+		if event.retry != "" {
+			return llmRetryMsg{id: id, status: event.retry, stream: stream}
 		}
+		return llmChunkMsg{id: id, chunk: event.chunk, stream: stream}
+	}
+}
 
-		line := fmt.Sprintf("%s %s%s", cursor, modelInfo, status)
+// startLLMRequest shows the processing message and spinner, then returns a command that runs
+// makeLLMRequest in the background. Its progress comes back to Update as llmChunkMsg values,
+// followed by a single llmResultMsg.
+func startLLMRequest(m model, md string) (model, tea.Cmd) {
+	theme := m.styleThemes[m.styleThemeIndex]
 
-		if m.modelCursor == i {
-			line = m.styles.Highlight.Render(line)
-		} else {
-			line = m.styles.Help.Render(line)
-		}
+	m = m.stopRequest()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.requestCancel = cancel
+	m.requestID++
+	m.requestMarkdown = md
+	m.gptRawOutput = ""
+	m.outputSaved = false
+	m.generating = true
+	m.showSpinner = true
+	m.displayStatus = ""
+	m.requestErr = ""
+	m.requestStart = time.Now()
+	m.currentMode = displayMode
 
-		s += line + "\n"
+	// Show a simple "Processing..." message in the viewport
+	processingMsg := fmt.Sprintf("## Processing with %s\n\nGenerating summary...", m.config.ActiveModel)
+	if err := renderMarkdownToViewport(processingMsg, &m.viewport, theme, m.hyperlinks()); err != nil {
+		logf("Error rendering processing message: %v", err)
 	}
 
-	s += "\n" + m.styles.Help.Render("Use ↑/↓ or j/k to navigate • Enter to select") + "\n"
-	s += m.styles.Help.Render("c to configure provider • Ctrl+t to change theme") + "\n"
-	if m.config.ActiveModel != "" {
-		s += m.styles.Help.Render(fmt.Sprintf("Current model: %s - %s", m.config.ActiveModel, m.config.Models[m.config.ActiveModel].ModelName)) + "\n"
+	// Copy what the request needs so the goroutine never touches the model
+	activeModelConfig := m.config.Models[m.config.ActiveModel]
+	chain := m.config.modelChain(m.config.ActiveModel)
+	requestSettings := m.config.requestSettings()
+	requestSettings.RefreshCache = m.refreshCache
+	m.refreshCache = false
+	formPrompt := m.config.requestPrompt(m.currentForm)
+	configDir := m.config.dir
+	notify := m.config.NotifyOnComplete
+	entry := historyEntry{
+		FormType:  m.currentForm.name,
+		Model:     m.config.ActiveModel,
+		ModelName: activeModelConfig.ModelName,
+		Answers:   append([]string(nil), m.answers...),
+		Markdown:  md,
 	}
-	s += m.styles.Help.Render("Esc to return to menu • Ctrl+q to quit") + "\n"
 
-	return s
-}
+	// The first turn of the conversation follow-ups build on
+	m.pendingTurns = formPromptMessages(formPrompt, md)
 
-// viewStyleSelectMode renders the style selection interface
-func (m model) viewStyleSelectMode() string {
-	s := m.appBoundaryView("Select Style Theme") + "\n\n"
+	// The answers are on their way, so there's nothing left to resume
+	clearDraft(configDir)
 
-	for i, theme := range m.styleThemes {
-		cursor := "  "
-		if m.styleThemeIndex == i {
-			cursor = m.styles.Highlight.Render(">")
+	// Launch API request concurrently
+	stream := runLLMStream(func(onChunk func(chunk string), onRetry func(attempt, maxRetries int, delay time.Duration)) (string, *llm.Usage, string, error) {
+		response, usage, used, err := makeLLMRequestWithFallbacks(ctx, chain, requestSettings, formPrompt, md, onChunk, onRetry)
+		entry.Model, entry.ModelName = used.key, used.config.ModelName
+		if notify && !errors.Is(err, context.Canceled) {
+			notifyRequestDone(entry, err)
 		}
-
-		line := fmt.Sprintf("%s %s", cursor, theme.Name)
-		if m.styleThemeIndex == i {
-			line = m.styles.Highlight.Render(line)
+		if err == nil {
+			entry.Timestamp = time.Now()
+			entry.Output = response
+			if path, err := saveHistoryEntry(configDir, entry); err != nil {
+				logf("Failed to save summary to history: %v", err)
+			} else {
+				logf("Saved summary to history: %s", path)
+			}
 		}
+		return response, usage, used.key, err
+	})
 
-		s += line + "\n"
+	return m, tea.Batch(waitForLLMStream(m.requestID, stream), m.spinnerTick())
+}
+
+// startFollowUp sends the conversation so far plus a follow-up instruction, so the model
+// revises its last summary. The reply replaces the summary; if the request fails, the
+// previous summary is put back.
+func startFollowUp(m model, instruction string) (model, tea.Cmd) {
+	if m.generating || len(m.conversation) == 0 {
+		return m, nil
+	}
+	if _, ok := m.config.activeModelConfig(); !ok {
+		m.displayStatus = m.styles.ErrorStatus(noActiveModelStatus)
+		return m, nil
 	}
+	theme := m.styleThemes[m.styleThemeIndex]
 
-	s += "\n" + m.styles.Help.Render("Use ↑/↓ to navigate • Enter to select") + "\n"
-	s += m.styles.Help.Render("Esc to return to menu • Ctrl+q to quit") + "\n"
+	// Remember the current output so it can be put back if the request fails
+	m.previousContent = m.content
+	m.previousOutput = m.gptRawOutput
+	m.refining = true
+
+	m = m.stopRequest()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.requestCancel = cancel
+	m.requestID++
+	m.gptRawOutput = ""
+	m.outputSaved = false
+	m.generating = true
+	m.showSpinner = true
+	m.displayStatus = ""
+	m.requestStart = time.Now()
+
+	processingMsg := fmt.Sprintf("## Refining with %s\n\n> %s", m.config.ActiveModel, instruction)
+	if err := renderMarkdownToViewport(processingMsg, &m.viewport, theme, m.hyperlinks()); err != nil {
+		logf("Error rendering processing message: %v", err)
+	}
 
-	return s
+	// Copy what the request needs so the goroutine never touches the model
+	activeModelConfig := m.config.Models[m.config.ActiveModel]
+	requestSettings := m.config.requestSettings()
+	turns := append(append([]llm.Message(nil), m.conversation...), llm.Message{Role: "user", Content: instruction})
+	m.pendingTurns = turns
+	logf("Sending follow-up (turn %d): %s", len(turns)/2+1, instruction)
+
+	activeKey := m.config.ActiveModel
+	stream := runLLMStream(func(onChunk func(chunk string), onRetry func(attempt, maxRetries int, delay time.Duration)) (string, *llm.Usage, string, error) {
+		response, usage, err := processConversationWithLLM(ctx, activeModelConfig, requestSettings, turns, onChunk, onRetry)
+		if err != nil {
+			return "", nil, activeKey, fmt.Errorf("LLM API error: %v", err)
+		}
+		return response, usage, activeKey, nil
+	})
+
+	return m, tea.Batch(waitForLLMStream(m.requestID, stream), m.spinnerTick())
 }
 
-// appBoundaryView renders a consistent header for the application
-func (m model) appBoundaryView(text string) string {
-	theme := m.styleThemes[m.styleThemeIndex]
-	return lipgloss.PlaceHorizontal(
-		m.width,
-		lipgloss.Left,
-		m.styles.HeaderText.Render(text),
-		lipgloss.WithWhitespaceChars("/"),
-		lipgloss.WithWhitespaceForeground(theme.Base),
-	)
+// runLLMStream runs send in the background. Its chunks and retries are passed on through the
+// returned channel, which ends with a done event holding the result.
+func runLLMStream(send func(onChunk func(chunk string), onRetry func(attempt, maxRetries int, delay time.Duration)) (response string, usage *llm.Usage, modelKey string, err error)) <-chan llmStreamEvent {
+	stream := make(chan llmStreamEvent)
+	go func() {
+		// A panic here would end the program without restoring the terminal
+		defer func() {
+			if r := recover(); r != nil {
+				stream <- llmStreamEvent{done: true, err: panicError("the request", r)}
+			}
+		}()
+
+		onChunk := func(chunk string) {
+			stream <- llmStreamEvent{chunk: chunk}
+		}
+		onRetry := func(attempt, maxRetries int, delay time.Duration) {
+			if attempt == 0 {
+				// Waiting on the client-side rate limit rather than retrying
+				stream <- llmStreamEvent{retry: fmt.Sprintf("Rate-limited locally, waiting %s...", delay.Round(time.Second))}
+				return
+			}
+			stream <- llmStreamEvent{retry: fmt.Sprintf("Retrying (%d/%d)...", attempt, maxRetries)}
+		}
+		response, usage, modelKey, err := send(onChunk, onRetry)
+		stream <- llmStreamEvent{done: true, response: response, usage: usage, model: modelKey, err: err}
+	}()
+	return stream
 }
 
-// appErrorBoundaryView renders a consistent error header for the application
-func (m model) appErrorBoundaryView(text string) string {
-	theme := m.styleThemes[m.styleThemeIndex]
-	return lipgloss.PlaceHorizontal(
-		m.width,
-		lipgloss.Left,
-		m.styles.ErrorHeaderText.Render(text),
-		lipgloss.WithWhitespaceChars("/"),
-		lipgloss.WithWhitespaceForeground(theme.Error),
-	)
+// stopRequest cancels the request in flight, if there is one. Its result still arrives, as a
+// context.Canceled error, so callers that move on bump requestID to have it ignored.
+func (m model) stopRequest() model {
+	if m.requestCancel != nil {
+		m.requestCancel()
+		m.requestCancel = nil
+	}
+	return m
 }
 
-// --- [ I/O ] ------------------------------------
-//
-// This section defines helper functions to take the user input in the viewport and pass it to the LLM.
-//
+// abandonRequest cancels the request in flight and ignores whatever it still sends. A summary
+// that was being regenerated or revised is put back.
+func (m model) abandonRequest() model {
+	m = m.stopRequest()
+	m.requestID++
+	if m.generating && (m.regenerating || m.refining) {
+		m.content = m.previousContent
+		m.gptRawOutput = m.previousOutput
+	}
+	m.generating = false
+	m.regenerating = false
+	m.refining = false
+	m.showSpinner = false
+	m.pendingTurns = nil
+	return m
+}
 
-// buildSelectedMarkdown returns a string of Markdown reflecting the selected items.
-func buildSelectedMarkdown(m model) string {
-	var sb strings.Builder
+// handleLLMChunk appends a piece of streamed output to the viewport
+func (m model) handleLLMChunk(msg llmChunkMsg) (tea.Model, tea.Cmd) {
+	// Keep draining requests that have been superseded, without letting them touch the view
+	if msg.id != m.requestID {
+		return m, waitForLLMStream(msg.id, msg.stream)
+	}
 
-	// Add form name
-	sb.WriteString(fmt.Sprintf("# %s\n\n", m.currentForm.name))
+	// Stop the spinner as soon as there's something to show
+	m.showSpinner = false
 
-	// Add questions
-	for i, question := range m.currentForm.questions {
-		sb.WriteString(fmt.Sprintf("## %d. %s\n\n", i+1, question))
-		if i < len(m.answers) {
-			sb.WriteString(fmt.Sprintf("%s\n\n", m.answers[i]))
-		}
+	m.gptRawOutput += msg.chunk
+	m.content = appendSummary(m.requestMarkdown, m.gptRawOutput)
+	if err := m.renderContent(); err != nil {
+		logf("Error rendering streamed content: %v", err)
 	}
-
-	return sb.String()
+	return m, waitForLLMStream(msg.id, msg.stream)
 }
 
-// renderMarkdownToViewport uses Glamour to transform the raw markdown into styled text.
-func renderMarkdownToViewport(md string, vp *viewport.Model, theme StyleTheme) error {
-	// Create base styles using lipgloss
-	baseStyle := lipgloss.NewStyle().Foreground(theme.Base)
-	headerStyle := lipgloss.NewStyle().
-		Foreground(theme.Base).
-		Bold(true)
+// handleLLMRetry shows that the request is waiting to be retried
+func (m model) handleLLMRetry(msg llmRetryMsg) (tea.Model, tea.Cmd) {
+	if msg.id != m.requestID {
+		return m, waitForLLMStream(msg.id, msg.stream)
+	}
 
-	// Prepare a Glamour renderer with minimal styling
-	r, err := glamour.NewTermRenderer(
-		glamour.WithAutoStyle(),
-		glamour.WithWordWrap(vp.Width),
-	)
+	theme := m.styleThemes[m.styleThemeIndex]
+	retryMsg := fmt.Sprintf("## Processing with %s\n\n%s", m.config.ActiveModel, msg.status)
+	if err := renderMarkdownToViewport(retryMsg, &m.viewport, theme, m.hyperlinks()); err != nil {
+		logf("Error rendering retry message: %v", err)
+	}
+	return m, waitForLLMStream(msg.id, msg.stream)
+}
 
-	if err != nil {
-		return err
+// handleLLMResult shows the final response of a request, or its error
+func (m model) handleLLMResult(msg llmResultMsg) (tea.Model, tea.Cmd) {
+	if msg.id != m.requestID {
+		return m, nil
+	}
+	// Results only belong in display mode, where the request was started; one that arrives
+	// after the user moved on must not pull them out of what they're doing
+	if m.currentMode != displayMode {
+		logf("Dropping the result of request %d: the display is no longer shown", msg.id)
+		return m.abandonRequest(), nil
 	}
 
-	rendered, err := r.Render(md)
-	if err != nil {
-		return err
+	m = m.stopRequest() // Finished; this only releases the context
+	m.generating = false
+	m.showSpinner = false
+	elapsed := formatElapsed(time.Since(m.requestStart))
+	bell := m.bellCmd(msg.err)
+	regenerating, refining := m.regenerating, m.refining
+	m.regenerating = false
+	m.refining = false
+	m.rejectedKey = ""
+
+	// A fallback may have answered instead of the active model
+	producedBy := m.config.ActiveModel
+	if msg.model != "" {
+		producedBy = msg.model
 	}
 
-	// Post-process the rendered content to apply our styles
-	lines := strings.Split(rendered, "\n")
-	var styledLines []string
+	if err := msg.err; err != nil {
+		logf("Error from LLM: %v", err)
 
-	for _, line := range lines {
-		// Remove any existing ANSI color codes
-		cleanLine := stripansi.Strip(line)
+		// A rejected key can be fixed right away from the display or error screen
+		var keyErr *llm.AuthError
+		fixHint := ""
+		if errors.As(err, &keyErr) {
+			m.rejectedKey = producedBy
+			fixHint = fmt.Sprintf("Press %s to update the settings for %s.", m.keys.shortLabel(actionFixSettings), m.rejectedKey)
+		}
 
-		switch {
-		case strings.HasPrefix(cleanLine, "# "):
-			// H1 headers
-			styledLines = append(styledLines, headerStyle.Render(cleanLine))
-		case strings.HasPrefix(cleanLine, "## "):
-			// H2 headers
-			styledLines = append(styledLines, headerStyle.Render(cleanLine))
-		case strings.HasPrefix(cleanLine, "### "):
-			// H3 headers
-			styledLines = append(styledLines, headerStyle.Render(cleanLine))
-		default:
-			// Regular text
-			if strings.TrimSpace(cleanLine) != "" {
-				styledLines = append(styledLines, baseStyle.Render(cleanLine))
-			} else {
-				styledLines = append(styledLines, cleanLine)
+		if regenerating || refining {
+			// Put the previous output back
+			m.content = m.previousContent
+			m.gptRawOutput = m.previousOutput
+			if err := m.renderContent(); err != nil {
+				logf("Error re-rendering previous output: %v", err)
+			}
+			action := "Regenerate"
+			if refining {
+				action = "Follow-up"
 			}
+			status := fmt.Sprintf("%s failed: %v", action, err)
+			if fixHint != "" {
+				status += ". " + fixHint
+			}
+			m.displayStatus = m.styles.ErrorStatus(status)
+			return m, bell
 		}
-	}
 
-	// Join the lines back together
-	styledContent := strings.Join(styledLines, "\n")
+		// Nothing to show yet, so the error gets a screen of its own
+		m.content = m.requestMarkdown
+		m.gptRawOutput = ""
+		m.pendingTurns = nil
+		m.requestErr = fmt.Sprintf("Failed to get response from %s", producedBy)
+		if producedBy != m.config.ActiveModel {
+			m.requestErr += fmt.Sprintf(", the last fallback tried after %s was unavailable", m.config.ActiveModel)
+		}
+		m.requestErr += fmt.Sprintf(": %v", err)
+		m.currentMode = errorMode
+		return m, bell
+	}
 
-	// Ensure the rendered content ends with a newline for proper display
-	styledContent = strings.TrimRight(styledContent, "\n") + "\n"
+	m.gptRawOutput = msg.content
+	m.analystNote = ""
+	m.generatedBy = m.config.Models[producedBy].ModelName
+	m.generatedAt = time.Now()
+	m.usage = msg.usage
+	m.content = appendSummary(m.requestMarkdown, m.gptRawOutput)
+	m.conversation = append(m.pendingTurns, llm.Message{Role: "assistant", Content: msg.content})
+	m.pendingTurns = nil
+	if err := m.renderContent(); err != nil {
+		logf("Error rendering response: %v", err)
+	}
+	status := "Generated in " + elapsed
+	if regenerating {
+		m.viewport.GotoTop()
+		status = "Summary regenerated in " + elapsed
+	}
+	if refining {
+		m.viewport.GotoTop()
+		status = fmt.Sprintf("Summary revised (turn %d) in %s", len(m.conversation)/2, elapsed)
+	}
+	if producedBy != m.config.ActiveModel {
+		status += fmt.Sprintf(" by %s (%s was unavailable)", producedBy, m.config.ActiveModel)
+	}
+	m.displayStatus = m.styles.SuccessStatus(status)
 
-	// Set the content in the viewport
-	vp.SetContent(styledContent)
-	return nil
+	logf("Request completed")
+	return m, bell
 }
 
-// handleFormCompletion combines the other helper functions to pass the input on to the LLM.
-func handleFormCompletion(m model) model {
-	// Build the Markdown
-	md := buildSelectedMarkdown(m)
-	theme := m.styleThemes[m.styleThemeIndex]
-	if err := renderMarkdownToViewport(md, &m.viewport, theme); err != nil {
-		logf("Error rendering markdown: %v", err)
+// bellCmd returns a command that rings the terminal bell for a finished request, if that's
+// turned on. Errors ring three times so they can be told apart without looking.
+func (m model) bellCmd(err error) tea.Cmd {
+	if !m.config.BellOnComplete {
+		return nil
 	}
-	m.content = md
-
-	// Update viewport style with theme colors
-	m.viewport.Style = lipgloss.NewStyle().
-		BorderStyle(lipgloss.RoundedBorder()).
-		BorderForeground(theme.Base).
-		PaddingLeft(2).
-		PaddingRight(2)
-
-	// Check if the active model has the required API key or base URL
-	activeModelConfig := m.config.Models[m.config.ActiveModel]
-	if (activeModelConfig.Provider != ProviderLocal && activeModelConfig.APIKey == "") ||
-		(activeModelConfig.Provider == ProviderLocal && activeModelConfig.APIBaseURL == "") {
-		// Go to API key input mode if needed
-		m.currentMode = apiKeyInputMode
-		return m
+	rings := 1
+	if err != nil {
+		rings = 3
+	}
+	return func() tea.Msg {
+		for i := 0; i < rings; i++ {
+			if i > 0 {
+				time.Sleep(250 * time.Millisecond)
+			}
+			terminal.Write([]byte("\a"))
+		}
+		return nil
 	}
+}
 
-	// Create a channel to capture the API request result
-	done := make(chan error, 1)
+// terminalOutput is the TUI's output. Writes are serialized so that a bell rung from a command's
+// goroutine lands between frames instead of in the middle of one; the renderer writes each
+// frame in a single call. The embedded file keeps terminal detection working.
+type terminalOutput struct {
+	mu sync.Mutex
+	*os.File
+}
 
-	// Show a simple "Processing..." message in the viewport
-	processingMsg := fmt.Sprintf("## Processing with %s\n\nGenerating summary...", m.config.ActiveModel)
-	if err := renderMarkdownToViewport(processingMsg, &m.viewport, theme); err != nil {
-		logf("Error rendering processing message: %v", err)
-	}
+func (o *terminalOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.File.Write(p)
+}
+
+// terminal is shared by the renderer, bellCmd and the OSC 52 clipboard, see terminalOutput
+var terminal = &terminalOutput{File: os.Stdout}
 
-	// Launch API request concurrently
-	go func() {
-		err := makeLLMRequest(context.TODO(), &m, md)
-		done <- err
+// ---[[ LLM Requests ]]------------------------------------------------------------
+
+// makeLLMRequest encapsulates the LLM API call. Chunks are passed to onChunk as they
+// arrive when the provider supports streaming; the full response is always returned, with the
+// provider's token usage when it reports it (cached responses have none).
+func makeLLMRequest(ctx context.Context, modelConfig llm.ModelConfig, settings RequestSettings, formPrompt, md string, onChunk func(chunk string), onRetry func(attempt, maxRetries int, delay time.Duration)) (response string, usage *llm.Usage, err error) {
+	// Requests run outside the TUI's goroutine, where an uncaught panic would leave the terminal in raw mode
+	defer func() {
+		if r := recover(); r != nil {
+			response, usage, err = "", nil, panicError("the request to "+modelConfig.ModelName, r)
+		}
 	}()
 
-	// Create a cancellable context for the spinner
-	spinnerCtx, cancelSpinner := context.WithCancel(context.Background())
-	defer cancelSpinner()
+	// Send the form's instructions as the system message and the answers as the user message
+	messages := formPromptMessages(formPrompt, md)
 
-	// Start the spinner in a separate goroutine
-	go func() {
-		err := spinner.New().
-			Context(spinnerCtx).
-			Action(func() {
-				// Instead of sleeping, just block until the spinnerCtx is cancelled
-				<-spinnerCtx.Done()
-			}).
-			Accessible(rand.Int()%2 == 0).
-			Run()
-		if err != nil {
-			logf("Spinner error: %v", err)
+	cacheKey := responseCacheKey(modelConfig, messages)
+	if settings.CacheDir != "" && !settings.RefreshCache {
+		if response, ok := readCachedResponse(settings, cacheKey); ok {
+			logf("Using cached response %s", cacheKey)
+			return response, nil, nil
 		}
-	}()
+	}
 
-	// Wait for the API request to complete
-	if err := <-done; err != nil {
-		logf("Error from LLM: %v", err)
-		// Show error in viewport
-		errorMsg := fmt.Sprintf("## Error\n\nFailed to get response from %s: %v\n\nCheck the log file for details.",
-			m.config.ActiveModel, err)
-		if err := renderMarkdownToViewport(errorMsg, &m.viewport, theme); err != nil {
-			logf("Error rendering error message: %v", err)
+	resp, usage, err := processConversationWithLLM(ctx, modelConfig, settings, messages, onChunk, onRetry)
+	if err != nil {
+		return "", nil, fmt.Errorf("LLM API error: %w", err)
+	}
+
+	if settings.CacheDir != "" {
+		if err := writeCachedResponse(settings, cacheKey, resp); err != nil {
+			logf("Failed to cache response: %v", err)
 		}
 	}
 
-	// Cancel the spinner once the API request is done
-	cancelSpinner()
+	return resp, usage, nil
+}
 
-	logf("Request completed")
-	m.currentMode = displayMode
-	return m
+// namedModel is a model's settings together with its key in the config
+type namedModel struct {
+	key    string
+	config llm.ModelConfig
 }
 
-// ---[[ LLM Requests ]]------------------------------------------------------------
+// modelChain returns the models a summary request with modelKey tries, in order: modelKey
+// itself, then its fallbacks. Fallbacks that are missing from the config or not set up are
+// skipped, as is modelKey if it's listed again.
+func (c Config) modelChain(modelKey string) []namedModel {
+	chain := []namedModel{{key: modelKey, config: c.Models[modelKey]}}
+	listed := map[string]bool{modelKey: true}
+	for _, key := range c.Fallbacks {
+		if listed[key] {
+			continue
+		}
+		modelConfig, ok := c.Models[key]
+		if !ok {
+			logf("WARNING: fallback %q is not in the config, skipping it", key)
+			continue
+		}
+		if err := modelConfigured(key, modelConfig); err != nil {
+			logf("WARNING: skipping fallback: %v", err)
+			continue
+		}
+		listed[key] = true
+		chain = append(chain, namedModel{key: key, config: modelConfig})
+	}
+	return chain
+}
 
-// makeLLMRequest encapsulates the LLM API call & viewport re-rendering.
-func makeLLMRequest(ctx context.Context, m *model, md string) error {
-	// Get the active model configuration
-	activeModelConfig := m.config.Models[m.config.ActiveModel]
+// makeLLMRequestWithFallbacks sends the request to the first model of chain, moving on to the
+// next while the provider is unavailable (see llm.IsUnavailable). Other errors, such as a
+// rejected API key, end the request so a misconfigured provider isn't hidden. It returns the
+// response and its usage (see makeLLMRequest), and the model that produced the response, or the
+// last one tried.
+func makeLLMRequestWithFallbacks(ctx context.Context, chain []namedModel, settings RequestSettings, formPrompt, md string, onChunk func(chunk string), onRetry func(attempt, maxRetries int, delay time.Duration)) (string, *llm.Usage, namedModel, error) {
+	var response string
+	var usage *llm.Usage
+	var err error
+	for i, model := range chain {
+		if i > 0 {
+			logf("Fallback: %s is unavailable (%v), trying %s", chain[i-1].key, err, model.key)
+		}
+		response, usage, err = makeLLMRequest(ctx, model.config, settings, formPrompt, md, onChunk, onRetry)
+		if err == nil {
+			if i > 0 {
+				logf("Fallback: summary produced by %s", model.key)
+			}
+			return response, usage, model, nil
+		}
+		if !llm.IsUnavailable(err) || ctx.Err() != nil || i == len(chain)-1 {
+			return "", nil, model, err
+		}
+	}
+	return "", nil, namedModel{}, fmt.Errorf("no model to send the request to")
+}
 
-	// Append the prompt to the generated response
-	combinedPrompt := m.currentForm.prompt + "\n\n" + md
+// combinePrompt puts the form's instructions ahead of the answers markdown
+func combinePrompt(formPrompt, md string) string {
+	return formPrompt + "\n\n" + md
+}
 
-	// Step 1 - Call the LLM with the generated response Markdown
-	resp, err := processFormWithLLM(ctx, activeModelConfig, combinedPrompt)
-	if err != nil {
-		return fmt.Errorf("LLM API error: %v", err)
+// formPromptMessages returns the opening of a conversation about a form: its instructions as
+// the system message and the answers markdown as the user message
+func formPromptMessages(formPrompt, md string) []llm.Message {
+	if formPrompt == "" {
+		return llm.UserPrompt(md)
 	}
+	return []llm.Message{{Role: "system", Content: formPrompt}, {Role: "user", Content: md}}
+}
 
-	m.gptRawOutput = resp // Store the raw output
-
-	// Step 2 - Append the LLM's response as an optional "analysis" or "summary"
-	summary := "\n## Ticket Summary\n\n" + resp
-	appendedContent := md + summary
+// appendSummary appends the LLM's response to the answers as a "summary" section
+func appendSummary(md, response string) string {
+	return md + "\n## Ticket Summary\n\n" + response
+}
 
-	// Step 3 - Re-render the viewport with the appended content
-	if err := renderMarkdownToViewport(appendedContent, &m.viewport, m.styleThemes[m.styleThemeIndex]); err != nil {
-		return fmt.Errorf("render markdown error: %v", err)
-	}
-	m.content = appendedContent
-	return nil
+// formatElapsed rounds a request's duration for display, e.g. "7.3s"
+func formatElapsed(d time.Duration) string {
+	return d.Round(100 * time.Millisecond).String()
 }
 
-func processFormWithLLM(ctx context.Context, modelConfig ModelConfig, content string) (string, error) {
+// newLLMClient creates the client requests are sent with. It can be swapped for one that
+// returns an llm.MockClient to exercise the UI without a provider.
+var newLLMClient = llm.CreateLLMClient
+
+// processConversationWithLLM sends a conversation and returns the model's next reply, with the
+// token usage the provider reported or nil if it reports none. Clients that can't take separate
+// messages get the conversation written out as one prompt.
+func processConversationWithLLM(ctx context.Context, modelConfig llm.ModelConfig, settings RequestSettings, messages []llm.Message, onChunk func(chunk string), onRetry func(attempt, maxRetries int, delay time.Duration)) (string, *llm.Usage, error) {
 	logf("Processing request with provider: %s, model: %s", modelConfig.Provider, modelConfig.ModelName)
+	logf("Generation settings: %s", modelConfig.GenerationParams())
 
 	// Create the appropriate LLM client based on the model configuration
-	client, err := CreateLLMClient(modelConfig)
+	httpClient, err := newHTTPClient(settings.ProxyURL)
+	if err != nil {
+		logf("ERROR: %v", err)
+		return "", nil, err
+	}
+
+	client, err := newLLMClient(modelConfig, httpClient)
 	if err != nil {
 		logf("ERROR: Failed to create LLM client: %v", err)
-		return "", fmt.Errorf("failed to create LLM client: %v", err)
+		return "", nil, fmt.Errorf("failed to create LLM client: %v", err)
 	}
 
 	logf("Client created successfully, sending request to %s", modelConfig.Provider)
 
 	// Calculate prompt size metrics
+	content := llm.FlattenConversation(messages)
 	promptCharLength := len(content)
 	promptLines := len(strings.Split(content, "\n"))
-	logf("Sending prompt with %d characters, %d lines", promptCharLength, promptLines)
+	logf("Sending prompt with %d characters, %d lines, %d messages", promptCharLength, promptLines, len(messages))
+	logf("Estimated prompt tokens: %d (context window: %d)", estimateTokens(content), contextWindow(modelConfig))
+
+	// Stream the response when the client supports it, otherwise wait for the whole thing.
+	// Transient errors are retried, unless part of the response has already been shown.
+	// Each attempt gets its own deadline.
+	streamed := false
+	limiter := rateLimiterFor(modelConfig)
+	start := time.Now()
+	response, err := withRetry(ctx, settings.Retry, onRetry, func() (string, error) {
+		if err := limiter.wait(ctx, onRetry); err != nil {
+			return "", err
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, settings.Timeout)
+		defer cancel()
 
-	// Use the client to complete the prompt
-	response, err := client.Complete(ctx, content)
+		var streamChunk func(chunk string)
+		if onChunk != nil {
+			streamChunk = func(chunk string) {
+				streamed = true
+				onChunk(chunk)
+			}
+		}
+
+		var response string
+		var err error
+		if conversation, ok := client.(llm.ConversationLLMClient); ok {
+			response, err = conversation.CompleteConversation(attemptCtx, messages, streamChunk)
+		} else if streamer, ok := client.(llm.StreamingLLMClient); ok && streamChunk != nil {
+			response, err = streamer.CompleteStream(attemptCtx, content, streamChunk)
+		} else {
+			response, err = client.Complete(attemptCtx, content)
+		}
+		if err != nil && attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = &timeoutError{timeout: settings.Timeout, err: err}
+		}
+		if err != nil && streamed {
+			return "", fmt.Errorf("stream interrupted: %v", err)
+		}
+		return response, err
+	})
+	elapsed := formatElapsed(time.Since(start))
 	if err != nil {
-		logf("ERROR: %s completion failed: %v", modelConfig.Provider, err)
-		return "", err
+		logf("ERROR: %s completion failed after %s: %v", modelConfig.Provider, elapsed, err)
+		return "", nil, err
 	}
 
-	logf("Request completed successfully, received %d character response", len(response))
-	return response, nil
+	logf("Request to %s (%s) completed in %s, received %d character response", modelConfig.Provider, modelConfig.ModelName, elapsed, len(response))
+	var usage *llm.Usage
+	if reporter, ok := client.(llm.UsageReporter); ok {
+		usage = reporter.LastUsage()
+	}
+	if usage != nil {
+		logf("Token usage: %d prompt, %d completion", usage.PromptTokens, usage.CompletionTokens)
+	}
+	return response, usage, nil
 }
 
-// ---[[ LLM Client Interface ]]------------------------------------------------------------
-
-// LLMClient defines the interface for different LLM providers
-type LLMClient interface {
-	Complete(ctx context.Context, prompt string) (string, error)
-}
+// ---[[ Rate Limiting ]]-------------------------------------------------------
 
-// OpenAIClient implements the LLMClient interface for OpenAI
-type OpenAIClient struct {
-	client *openai.Client
-	model  string
+// rateLimiter is a token bucket holding up to perMinute requests, refilled at perMinute a
+// minute, so short bursts go through and longer ones are spread out
+type rateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	tokens    float64
+	last      time.Time
 }
 
-func NewOpenAIClient(apiKey, model string) *OpenAIClient {
-	client := openai.NewClient(
-		option.WithAPIKey(apiKey),
-	)
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = map[string]*rateLimiter{}
+)
 
-	return &OpenAIClient{
-		client: client,
-		model:  model,
+// rateLimiterFor returns the limiter shared by every request to the same provider (or local
+// server) with the same limit, or nil if the entry has no limit
+func rateLimiterFor(config llm.ModelConfig) *rateLimiter {
+	if config.RequestsPerMinute <= 0 {
+		return nil
+	}
+	key := fmt.Sprintf("%s|%s|%d", config.Provider, config.APIBaseURL, config.RequestsPerMinute)
+
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	limiter, ok := rateLimiters[key]
+	if !ok {
+		limiter = &rateLimiter{perMinute: config.RequestsPerMinute, tokens: float64(config.RequestsPerMinute), last: time.Now()}
+		rateLimiters[key] = limiter
 	}
+	return limiter
 }
 
-func (c *OpenAIClient) Complete(ctx context.Context, prompt string) (string, error) {
-	logf("OpenAI: Sending request to model %s", c.model)
+// reserve takes a token and returns how long to wait before it may be used. Tokens can be
+// owed, so concurrent requests queue up behind each other.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	params := openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		}),
-		Model: openai.F(c.model),
+	perSecond := float64(l.perMinute) / 60
+	l.tokens += now.Sub(l.last).Seconds() * perSecond
+	if l.tokens > float64(l.perMinute) {
+		l.tokens = float64(l.perMinute)
 	}
+	l.last = now
 
-	logf("OpenAI: Calling Chat Completions API")
-	chatCompletion, err := c.client.Chat.Completions.New(ctx, params)
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / perSecond * float64(time.Second))
+}
 
-	if err != nil {
-		logf("OpenAI ERROR: API request failed: %v", err)
-		return "", err
+// wait blocks until the limiter allows another request. A wait is reported to onRetry with an
+// attempt of 0, see runLLMStream. A nil limiter never waits.
+func (l *rateLimiter) wait(ctx context.Context, onRetry func(attempt, maxRetries int, delay time.Duration)) error {
+	if l == nil {
+		return nil
+	}
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
 	}
 
-	logf("OpenAI: Request successful, received %d choices", len(chatCompletion.Choices))
-	if len(chatCompletion.Choices) > 0 {
-		responseLength := len(chatCompletion.Choices[0].Message.Content)
-		logf("OpenAI: Response length: %d characters", responseLength)
+	logf("Rate-limited locally (%d requests per minute), waiting %s", l.perMinute, delay.Round(100*time.Millisecond))
+	if onRetry != nil {
+		onRetry(0, 0, delay)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
 	}
+}
+
+// ---[[ Response Cache ]]------------------------------------------------------
 
-	return chatCompletion.Choices[0].Message.Content, nil
+// cachedResponse is the file stored for each cached response, named after its key
+type cachedResponse struct {
+	Created  time.Time `json:"created"`
+	Response string    `json:"response"`
 }
 
-// ClaudeClient implements the LLMClient interface for Anthropic
-type ClaudeClient struct {
-	client *anthropic.Client
-	model  string
+// responseCacheKey hashes everything that shapes a response: the provider, model, server,
+// messages and sampling settings. API keys aren't part of it.
+func responseCacheKey(modelConfig llm.ModelConfig, messages []llm.Message) string {
+	data, _ := json.Marshal(struct {
+		Provider llm.ModelProvider
+		Model    string
+		BaseURL  string
+		APIStyle llm.APIStyle
+		Params   llm.GenerationParams
+		Messages []llm.Message
+	}{modelConfig.Provider, modelConfig.ModelName, modelConfig.APIBaseURL, modelConfig.APIStyle, modelConfig.GenerationParams(), messages})
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum)
 }
 
-func NewClaudeClient(apiKey, model string) *ClaudeClient {
-	client := anthropic.NewClient(apiKey)
+// readCachedResponse returns the cached response for key if there is one younger than the TTL.
+// Expired entries are removed.
+func readCachedResponse(settings RequestSettings, key string) (string, bool) {
+	path := filepath.Join(settings.CacheDir, key+".json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	var entry cachedResponse
+	if err := json.Unmarshal(data, &entry); err != nil {
+		logf("Ignoring unreadable cache entry %s: %v", path, err)
+		return "", false
+	}
+	if time.Since(entry.Created) > settings.CacheTTL {
+		os.Remove(path)
+		return "", false
+	}
+	return entry.Response, true
+}
 
-	return &ClaudeClient{
-		client: client,
-		model:  model,
+// writeCachedResponse stores a response under key. Entries hold ticket contents, so only the
+// user can read them.
+func writeCachedResponse(settings RequestSettings, key, response string) error {
+	if err := os.MkdirAll(settings.CacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	data, err := json.Marshal(cachedResponse{Created: time.Now(), Response: response})
+	if err != nil {
+		return err
 	}
+	return ioutil.WriteFile(filepath.Join(settings.CacheDir, key+".json"), data, 0600)
 }
 
-func (c *ClaudeClient) Complete(ctx context.Context, prompt string) (string, error) {
-	logf("Claude: Sending request to model %s", c.model)
+// ---[[ Timeouts and Retries ]]------------------------------------------------
 
-	// Log model version info to help with debugging
-	logf("Claude: Using client with model %s", c.model)
+// Defaults used when the config doesn't set timeout_seconds, max_retries or retry_base_delay_ms
+const (
+	defaultRequestTimeout = 120 * time.Second
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = time.Second
+	maxRetryDelay         = 30 * time.Second
+)
 
-	// Use the go-anthropic client to create a messages completion
-	mesReq := anthropic.MessagesRequest{
-		Model: c.model,
-		Messages: []anthropic.Message{
-			{
-				Role: anthropic.RoleUser,
-				Content: []anthropic.MessageContent{
-					{
-						Type: "text",
-						Text: &prompt,
-					},
-				},
-			},
-		},
-		MaxTokens: 4096,
+// RequestSettings controls how requests are sent, how long they may take, and how failures are retried
+type RequestSettings struct {
+	Timeout  time.Duration // Applies to each attempt
+	Retry    RetryPolicy
+	ProxyURL string
+
+	// Form responses are cached here for CacheTTL; an empty CacheDir turns the cache off
+	CacheDir     string
+	CacheTTL     time.Duration
+	RefreshCache bool // Skip cached responses; the new response is still cached
+}
+
+// requestSettings returns the request settings from the config, falling back to the defaults
+func (c Config) requestSettings() RequestSettings {
+	settings := RequestSettings{Timeout: defaultRequestTimeout, Retry: c.retryPolicy(), ProxyURL: c.ProxyURL}
+	if c.TimeoutSeconds > 0 {
+		settings.Timeout = time.Duration(c.TimeoutSeconds) * time.Second
 	}
+	if c.CacheTTLMinutes > 0 && c.dir != "" {
+		settings.CacheDir = filepath.Join(c.dir, "cache")
+		settings.CacheTTL = time.Duration(c.CacheTTLMinutes) * time.Minute
+	}
+	return settings
+}
 
-	logf("Claude: Sending message to %s with max tokens: %d", c.model, mesReq.MaxTokens)
+// RetryPolicy controls how transient API errors are retried
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+}
 
-	resp, err := c.client.CreateMessages(ctx, mesReq)
-	if err != nil {
-		var apiErr *anthropic.APIError
-		if errors.As(err, &apiErr) {
-			logf("Claude ERROR: API error (type: %s): %s", apiErr.Type, apiErr.Message)
+// retryPolicy returns the retry settings from the config, falling back to the defaults
+func (c Config) retryPolicy() RetryPolicy {
+	policy := RetryPolicy{MaxRetries: defaultMaxRetries, BaseDelay: defaultRetryBaseDelay}
+	if c.MaxRetries != nil && *c.MaxRetries >= 0 {
+		policy.MaxRetries = *c.MaxRetries
+	}
+	if c.RetryBaseDelayMs > 0 {
+		policy.BaseDelay = time.Duration(c.RetryBaseDelayMs) * time.Millisecond
+	}
+	return policy
+}
 
-			// Provide helpful guidance for model not found errors
-			if apiErr.Type == "not_found_error" && strings.Contains(apiErr.Message, "model") {
-				logf("Claude ERROR: The specified model name '%s' was not found", c.model)
-				logf("Claude INFO: Available Claude models typically include:")
-				logf("  - claude-3-opus-20240229")
-				logf("  - claude-3-sonnet-20240229")
-				logf("  - claude-3-haiku-20240307")
-				return "", fmt.Errorf("Claude API error: Model '%s' not found. Try using claude-3-opus-20240229, claude-3-sonnet-20240229, or claude-3-haiku-20240307", c.model)
-			}
+// proxyEnvVars are the environment variables http.ProxyFromEnvironment looks at
+var proxyEnvVars = []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"}
 
-			return "", fmt.Errorf("Claude API error (type: %s): %s", apiErr.Type, apiErr.Message)
+// newHTTPClient returns the HTTP client used for all provider requests. It goes through proxyURL
+// if one is configured, and otherwise through the proxy from the environment.
+func newHTTPClient(proxyURL string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy_url %q", proxyURL)
 		}
-		logf("Claude ERROR: Unknown error: %v", err)
-		return "", fmt.Errorf("Claude API error: %v", err)
+		logf("Using proxy from config: %s", u.Redacted())
+		transport.Proxy = http.ProxyURL(u)
+		return &http.Client{Transport: transport}, nil
 	}
 
-	logf("Claude: Response received! ID: %s, Model: %s", resp.ID, resp.Model)
-
-	// Get the response text from the content blocks
-	if len(resp.Content) > 0 {
-		for _, content := range resp.Content {
-			if content.Type == "text" {
-				return content.Text, nil
+	transport.Proxy = http.ProxyFromEnvironment
+	for _, name := range proxyEnvVars {
+		if value := os.Getenv(name); value != "" {
+			if u, err := url.Parse(value); err == nil {
+				value = u.Redacted()
 			}
+			logf("Proxy setting from environment: %s=%s", name, value)
 		}
 	}
-
-	return "", fmt.Errorf("Claude returned no text content")
+	return &http.Client{Transport: transport}, nil
 }
 
-// LocalLLMClient implements the LLMClient interface for local LLMs
-type LocalLLMClient struct {
-	baseURL string
-	model   string
+// timeoutError replaces the assorted errors the clients return when a request runs out of time
+type timeoutError struct {
+	timeout time.Duration
+	err     error
 }
 
-func NewLocalLLMClient(baseURL, model string) *LocalLLMClient {
-	return &LocalLLMClient{
-		baseURL: baseURL,
-		model:   model,
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("request timed out after %s", e.timeout)
+}
+func (e *timeoutError) Unwrap() error   { return e.err }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// retryDelay returns the exponential backoff for an attempt (starting at 1), with jitter
+func retryDelay(policy RetryPolicy, attempt int) time.Duration {
+	delay := policy.BaseDelay << uint(attempt-1)
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
 	}
+	// Wait between half and all of the delay so that clients don't retry in lockstep
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
 }
 
-func (c *LocalLLMClient) Complete(ctx context.Context, prompt string) (string, error) {
-	logf("Local LLM: Sending request to %s, model: %s", c.baseURL, c.model)
+// withRetry calls fn, retrying transient errors according to policy. onRetry (which may be nil)
+// is called before each wait with the retry number and the delay.
+func withRetry(ctx context.Context, policy RetryPolicy, onRetry func(attempt, maxRetries int, delay time.Duration), fn func() (string, error)) (string, error) {
+	for attempt := 1; ; attempt++ {
+		response, err := fn()
+		if err == nil {
+			return response, nil
+		}
 
-	// Format the base URL correctly for the Ollama API
-	baseURL := c.baseURL
+		retryable, retryAfter := llm.IsRetryable(err)
+		if !retryable || attempt > policy.MaxRetries {
+			return "", err
+		}
 
-	// Strip trailing slashes
-	baseURL = strings.TrimSuffix(baseURL, "/")
+		delay := retryDelay(policy, attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		logf("Transient error (%v), retrying (%d/%d) in %s", err, attempt, policy.MaxRetries, delay)
+		if onRetry != nil {
+			onRetry(attempt, policy.MaxRetries, delay)
+		}
 
-	// For Ollama, use the simpler API endpoint format
-	if strings.Contains(baseURL, "localhost:11434") || strings.Contains(baseURL, "127.0.0.1:11434") {
-		// For Ollama, use its native API format: /api/chat
-		logf("Local LLM: Detected Ollama server, using native API endpoint")
-		baseURL = baseURL + "/api/chat"
-	} else {
-		// For OpenAI-compatible APIs, use the standard endpoint format
-		// First, check for existing path components to avoid duplication
-		if strings.Contains(baseURL, "/v1/chat/completions") {
-			// URL already contains the correct full path, use as is
-			logf("Local LLM: URL already contains complete path")
-		} else if strings.Contains(baseURL, "/chat/completions") {
-			// URL already contains the correct endpoint, use as is
-			logf("Local LLM: URL already contains chat/completions endpoint")
-		} else if strings.HasSuffix(baseURL, "/v1") {
-			// URL ends with /v1, add /chat/completions
-			baseURL = baseURL + "/chat/completions"
-		} else {
-			// Add the standard endpoint path
-			baseURL = baseURL + "/v1/chat/completions"
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
 		}
 	}
+}
 
-	logf("Local LLM: Using final endpoint URL: %s", baseURL)
+// ---[ Non-interactive Mode ]------------------------------------------------
+//
+// With --form, TicketDuck skips the TUI: answers are read from a JSON file, sent to the model
+// through the same request path as the interactive mode, and the summary is written out.
+
+// cliOptions holds the flags used by the non-interactive mode
+type cliOptions struct {
+	form        string
+	answersFile string
+	modelKey    string
+	output      string
+	timeout     time.Duration
+	configDir   string
+	profile     string
+	encryptKeys bool
+	batchDir    string
+	concurrency int
+}
 
-	// Create a client with the exact URL
-	client := openai.NewClient(
-		option.WithBaseURL(baseURL),
-	)
+// cliModel returns the model to use for a run without the TUI: the one named, or else the
+// active one, as long as it's configured well enough to send a request
+func cliModel(config Config, modelKey string) (string, llm.ModelConfig, error) {
+	if modelKey == "" {
+		modelKey = config.ActiveModel
+	}
+	if modelKey == "" {
+		return "", llm.ModelConfig{}, fmt.Errorf("no model selected; pass --model or pick one in the TUI")
+	}
+	modelConfig, ok := config.Models[modelKey]
+	if !ok {
+		return "", llm.ModelConfig{}, fmt.Errorf("unknown model %q", modelKey)
+	}
+	if err := modelConfigured(modelKey, modelConfig); err != nil {
+		return "", llm.ModelConfig{}, err
+	}
+	return modelKey, modelConfig, nil
+}
 
-	// For Ollama's native API format
-	if strings.Contains(baseURL, "/api/chat") {
-		// Create Ollama-specific request body
-		type OllamaMessage struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		}
+// modelConfigured checks that a model has what a request needs: an API key, or a server
+// address for local models
+func modelConfigured(modelKey string, modelConfig llm.ModelConfig) error {
+	if modelConfig.Provider != llm.ProviderLocal && modelConfig.APIKey == "" {
+		return fmt.Errorf("model %q has no API key configured", modelKey)
+	}
+	if modelConfig.Provider == llm.ProviderLocal && modelConfig.APIBaseURL == "" {
+		return fmt.Errorf("model %q has no base URL configured", modelKey)
+	}
+	return nil
+}
 
-		type OllamaRequest struct {
-			Model    string          `json:"model"`
-			Messages []OllamaMessage `json:"messages"`
-			Stream   bool            `json:"stream"`
+// cliRetryNotice returns the onRetry callback for runs without the TUI, which reports waits on
+// stderr. prefix tells apart the requests of a batch.
+func cliRetryNotice(prefix string) func(attempt, maxRetries int, delay time.Duration) {
+	return func(attempt, maxRetries int, delay time.Duration) {
+		if attempt == 0 {
+			fmt.Fprintf(os.Stderr, "%sRate-limited locally, waiting %s...\n", prefix, delay.Round(100*time.Millisecond))
+			return
 		}
+		fmt.Fprintf(os.Stderr, "%sRetrying (%d/%d) in %s...\n", prefix, attempt, maxRetries, delay.Round(100*time.Millisecond))
+	}
+}
 
-		ollamaReq := OllamaRequest{
-			Model: c.model,
-			Messages: []OllamaMessage{
-				{
-					Role:    "user",
-					Content: prompt,
-				},
-			},
-			Stream: false, // Don't stream for simpler response handling
+// findFormType returns the form with the given name (ignoring case)
+func findFormType(formTypes []formType, name string) (formType, error) {
+	var names []string
+	for _, form := range formTypes {
+		if strings.EqualFold(form.name, name) {
+			return form, nil
 		}
+		names = append(names, fmt.Sprintf("%q", form.name))
+	}
+	return formType{}, fmt.Errorf("unknown form %q (available: %s)", name, strings.Join(names, ", "))
+}
 
-		logf("Local LLM: Using Ollama-specific request format")
-		jsonBody, err := json.Marshal(ollamaReq)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal Ollama request: %v", err)
-		}
+// loadAnswersFile reads a JSON or YAML (.yaml, .yml) mapping of questions to answers and lines
+// the answers up with the form's questions. A question is named by its text or by its number,
+// starting at 1. Questions without an answer are left blank; a key that matches no question is
+// an error, so a typo doesn't silently drop an answer.
+func loadAnswersFile(path string, form formType) ([]string, error) {
+	byQuestion, err := readAnswersFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return matchAnswers(byQuestion, form)
+}
 
-		// Create HTTP request
-		req, err := http.NewRequestWithContext(ctx, "POST", baseURL, bytes.NewBuffer(jsonBody))
-		if err != nil {
-			return "", fmt.Errorf("failed to create HTTP request: %v", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
+// readAnswersFile reads the key/answer pairs of a JSON or YAML answers file
+func readAnswersFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read answers file: %v", err)
+	}
 
-		// Send request
-		httpClient := &http.Client{
-			Timeout: 120 * time.Second, // Set a longer timeout for LLM responses
-		}
+	var byQuestion map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		byQuestion, err = parseSimpleYAML(data)
+	default:
+		err = json.Unmarshal(data, &byQuestion)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse answers file: %v", err)
+	}
+	return byQuestion, nil
+}
 
-		logf("Local LLM: Sending request to Ollama API at %s", baseURL)
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			logf("Local LLM ERROR: API request failed: %v", err)
-			return "", fmt.Errorf("Local LLM API error: %v", err)
+// matchAnswers lines the answers up with the form's questions, as described for loadAnswersFile
+func matchAnswers(byQuestion map[string]string, form formType) ([]string, error) {
+	answers := make([]string, len(form.questions))
+	var unknown []string
+	for key, answer := range byQuestion {
+		i := -1
+		if n, err := strconv.Atoi(strings.TrimSpace(key)); err == nil && n >= 1 && n <= len(form.questions) {
+			i = n - 1
+		} else {
+			for j, question := range form.questions {
+				if strings.TrimSpace(key) == question {
+					i = j
+					break
+				}
+			}
 		}
-		defer resp.Body.Close()
-
-		// Log response status
-		logf("Local LLM: Received response with status: %s", resp.Status)
-
-		// Check for non-200 status code
-		if resp.StatusCode != http.StatusOK {
-			// Read error response body
-			errBody, _ := ioutil.ReadAll(resp.Body)
-			logf("Local LLM ERROR: Bad status code: %d, response: %s", resp.StatusCode, string(errBody))
-			return "", fmt.Errorf("Ollama API returned %s: %s", resp.Status, string(errBody))
+		if i < 0 {
+			unknown = append(unknown, fmt.Sprintf("%q", key))
+			continue
 		}
+		answers[i] = strings.TrimSpace(answer)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("no question in %q matches %s from the answers file (use the question text or its number, 1-%d)",
+			form.name, strings.Join(unknown, ", "), len(form.questions))
+	}
+	if i := form.missingAnswer(answers); i >= 0 {
+		return nil, fmt.Errorf("question %d of %q is required but the answers file has no answer for it: %s", i+1, form.name, form.questions[i])
+	}
+	return answers, nil
+}
 
-		// Read the full response body
-		responseBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			logf("Local LLM ERROR: Failed to read response body: %v", err)
-			return "", fmt.Errorf("failed to read Ollama response: %v", err)
+// parseSimpleYAML reads the flat YAML an answers file needs: one `key: value` per line, with
+// optional quotes around either, and `key: |` followed by indented lines for an answer that
+// spans several. Blank lines, comments, and a leading "---" are skipped.
+func parseSimpleYAML(data []byte) (map[string]string, error) {
+	unquote := func(s string) (string, error) {
+		switch {
+		case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+			return strconv.Unquote(s)
+		case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+			return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
 		}
+		return s, nil
+	}
 
-		// Log the raw response for debugging
-		logf("Local LLM: Raw response from Ollama (%d bytes): %.500s...", len(responseBody), string(responseBody))
-
-		// Parse response
-		var result struct {
-			Message struct {
-				Content string `json:"content"`
-				Role    string `json:"role"`
-			} `json:"message"`
-			Done bool `json:"done"`
+	values := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for n := 0; n < len(lines); n++ {
+		line := lines[n]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
 		}
-
-		if err := json.Unmarshal(responseBody, &result); err != nil {
-			logf("Local LLM ERROR: Failed to parse Ollama response JSON: %v", err)
-			logf("Local LLM ERROR: Response causing the error: %.500s...", string(responseBody))
-			return "", fmt.Errorf("failed to parse Ollama response: %v", err)
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n+1)
 		}
 
-		responseContent := result.Message.Content
-		responseRole := result.Message.Role
-		logf("Local LLM: Response content length: %d characters, role: %s", len(responseContent), responseRole)
-
-		// Log a substantial preview of the response
-		if len(responseContent) > 0 {
-			previewLength := 500
-			if len(responseContent) < previewLength {
-				previewLength = len(responseContent)
+		// Split at the colon that ends the key, which may be quoted and contain colons itself
+		var key, rest string
+		if trimmed[0] == '"' || trimmed[0] == '\'' {
+			end := strings.IndexByte(trimmed[1:], trimmed[0])
+			if end < 0 || !strings.HasPrefix(trimmed[end+2:], ":") {
+				return nil, fmt.Errorf("line %d: expected \"key: value\"", n+1)
+			}
+			key, rest = trimmed[:end+2], trimmed[end+3:]
+		} else {
+			i := strings.Index(trimmed+" ", ": ")
+			if i < 0 {
+				return nil, fmt.Errorf("line %d: expected \"key: value\"", n+1)
 			}
-			logf("Local LLM: Response preview: %s", responseContent[:previewLength])
+			key, rest = trimmed[:i], trimmed[min(i+2, len(trimmed)):]
+		}
+		key, err := unquote(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: %q is given more than once", n+1, key)
+		}
 
-			// Also log the end of the content if it's longer
-			if len(responseContent) > previewLength {
-				endPreviewStart := len(responseContent) - 100
-				if endPreviewStart < previewLength {
-					endPreviewStart = previewLength
+		value := strings.TrimSpace(rest)
+		if value == "|" || value == "|-" {
+			// A block: the following indented (or blank) lines, with the indentation removed
+			var block []string
+			indent := ""
+			for n+1 < len(lines) {
+				next := lines[n+1]
+				if strings.TrimSpace(next) == "" {
+					block = append(block, "")
+					n++
+					continue
+				}
+				if next[0] != ' ' && next[0] != '\t' {
+					break
 				}
-				logf("Local LLM: Response end: %s", responseContent[endPreviewStart:])
+				if indent == "" {
+					indent = next[:len(next)-len(strings.TrimLeft(next, " \t"))]
+				}
+				block = append(block, strings.TrimPrefix(next, indent))
+				n++
 			}
-		} else {
-			logf("Local LLM WARNING: Received empty response content")
+			values[key] = strings.TrimSpace(strings.Join(block, "\n"))
+			continue
 		}
 
-		return responseContent, nil
+		if value != "" && value[0] != '"' && value[0] != '\'' {
+			// Drop a trailing comment from a plain value
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		if values[key], err = unquote(value); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
 	}
+	return values, nil
+}
 
-	// Standard OpenAI-compatible API for non-Ollama servers
-	// Structure the request according to OpenAI's expectations
-	messages := []openai.ChatCompletionMessageParamUnion{
-		openai.UserMessage(prompt),
+// runNonInteractive builds the form markdown from the answers file, sends it to the model, and
+// writes the summary to the output file (or stdout)
+func runNonInteractive(opts cliOptions) error {
+	config, err := loadConfig(opts.configDir, opts.profile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
 	}
 
-	params := openai.ChatCompletionNewParams{
-		Messages: openai.F(messages),
-		Model:    openai.F(c.model),
+	modelKey, modelConfig, err := cliModel(config, opts.modelKey)
+	if err != nil {
+		return err
 	}
 
-	logf("Local LLM: Sending request to model: %s with prompt: %.100s...", c.model, prompt)
-
-	// Make the API call
-	chatCompletion, err := client.Chat.Completions.New(ctx, params)
+	form, err := findFormType(loadFormTypes(opts.configDir), opts.form)
+	if err != nil {
+		return err
+	}
 
+	answers, err := loadAnswersFile(opts.answersFile, form)
 	if err != nil {
-		logf("Local LLM ERROR: API request failed: %v", err)
+		return err
+	}
 
-		// Additional debugging information
-		logf("Request details - URL: %s, Model: %s", baseURL, c.model)
-		logf("Error details: %v", err)
+	md := buildSelectedMarkdown(model{currentForm: form, answers: answers})
+	logf("Running %q non-interactively with %s", form.name, modelKey)
 
-		return "", fmt.Errorf("Local LLM API error: %v", err)
+	formPrompt := config.requestPrompt(form)
+	if warning := contextWindowWarning(modelConfig, combinePrompt(formPrompt, md)); warning != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
 	}
 
-	// Debug the response
-	logf("Local LLM: Response received, choices: %d", len(chatCompletion.Choices))
-
-	if len(chatCompletion.Choices) == 0 {
-		return "", fmt.Errorf("No content returned from the LLM")
+	settings := config.requestSettings()
+	if opts.timeout > 0 {
+		settings.Timeout = opts.timeout
 	}
 
-	responseContent := chatCompletion.Choices[0].Message.Content
-	logf("Local LLM: Response content length: %d", len(responseContent))
-	logf("Local LLM: Response preview: %.100s...", responseContent)
+	response, _, used, err := makeLLMRequestWithFallbacks(context.Background(), config.modelChain(modelKey), settings, formPrompt, md, nil, cliRetryNotice(""))
+	if err != nil {
+		return err
+	}
+	if used.key != modelKey {
+		fmt.Fprintf(os.Stderr, "%s was unavailable; the summary was generated by %s\n", modelKey, used.key)
+	}
 
-	return responseContent, nil
+	if opts.output == "" {
+		fmt.Println(response)
+		return nil
+	}
+	if err := ioutil.WriteFile(opts.output, []byte(response+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+	return nil
 }
 
-// CreateLLMClient creates an appropriate client based on the model configuration
-func CreateLLMClient(config ModelConfig) (LLMClient, error) {
-	logf("Creating LLM client for provider: %s, model: %s", config.Provider, config.ModelName)
-
-	switch config.Provider {
-	case ProviderOpenAI:
-		if config.APIKey == "" {
-			logf("ERROR: OpenAI API key is missing")
-			return nil, fmt.Errorf("OpenAI API key is required")
-		}
-
-		// Log key length and first/last characters for debugging
-		keyLength := len(config.APIKey)
-		logf("OpenAI: Using API key with length: %d characters", keyLength)
+// batchFormKey is the key in a batch answers file that names its form
+const batchFormKey = "form"
 
-		if keyLength < 20 {
-			logf("WARNING: OpenAI API key seems too short (length: %d), may be invalid", keyLength)
-		}
+// batchResult is the outcome of one file of a batch run
+type batchResult struct {
+	file   string
+	output string
+	err    error
+}
 
-		if keyLength >= 10 {
-			firstChars := config.APIKey[:4]
-			lastChars := config.APIKey[keyLength-4:]
-			logf("OpenAI: Key prefix: %s..., suffix: ...%s", firstChars, lastChars)
+// batchOutputNames maps each answers file to the name of its summary, the file name with .md
+// for its extension. Files that would overwrite each other's summary, such as a.json and
+// a.yaml, are an error. Names differing only in case collide too, as they do on macOS and Windows.
+func batchOutputNames(files []string) (map[string]string, error) {
+	names := make(map[string]string, len(files))
+	claimed := make(map[string]string) // Lowercased summary name to the file it's for
+	for _, file := range files {
+		name := strings.TrimSuffix(file, filepath.Ext(file)) + ".md"
+		if other, ok := claimed[strings.ToLower(name)]; ok {
+			return nil, fmt.Errorf("%s and %s would both be summarized to %s; rename one of them", other, file, name)
 		}
+		claimed[strings.ToLower(name)] = file
+		names[file] = name
+	}
+	return names, nil
+}
 
-		return NewOpenAIClient(config.APIKey, config.ModelName), nil
+// runBatch summarizes every answers file (.json, .yaml, .yml) in opts.batchDir, up to
+// opts.concurrency at a time, and writes each summary to a markdown file of the same name
+// in the output directory. Each file names its form under "form", or falls back to --form.
+// A failed file doesn't stop the others; the run fails if any did.
+func runBatch(opts cliOptions) error {
+	config, err := loadConfig(opts.configDir, opts.profile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	modelKey, modelConfig, err := cliModel(config, opts.modelKey)
+	if err != nil {
+		return err
+	}
 
-	case ProviderAnthropic:
-		if config.APIKey == "" {
-			logf("ERROR: Claude API key is missing")
-			return nil, fmt.Errorf("Claude API key is required")
+	entries, err := ioutil.ReadDir(opts.batchDir)
+	if err != nil {
+		return fmt.Errorf("failed to read batch directory: %v", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml":
+			if !entry.IsDir() {
+				files = append(files, entry.Name())
+			}
 		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no answers files (.json, .yaml, .yml) in %s", opts.batchDir)
+	}
+	outputNames, err := batchOutputNames(files)
+	if err != nil {
+		return err
+	}
 
-		keyLength := len(config.APIKey)
-		logf("Claude: Using API key with length: %d characters", keyLength)
+	outputDir := opts.output
+	if outputDir == "" {
+		outputDir = filepath.Join(opts.batchDir, "summaries")
+	}
+	if err := os.MkdirAll(outputDir, 0700); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
 
-		if keyLength < 20 {
-			logf("WARNING: Claude API key seems too short (length: %d), may be invalid", keyLength)
+	settings := config.requestSettings()
+	if opts.timeout > 0 {
+		settings.Timeout = opts.timeout
+	}
+	forms := loadFormTypes(opts.configDir)
+	chain := config.modelChain(modelKey)
+	concurrency := max(opts.concurrency, 1)
+	logf("Running a batch of %d files from %s with %s, %d at a time", len(files), opts.batchDir, modelKey, concurrency)
+
+	// summarize handles a single file
+	summarize := func(file string) batchResult {
+		result := batchResult{file: file}
+		byQuestion, err := readAnswersFile(filepath.Join(opts.batchDir, file))
+		if err != nil {
+			result.err = err
+			return result
 		}
-
-		return NewClaudeClient(config.APIKey, config.ModelName), nil
-
-	case ProviderLocal:
-		if config.APIBaseURL == "" {
-			logf("ERROR: Local LLM API base URL is missing")
-			return nil, fmt.Errorf("API base URL is required for local models")
+		formName := opts.form
+		if name, ok := byQuestion[batchFormKey]; ok {
+			formName = name
+			delete(byQuestion, batchFormKey)
 		}
-
-		logf("Local LLM: Using API base URL: %s", config.APIBaseURL)
-
-		// Validate model name
-		modelName := config.ModelName
-		if modelName == "" {
-			logf("WARNING: Local LLM model name is empty, using default 'llama3'")
-			modelName = "llama3"
+		if formName == "" {
+			result.err = fmt.Errorf("no form named; add a %q entry or pass --form", batchFormKey)
+			return result
+		}
+		form, err := findFormType(forms, formName)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		answers, err := matchAnswers(byQuestion, form)
+		if err != nil {
+			result.err = err
+			return result
 		}
 
-		logf("Local LLM: Using model name: %s", modelName)
+		md := buildSelectedMarkdown(model{currentForm: form, answers: answers})
+		formPrompt := config.requestPrompt(form)
+		if warning := contextWindowWarning(modelConfig, combinePrompt(formPrompt, md)); warning != "" {
+			fmt.Fprintf(os.Stderr, "%s: Warning: %s\n", file, warning)
+		}
+		response, _, used, err := makeLLMRequestWithFallbacks(context.Background(), chain, settings, formPrompt, md, nil, cliRetryNotice(file+": "))
+		if err != nil {
+			result.err = err
+			return result
+		}
+		if used.key != modelKey {
+			fmt.Fprintf(os.Stderr, "%s: %s was unavailable; the summary was generated by %s\n", file, modelKey, used.key)
+		}
 
-		// Basic URL validation
-		if !strings.HasPrefix(config.APIBaseURL, "http://") && !strings.HasPrefix(config.APIBaseURL, "https://") {
-			logf("WARNING: Local LLM API URL doesn't start with http:// or https://: %s", config.APIBaseURL)
+		// Summaries hold whatever was in the answers, so they're kept private like the config
+		result.output = filepath.Join(outputDir, outputNames[file])
+		if err := ioutil.WriteFile(result.output, []byte(response+"\n"), 0600); err != nil {
+			result.err = fmt.Errorf("failed to write output file: %v", err)
 		}
+		return result
+	}
 
-		return NewLocalLLMClient(config.APIBaseURL, modelName), nil
+	// Run the files through a fixed number of workers, reporting each as it finishes
+	results := make([]batchResult, len(files))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var reportMu sync.Mutex
+	for i, file := range files {
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = summarize(file)
+			reportMu.Lock()
+			defer reportMu.Unlock()
+			if err := results[i].err; err != nil {
+				logf("Batch: %s failed: %v", file, err)
+				fmt.Fprintf(os.Stderr, "failed  %s: %v\n", file, err)
+			} else {
+				logf("Batch: %s written to %s", file, results[i].output)
+				fmt.Fprintf(os.Stderr, "ok      %s -> %s\n", file, results[i].output)
+			}
+		}(i, file)
+	}
+	wg.Wait()
 
-	default:
-		logf("ERROR: Unsupported provider: %s", config.Provider)
-		return nil, fmt.Errorf("unsupported provider: %s", config.Provider)
+	var failed []string
+	for _, result := range results {
+		if result.err != nil {
+			failed = append(failed, result.file)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nBatch finished: %d succeeded, %d failed\n", len(files)-len(failed), len(failed))
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d files failed: %s", len(failed), len(files), strings.Join(failed, ", "))
 	}
+	return nil
 }
 
 // ---[ Main ]------------------------------------------------------------
 func main() {
+	var opts cliOptions
+	flag.StringVar(&opts.form, "form", "", "Form name; runs without the TUI when set")
+	flag.StringVar(&opts.answersFile, "answers", "", "JSON or YAML file mapping each question (its text or number) to its answer (with --form)")
+	flag.StringVar(&opts.modelKey, "model", "", "Model to use, e.g. openai (defaults to the active model)")
+	flag.StringVar(&opts.output, "output", "", "File to write the summary to (defaults to stdout); with --batch, the directory for the summaries (defaults to <dir>/summaries)")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "Time allowed for each request attempt, e.g. 90s (defaults to timeout_seconds in the config, or 2m)")
+	flag.StringVar(&opts.configDir, "config-dir", "", "Directory for config, forms, history and logs (defaults to $TICKETDUCK_CONFIG_DIR, then $XDG_CONFIG_HOME/ticketduck, then ~/.ticketduck)")
+	flag.StringVar(&opts.profile, "profile", "", "Named profile to use; its settings are kept in config.<profile>.json")
+	flag.BoolVar(&opts.encryptKeys, "encrypt-keys", false, "Encrypt the API keys in the config file with a passphrase")
+	flag.StringVar(&opts.batchDir, "batch", "", "Directory of answers files to summarize without the TUI, one summary per file")
+	flag.IntVar(&opts.concurrency, "concurrency", 3, "Number of requests a --batch run sends at once")
+	flag.Parse()
+	opts.configDir = getConfigDir(opts.configDir)
+	if opts.profile != "" && !validProfileName(opts.profile) {
+		fmt.Fprintf(os.Stderr, "Error: invalid profile name %q\n", opts.profile)
+		os.Exit(2)
+	}
+
 	// Initialize logging
-	if err := setupLogging(); err != nil {
-		fmt.Printf("Warning: Failed to setup logging: %v\n", err)
+	if err := setupLogging(opts.configDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to setup logging: %v\n", err)
 	}
+	llm.Logf = logf
 	defer closeLogging()
 
+	if opts.encryptKeys {
+		if err := enableKeyEncryption(opts.configDir, opts.profile); err != nil {
+			logf("Failed to encrypt API keys: %v", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			closeLogging()
+			os.Exit(1)
+		}
+	}
+
+	if opts.batchDir != "" {
+		if opts.answersFile != "" {
+			fmt.Fprintln(os.Stderr, "Error: --batch reads every answers file in its directory, so --answers can't be used with it")
+			closeLogging()
+			os.Exit(2)
+		}
+		if err := runBatch(opts); err != nil {
+			logf("Batch run failed: %v", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			closeLogging()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.form != "" || opts.answersFile != "" {
+		if opts.form == "" || opts.answersFile == "" {
+			fmt.Fprintln(os.Stderr, "Error: --form and --answers must be used together")
+			closeLogging()
+			os.Exit(2)
+		}
+		if err := runNonInteractive(opts); err != nil {
+			logf("Non-interactive run failed: %v", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			closeLogging()
+			os.Exit(1)
+		}
+		return
+	}
+
 	logf("Starting TicketDuck")
 
-	p := tea.NewProgram(initialModel())
+	// Unlock encrypted API keys while the terminal can still be used to ask for the passphrase.
+	// Starting without them would leave the TUI unable to save the config.
+	if config, err := loadConfig(opts.configDir, opts.profile); err != nil && config.Encryption != nil && config.secretKey == nil {
+		logf("Failed to unlock config: %v", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		closeLogging()
+		os.Exit(1)
+	}
+	passphrasePromptAllowed = false
+
+	// The alternate screen keeps the TUI out of the scrollback and restores the shell on exit
+	m := initialModel(fileConfigStore{dir: opts.configDir, profile: opts.profile})
+	tuiStarted = true
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithOutput(terminal))
 	if err := p.Start(); err != nil {
 		logf("Error starting program: %v", err)
 		fmt.Printf("Error starting program: %v\n", err)
@@ -1986,24 +9103,24 @@ func main() {
 	logf("TicketDuck completed successfully")
 }
 
+// scrollPosition describes how far down the viewport is scrolled: "All" when everything fits,
+// "Top" or "Bot" at either end, and a percentage in between
+func scrollPosition(vp viewport.Model) string {
+	switch {
+	case vp.AtTop() && vp.AtBottom():
+		return "All"
+	case vp.AtTop():
+		return "Top"
+	case vp.AtBottom():
+		return "Bot"
+	}
+	return fmt.Sprintf("%d%%", int(vp.ScrollPercent()*100))
+}
+
 // renderStatusBar creates a status bar showing the current mode and other relevant information
 func (m model) renderStatusBar() string {
 	// Get the current mode name
-	var modeName string
-	switch m.currentMode {
-	case selectionMode:
-		modeName = "Selection"
-	case questionMode:
-		modeName = "Question"
-	case displayMode:
-		modeName = "Display"
-	case apiKeyInputMode:
-		modeName = "API Config"
-	case modelSelectMode:
-		modeName = "Model Select"
-	case styleSelectMode:
-		modeName = "Style Select"
-	}
+	modeName := m.currentMode.name()
 
 	duck := m.styles.StatusText.Render(" 🦆 ")
 
@@ -2016,14 +9133,41 @@ func (m model) renderStatusBar() string {
 	// Create the theme indicator
 	themeInfo := m.styles.StatusText.Render(fmt.Sprintf(" Theme: %s", m.styleThemes[m.styleThemeIndex].Name))
 
+	// Create the length and tone indicator
+	styleInfo := m.styles.StatusText.Render(fmt.Sprintf(" Length: %s Tone: %s",
+		optionOrDefault(lengthOptions, m.config.Length), optionOrDefault(toneOptions, m.config.Tone)))
+
+	// Show whether the output is rendered or raw markdown in display mode, whether long lines
+	// wrap, and how far down it's scrolled
+	viewInfo := ""
+	wrapInfo := ""
+	scrollInfo := ""
+	if m.currentMode == displayMode {
+		if m.showRawMarkdown {
+			viewInfo = m.styles.StatusText.Render(" View: Raw")
+		} else {
+			viewInfo = m.styles.StatusText.Render(" View: Rendered")
+		}
+		if m.config.NoWrap {
+			wrapInfo = m.styles.StatusText.Render(" Wrap: off")
+		} else {
+			wrapInfo = m.styles.StatusText.Render(" Wrap: on")
+		}
+		scrollInfo = m.styles.StatusText.Render(" " + scrollPosition(m.viewport))
+	}
+
 	// Join the components
 	bar := lipgloss.JoinHorizontal(lipgloss.Top,
 		duck,
 		modeIndicator,
+		scrollInfo,
 		modelInfo,
 		themeInfo,
+		styleInfo,
+		viewInfo,
+		wrapInfo,
 	)
 
-	// Render the full bar with the theme's status bar style
-	return m.styles.StatusBar.Width(m.width).Render(bar)
+	// Render the full bar with the theme's status bar style, cut to one line in a narrow terminal
+	return m.styles.StatusBar.Width(m.width).MaxHeight(statusBarHeight).Render(bar)
 }
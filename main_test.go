@@ -0,0 +1,1092 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/acarl005/stripansi"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/lipgloss"
+	xansi "github.com/charmbracelet/x/ansi"
+
+	"ticketduck/llm"
+)
+
+// useMockClients makes requests go to the mock client for each model name instead of a provider
+func useMockClients(t *testing.T, clients map[string]*llm.MockClient) {
+	t.Helper()
+	original := newLLMClient
+	newLLMClient = func(config llm.ModelConfig, httpClient *http.Client) (llm.LLMClient, error) {
+		client, ok := clients[config.ModelName]
+		if !ok {
+			t.Fatalf("unexpected request to %s", config.ModelName)
+		}
+		return client, nil
+	}
+	t.Cleanup(func() { newLLMClient = original })
+}
+
+// testSettings sends requests once, without the cache
+func testSettings() RequestSettings {
+	return RequestSettings{Timeout: defaultRequestTimeout}
+}
+
+func TestMakeLLMRequestWithFallbacks(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	tests := []struct {
+		name      string
+		primary   *llm.MockClient
+		fallback  *llm.MockClient
+		wantModel string
+		wantErr   bool
+	}{
+		{"primary answers", &llm.MockClient{Response: "from primary"}, &llm.MockClient{Response: "from fallback"}, "primary", false},
+		{"primary unreachable", &llm.MockClient{Err: refused}, &llm.MockClient{Response: "from fallback"}, "fallback", false},
+		{"primary rejects the request", &llm.MockClient{Err: errors.New("invalid request")}, &llm.MockClient{Response: "from fallback"}, "primary", true},
+		{"both unreachable", &llm.MockClient{Err: refused}, &llm.MockClient{Err: refused}, "fallback", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMockClients(t, map[string]*llm.MockClient{"primary": tt.primary, "fallback": tt.fallback})
+			chain := []namedModel{
+				{key: "primary", config: llm.ModelConfig{Provider: llm.ProviderLocal, ModelName: "primary"}},
+				{key: "fallback", config: llm.ModelConfig{Provider: llm.ProviderLocal, ModelName: "fallback"}},
+			}
+
+			response, _, model, err := makeLLMRequestWithFallbacks(context.Background(), chain, testSettings(), "Summarize.", "answers", nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("makeLLMRequestWithFallbacks() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if model.key != tt.wantModel {
+				t.Errorf("makeLLMRequestWithFallbacks() used %s, want %s", model.key, tt.wantModel)
+			}
+			if !tt.wantErr && response != "from "+tt.wantModel {
+				t.Errorf("makeLLMRequestWithFallbacks() = %q, want the response from %s", response, tt.wantModel)
+			}
+			// The fallback is only asked when the primary is unavailable
+			wantFallbackRequests := 0
+			if tt.wantModel == "fallback" {
+				wantFallbackRequests = 1
+			}
+			if got := len(tt.fallback.Sent()); got != wantFallbackRequests {
+				t.Errorf("fallback was sent %d requests, want %d", got, wantFallbackRequests)
+			}
+		})
+	}
+}
+
+func TestMakeLLMRequestSendsPromptAsSystemMessage(t *testing.T) {
+	mock := &llm.MockClient{Chunks: []string{"Sum", "mary"}}
+	useMockClients(t, map[string]*llm.MockClient{"model": mock})
+
+	var chunks []string
+	response, _, err := makeLLMRequest(context.Background(), llm.ModelConfig{Provider: llm.ProviderLocal, ModelName: "model"}, testSettings(), "Summarize.", "answers", func(chunk string) {
+		chunks = append(chunks, chunk)
+	}, nil)
+	if err != nil {
+		t.Fatalf("makeLLMRequest() error = %v", err)
+	}
+	if response != "Summary" || len(chunks) != 2 {
+		t.Errorf("makeLLMRequest() = %q in %d chunks, want %q in 2", response, len(chunks), "Summary")
+	}
+
+	sent := mock.Sent()
+	want := []llm.Message{{Role: "system", Content: "Summarize."}, {Role: "user", Content: "answers"}}
+	if len(sent) != 1 || len(sent[0]) != 2 || sent[0][0] != want[0] || sent[0][1] != want[1] {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+}
+
+// blockingClient answers only when its request is cancelled, reporting that on cancelled
+type blockingClient struct {
+	cancelled chan struct{}
+}
+
+func (c *blockingClient) Complete(ctx context.Context, prompt string) (string, error) {
+	<-ctx.Done()
+	close(c.cancelled)
+	return "", ctx.Err()
+}
+
+// testModel returns a model with one local model configured and active, kept in memory
+func testModel(t *testing.T) model {
+	t.Helper()
+	config := Config{
+		ActiveModel: "local",
+		Models:      map[string]llm.ModelConfig{"local": {Provider: llm.ProviderLocal, ModelName: "local", APIBaseURL: "http://127.0.0.1:8000", APIStyle: llm.APIStyleOpenAI}},
+		Onboarded:   true,
+	}
+	m := initialModel(newMemoryConfigStore(config))
+	m.config.dir = t.TempDir()
+	m.width, m.height = 100, 40
+	return m
+}
+
+// waitCancelled fails the test unless client's request is cancelled soon
+func waitCancelled(t *testing.T, client *blockingClient) {
+	t.Helper()
+	select {
+	case <-client.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was not cancelled")
+	}
+}
+
+func TestQuitCancelsRequest(t *testing.T) {
+	client := &blockingClient{cancelled: make(chan struct{})}
+	original := newLLMClient
+	newLLMClient = func(llm.ModelConfig, *http.Client) (llm.LLMClient, error) { return client, nil }
+	t.Cleanup(func() { newLLMClient = original })
+
+	m, cmd := startLLMRequest(testModel(t), "answers")
+	go runCmd(cmd) // Starts waiting for the stream, as the program would
+
+	if _, cmd := m.quit(); cmd == nil {
+		t.Fatal("quit() returned no command")
+	}
+	waitCancelled(t, client)
+}
+
+// runCmd runs cmd and any batch it returns, discarding the messages
+func runCmd(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	if batch, ok := cmd().(tea.BatchMsg); ok {
+		for _, c := range batch {
+			go runCmd(c)
+		}
+	}
+}
+
+// finishRequest waits for the request started with cmd to end, so it doesn't outlive the test
+func finishRequest(t *testing.T, cmd tea.Cmd) llmResultMsg {
+	t.Helper()
+	batch, ok := cmd().(tea.BatchMsg)
+	if !ok || len(batch) == 0 {
+		t.Fatal("no request was started")
+	}
+	wait := batch[0]
+	for {
+		switch msg := wait().(type) {
+		case llmResultMsg:
+			return msg
+		case llmChunkMsg:
+			wait = waitForLLMStream(msg.id, msg.stream)
+		case llmRetryMsg:
+			wait = waitForLLMStream(msg.id, msg.stream)
+		default:
+			t.Fatalf("unexpected %T from the request", msg)
+		}
+	}
+}
+
+func TestEscAbandonsRequest(t *testing.T) {
+	client := &blockingClient{cancelled: make(chan struct{})}
+	original := newLLMClient
+	newLLMClient = func(llm.ModelConfig, *http.Client) (llm.LLMClient, error) { return client, nil }
+	t.Cleanup(func() { newLLMClient = original })
+
+	m, cmd := startLLMRequest(testModel(t), "answers")
+	go runCmd(cmd)
+	staleID := m.requestID
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = result.(model)
+	waitCancelled(t, client)
+	if m.currentMode != selectionMode || m.generating {
+		t.Fatalf("after Esc: mode %s, generating %t; want the selection screen with nothing generating", m.currentMode.name(), m.generating)
+	}
+
+	// The cancelled request's result arrives while the user fills in a new form
+	m = m.startForm(0)
+	result, _ = m.Update(llmResultMsg{id: staleID, err: context.Canceled})
+	if m = result.(model); m.currentMode != questionMode {
+		t.Errorf("the abandoned request's result moved the user to %s", m.currentMode.name())
+	}
+}
+
+func TestResultOutsideDisplayModeIsDropped(t *testing.T) {
+	useMockClients(t, map[string]*llm.MockClient{"local": {Response: "summary"}})
+
+	m, cmd := startLLMRequest(testModel(t), "answers")
+	m.currentMode = modelSelectMode // The user pressed ~ while waiting
+
+	result, _ := m.Update(finishRequest(t, cmd))
+	m = result.(model)
+	if m.currentMode != modelSelectMode || m.gptRawOutput == "summary" {
+		t.Errorf("the result was shown outside display mode: mode %s, output %q", m.currentMode.name(), m.gptRawOutput)
+	}
+	if m.generating {
+		t.Error("generating is still set, which would block regenerating")
+	}
+}
+
+func TestRequestErrNamesModel(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	tests := []struct {
+		name      string
+		fallbacks []string
+		want      string
+	}{
+		{"no fallbacks", nil, "Failed to get response from local: "},
+		{"fallback tried", []string{"backup"}, "Failed to get response from backup, the last fallback tried after local was unavailable: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMockClients(t, map[string]*llm.MockClient{"local": {Err: refused}, "backup": {Err: refused}})
+			m := testModel(t)
+			m.config.Models["backup"] = llm.ModelConfig{Provider: llm.ProviderLocal, ModelName: "backup", APIBaseURL: "http://127.0.0.1:8001", APIStyle: llm.APIStyleOpenAI}
+			m.config.Fallbacks = tt.fallbacks
+
+			m, cmd := startLLMRequest(m, "answers")
+			result, _ := m.Update(finishRequest(t, cmd))
+			m = result.(model)
+			if m.currentMode != errorMode || !strings.HasPrefix(m.requestErr, tt.want) {
+				t.Errorf("mode %s, error %q, want the error screen with %q", m.currentMode.name(), m.requestErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchAnswersRequiresAnswers(t *testing.T) {
+	form := formType{name: "Bug", questions: []string{"What broke?", "Anything else?"}, required: []bool{true}}
+	tests := []struct {
+		name       string
+		byQuestion map[string]string
+		wantErr    bool
+	}{
+		{"all answered", map[string]string{"1": "the build", "2": "no"}, false},
+		{"optional left out", map[string]string{"What broke?": "the build"}, false},
+		{"required left out", map[string]string{"2": "no"}, true},
+		{"required blank", map[string]string{"1": "  ", "2": "no"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := matchAnswers(tt.byQuestion, form)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("matchAnswers() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendAnswersRequiresAnswers(t *testing.T) {
+	useMockClients(t, map[string]*llm.MockClient{"local": {Response: "summary"}})
+
+	m := testModel(t)
+	m.currentForm = formType{name: "Bug", prompt: "Summarize.", questions: []string{"Title?", "What broke?"}, required: []bool{false, true}}
+	m.answers = []string{"Login", ""}
+	m.currentMode = questionMode // As after a Ctrl+g jump past the required question
+
+	result, _ := sendAnswers(m)
+	m = result.(model)
+	if m.currentMode != reviewMode || m.generating {
+		t.Fatalf("sent with a required answer missing: mode %s, generating %t", m.currentMode.name(), m.generating)
+	}
+	if m.reviewCursor != 1 || m.reviewWarning == "" {
+		t.Errorf("review cursor %d, warning %q; want the cursor on question 2 with a warning", m.reviewCursor, m.reviewWarning)
+	}
+
+	m.answers[1] = "Every login fails"
+	result, cmd := sendAnswers(m)
+	if m = result.(model); m.currentMode != displayMode || !m.generating {
+		t.Fatalf("complete answers weren't sent: mode %s, generating %t", m.currentMode.name(), m.generating)
+	}
+	if msg := finishRequest(t, cmd); msg.err != nil || msg.content != "summary" {
+		t.Errorf("request ended with %q, %v; want the summary", msg.content, msg.err)
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	salt := "MDEyMzQ1Njc4OWFiY2RlZg==" // "0123456789abcdef"
+
+	// Keys must come out as they did before, or existing configs couldn't be decrypted
+	key, err := keyEncryption{Salt: salt, Iterations: keyDerivationRounds}.deriveKey("correct horse")
+	if err != nil {
+		t.Fatalf("deriveKey() error = %v", err)
+	}
+	if got, want := fmt.Sprintf("%x", key), "91828083f760ed60bb550e24f3e89aa29bbcb27c4219d034c4c4f03c2e6db9d9"; got != want {
+		t.Errorf("deriveKey() = %s, want %s", got, want)
+	}
+
+	for _, iterations := range []int{0, 1, keyDerivationRounds - 1, maxDerivationRounds + 1} {
+		if _, err := (keyEncryption{Salt: salt, Iterations: iterations}).deriveKey("correct horse"); err == nil {
+			t.Errorf("deriveKey() accepted %d iterations", iterations)
+		}
+	}
+}
+
+func TestNextProfileWarningStaysInTUI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits don't apply on Windows")
+	}
+	m := testModel(t)
+	if err := os.WriteFile(filepath.Join(m.config.dir, configFileName("work")), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Anything written to stderr now would land in the middle of the TUI
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	tuiStarted = true
+	t.Cleanup(func() {
+		os.Stderr = stderr
+		tuiStarted = false
+		takeWarnings()
+	})
+
+	m = m.nextProfile()
+	w.Close()
+	os.Stderr = stderr
+	written, _ := io.ReadAll(r)
+
+	if m.config.profile != "work" {
+		t.Fatalf("profile = %q, want work", m.config.profile)
+	}
+	if len(written) != 0 {
+		t.Errorf("wrote %q to stderr while the TUI was running", written)
+	}
+	if !strings.Contains(m.modelStatus, "readable by other users") {
+		t.Errorf("modelStatus = %q, want the permissions warning", m.modelStatus)
+	}
+	if warnings := takeWarnings(); len(warnings) != 0 {
+		t.Errorf("warnings left over after being shown: %q", warnings)
+	}
+}
+
+func TestBellCmdWritesThroughTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	output := terminal
+	terminal = &terminalOutput{File: w}
+	t.Cleanup(func() { terminal = output })
+
+	m := testModel(t)
+	if cmd := m.bellCmd(nil); cmd != nil {
+		t.Fatal("bell rung with BellOnComplete off")
+	}
+
+	m.config.BellOnComplete = true
+	m.bellCmd(nil)()
+	m.bellCmd(errors.New("failed"))()
+	w.Close()
+	written, _ := io.ReadAll(r)
+	if string(written) != "\a\a\a\a" {
+		t.Errorf("bells wrote %q, want one for success and three for an error", written)
+	}
+}
+
+func TestTooSmallOnlyQuits(t *testing.T) {
+	m := testModel(t)
+	m.formTypes = []formType{{name: "Bug", questions: []string{"What broke?"}}}
+	m = m.startForm(0)
+	m.width, m.height = 20, 5
+	if !m.tooSmall() {
+		t.Fatal("20x5 isn't too small")
+	}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	m = result.(model)
+	if got := m.answerInput.Value(); got != "" {
+		t.Errorf("typed %q into the hidden answer", got)
+	}
+
+	// The form has no answers yet, so q quits without asking
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if cmd == nil {
+		t.Fatal("q didn't quit")
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Error("q didn't quit")
+	}
+}
+
+func TestBatchOutputNames(t *testing.T) {
+	tests := []struct {
+		files   []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{files: []string{"a.json", "b.yaml", "c.yml"}, want: map[string]string{"a.json": "a.md", "b.yaml": "b.md", "c.yml": "c.md"}},
+		{files: []string{"a.json", "a.yaml"}, wantErr: true},
+		{files: []string{"a.yaml", "a.yml"}, wantErr: true},
+		{files: []string{"Bug.json", "bug.yaml"}, wantErr: true},
+		{files: []string{"a.b.json", "a.json"}, want: map[string]string{"a.b.json": "a.b.md", "a.json": "a.md"}},
+	}
+	for _, tt := range tests {
+		got, err := batchOutputNames(tt.files)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("batchOutputNames(%q) error = %v, wantErr %t", tt.files, err, tt.wantErr)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) && !tt.wantErr {
+			t.Errorf("batchOutputNames(%q) = %v, want %v", tt.files, got, tt.want)
+		}
+	}
+}
+
+func TestActiveModelModeTransitions(t *testing.T) {
+	useMockClients(t, map[string]*llm.MockClient{"local": {Response: "summary"}})
+	models := map[string]llm.ModelConfig{"local": {Provider: llm.ProviderLocal, ModelName: "local", APIBaseURL: "http://127.0.0.1:8000", APIStyle: llm.APIStyleOpenAI}}
+	tests := []struct {
+		activeModel string
+		want        mode
+	}{
+		{"local", selectionMode},
+		{"", modelSelectMode},
+		{"removed", modelSelectMode}, // Named but not in the config
+	}
+	for _, tt := range tests {
+		config := Config{ActiveModel: tt.activeModel, Models: models, Onboarded: true, dir: t.TempDir()}
+		store := newMemoryConfigStore(config)
+
+		m := initialModel(store)
+		if m.currentMode != tt.want {
+			t.Errorf("active model %q: started in %s, want %s", tt.activeModel, m.currentMode.name(), tt.want.name())
+		}
+
+		// Finishing setup lands in the same place
+		m.onboarding = true
+		if m = m.finishOnboarding(); m.currentMode != tt.want {
+			t.Errorf("active model %q: setup finished in %s, want %s", tt.activeModel, m.currentMode.name(), tt.want.name())
+		}
+		if saved, _ := store.Load(); !saved.Onboarded || store.Saves() != 1 {
+			t.Errorf("active model %q: finishing setup saved %d times, onboarded %t", tt.activeModel, store.Saves(), saved.Onboarded)
+		}
+
+		// Sending answers without a usable model asks for one instead
+		m.width, m.height = 100, 40
+		m.currentForm = formType{name: "Bug", prompt: "Summarize.", questions: []string{"What broke?"}}
+		m.answers = []string{"Login"}
+		result, cmd := sendAnswers(m)
+		m = result.(model)
+		if tt.want == selectionMode {
+			if !m.generating {
+				t.Errorf("active model %q: answers weren't sent", tt.activeModel)
+			} else {
+				finishRequest(t, cmd)
+			}
+		} else if m.currentMode != modelSelectMode || m.generating || m.config.ActiveModel != "" {
+			t.Errorf("active model %q: sending went to %s, generating %t, active model %q", tt.activeModel, m.currentMode.name(), m.generating, m.config.ActiveModel)
+		}
+	}
+}
+
+// fakeClipboard records what was copied to it, or fails with err
+type fakeClipboard struct {
+	text string
+	err  error
+}
+
+func (c *fakeClipboard) Write(text string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.text = text
+	return nil
+}
+
+func TestCopyInDisplayMode(t *testing.T) {
+	unavailable := errors.New("no clipboard")
+	tests := []struct {
+		name         string
+		ssh          bool
+		systemErr    error
+		terminalErr  error
+		wantSystem   bool
+		wantTerminal bool
+		wantStatus   string
+		wantSaved    bool
+	}{
+		{name: "system", wantSystem: true, wantStatus: "Copied as", wantSaved: true},
+		{name: "fallback", systemErr: unavailable, wantTerminal: true, wantStatus: "Sent to the terminal clipboard", wantSaved: true},
+		{name: "over ssh", ssh: true, wantTerminal: true, wantStatus: "Sent to the terminal clipboard", wantSaved: true},
+		{name: "both fail", systemErr: unavailable, terminalErr: errors.New("closed"), wantStatus: "Copy failed: closed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSH_TTY", "")
+			t.Setenv("SSH_CONNECTION", "")
+			if tt.ssh {
+				t.Setenv("SSH_TTY", "/dev/pts/0")
+			}
+			system, terminal := &fakeClipboard{err: tt.systemErr}, &fakeClipboard{err: tt.terminalErr}
+			m := testModel(t)
+			m.clipboard, m.terminalClipboard = system, terminal
+			m.currentMode = displayMode
+			m.gptRawOutput = "Login fails for every user"
+
+			result, _ := m.Update(tea.KeyMsg{Type: tea.KeyCtrlY})
+			m = result.(model)
+			if (system.text != "") != tt.wantSystem || (terminal.text != "") != tt.wantTerminal {
+				t.Errorf("copied %q to the system clipboard and %q to the terminal's", system.text, terminal.text)
+			}
+			if copied := system.text + terminal.text; tt.wantSystem || tt.wantTerminal {
+				if !strings.Contains(copied, "Login fails for every user") {
+					t.Errorf("copied %q, want the summary", copied)
+				}
+			}
+			if !strings.Contains(m.displayStatus, tt.wantStatus) {
+				t.Errorf("displayStatus = %q, want it to contain %q", m.displayStatus, tt.wantStatus)
+			}
+			if m.outputSaved != tt.wantSaved {
+				t.Errorf("outputSaved = %t, want %t", m.outputSaved, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestRenderedMarkdownFitsViewport(t *testing.T) {
+	md := `# Login fails for every user after the deploy of the new authentication service
+
+## What happened
+
+Since this morning's deploy, every attempt to sign in returns a 500 error from the authentication service, and the error page suggests trying again later, which doesn't help.
+
+- The session cookie is set, but its expiry is already in the past when the browser receives it
+- Clearing cookies, switching browsers and using a private window all fail the same way
+  - Nested item that is also long enough to need wrapping at any of the widths tested here
+
+1. Open the login page and enter a valid username and password for any account
+2. Submit the form and watch the response in the browser's network panel
+
+> Quoted from the customer: "Nobody in our team has been able to log in since nine o'clock this morning."
+
+**Severity:** high — _every_ user is affected, including administrators with ` + "`sso_bypass`" + ` enabled.
+
+日本語のテキストも折り返されるべきです。日本語のテキストも折り返されるべきです。日本語のテキストも折り返されるべきです。
+
+See [the incident report](https://status.example.com/incidents/2026-10-18) and https://logs.example.com/search?query=auth-service+status%3A500&from=2026-10-18T09%3A00%3A00Z&to=now
+`
+	m := testModel(t)
+	for _, theme := range m.styleThemes {
+		for _, width := range []int{40, 60, 80, 120} {
+			for _, hyperlinks := range []bool{false, true} {
+				vp := viewport.New(width, 20)
+				vp.Style = lipgloss.NewStyle().BorderStyle(lipgloss.RoundedBorder()).PaddingLeft(2).PaddingRight(2)
+				contentWidth := viewportContentWidth(&vp)
+
+				// A viewport without a width shows its lines as they are, so overlong ones aren't hidden
+				probe := viewport.New(0, 1000)
+				if err := renderMarkdownToViewportWidth(md, &probe, theme, contentWidth, hyperlinks); err != nil {
+					t.Fatalf("%s at %d: %v", theme.Name, width, err)
+				}
+				for _, line := range strings.Split(probe.View(), "\n") {
+					if w := lipgloss.Width(line); w > contentWidth {
+						t.Errorf("%s at %d (hyperlinks %t): line is %d wide, more than the viewport's %d: %q", theme.Name, width, hyperlinks, w, contentWidth, line)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestAPIConfigFieldsWrapAround(t *testing.T) {
+	tests := []struct {
+		model string
+		want  []apiConfigField // In Tab order, starting from the field focused on entry
+	}{
+		{"local", []apiConfigField{fieldBaseURL, fieldAPIKey, fieldModelName, fieldSaveConfig}},
+		{"openai", []apiConfigField{fieldAPIKey, fieldModelName, fieldSaveConfig}},
+	}
+	for _, tt := range tests {
+		m := testModel(t)
+		m.selectedModel = tt.model
+		m, _ = m.enterAPIKeyInputMode()
+
+		press := func(key tea.KeyType) {
+			result, _ := m.updateAPIKeyInputMode(tea.KeyMsg{Type: key})
+			m = result.(model)
+		}
+		check := func(step string, want apiConfigField) {
+			t.Helper()
+			if got := m.focusedField(); got != want {
+				t.Errorf("%s: after %s the focus is on field %d, want %d", tt.model, step, got, want)
+			}
+			focused := map[apiConfigField]bool{fieldAPIKey: m.apiKeyInput.Focused(), fieldBaseURL: m.apiBaseInput.Focused(), fieldModelName: m.modelNameInput.Focused()}
+			for field, isFocused := range focused {
+				if isFocused != (field == want) {
+					t.Errorf("%s: after %s the input for field %d has focus %t", tt.model, step, field, isFocused)
+				}
+			}
+		}
+
+		check("entering", tt.want[0])
+		for i := 1; i <= len(tt.want); i++ {
+			press(tea.KeyTab)
+			check(fmt.Sprintf("%d Tabs", i), tt.want[i%len(tt.want)])
+		}
+		press(tea.KeyShiftTab)
+		check("Shift+Tab from the first field", tt.want[len(tt.want)-1])
+		for i := len(tt.want) - 2; i >= 0; i-- {
+			press(tea.KeyShiftTab)
+			check("Shift+Tab", tt.want[i])
+		}
+	}
+}
+
+func TestGlamourStyleConfigColors(t *testing.T) {
+	theme := StyleTheme{
+		Name:   "Test",
+		Base:   lipgloss.AdaptiveColor{Light: "#111111", Dark: "#eeeeee"},
+		Accent: lipgloss.AdaptiveColor{Light: "#222222", Dark: "#dddddd"},
+	}
+	color := func(c *string) string {
+		if c == nil {
+			return "<default>"
+		}
+		return *c
+	}
+	for _, dark := range []bool{true, false} {
+		defaults, base := styles.LightStyleConfig, theme.Base.Light
+		if dark {
+			defaults, base = styles.DarkStyleConfig, theme.Base.Dark
+		}
+		cfg := glamourStyleConfig(theme, dark)
+
+		headings := []ansi.StyleBlock{cfg.Heading, cfg.H1, cfg.H2, cfg.H3, cfg.H4, cfg.H5, cfg.H6}
+		for level, h := range headings {
+			if got := color(h.Color); got != base {
+				t.Errorf("dark %t: heading %d is %s, want the theme's %s", dark, level, got, base)
+			}
+		}
+
+		// Body text keeps the terminal theme's foreground, so it stays readable on its background
+		if got, want := color(cfg.Document.Color), color(defaults.Document.Color); got != want {
+			t.Errorf("dark %t: document text is %s, want the default %s", dark, got, want)
+		}
+		if got, want := color(cfg.Paragraph.Color), color(defaults.Paragraph.Color); got != want {
+			t.Errorf("dark %t: paragraph text is %s, want the default %s", dark, got, want)
+		}
+		if got, want := color(cfg.Text.Color), color(defaults.Text.Color); got != want {
+			t.Errorf("dark %t: text is %s, want the default %s", dark, got, want)
+		}
+	}
+
+	// The shared defaults mustn't be changed by building a theme's style
+	if got := color(styles.DarkStyleConfig.H1.Color); got == theme.Base.Dark {
+		t.Errorf("building the style changed Glamour's dark default H1 to %s", got)
+	}
+	if cfg := glamourStyleConfig(StyleTheme{Name: monochromeThemeName}, true); color(cfg.H1.Color) != color(styles.NoTTYStyleConfig.H1.Color) {
+		t.Error("the monochrome theme doesn't use the plain style")
+	}
+}
+
+func TestConfigDirOverride(t *testing.T) {
+	home, xdg, env := t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv("TICKETDUCK_CONFIG_DIR", env)
+
+	tests := []struct {
+		override, env, xdg string
+		want               string
+	}{
+		{"/override", env, xdg, "/override"},
+		{"", env, xdg, env},
+		{"", "", xdg, filepath.Join(xdg, "ticketduck")},
+		{"", "", "", filepath.Join(home, ".ticketduck")},
+	}
+	for _, tt := range tests {
+		t.Setenv("TICKETDUCK_CONFIG_DIR", tt.env)
+		t.Setenv("XDG_CONFIG_HOME", tt.xdg)
+		if got := getConfigDir(tt.override); got != tt.want {
+			t.Errorf("getConfigDir(%q) with TICKETDUCK_CONFIG_DIR=%q XDG_CONFIG_HOME=%q = %q, want %q", tt.override, tt.env, tt.xdg, got, tt.want)
+		}
+	}
+
+	// Everything is written under the override, and nothing where it would otherwise go
+	t.Setenv("TICKETDUCK_CONFIG_DIR", env)
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	dir := getConfigDir(t.TempDir())
+	if err := setupLogging(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeLogging()
+		logger, logFile = nil, nil
+	})
+	config, err := loadConfig(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheTTLMinutes = 10
+	if err := (fileConfigStore{dir: dir}).Save(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCachedResponse(config.requestSettings(), "key", "Summary"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pattern := range []string{"config.json", "cache/key.json", "logs/ticketduck_*.log"} {
+		if matches, _ := filepath.Glob(filepath.Join(dir, pattern)); len(matches) != 1 {
+			t.Errorf("found %d files matching %s under the override, want 1", len(matches), pattern)
+		}
+	}
+	for _, other := range []string{home, xdg, env} {
+		if entries, _ := os.ReadDir(other); len(entries) != 0 {
+			t.Errorf("wrote to %s despite the override: %v", other, entries)
+		}
+	}
+}
+
+func TestLoadConfigDanglingActiveModel(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"active_model": "removed", "onboarded": true, "models": {"mine": {"provider": "local", "model_name": "llama3", "api_base_url": "http://127.0.0.1:11434"}}}`
+	if err := os.WriteFile(filepath.Join(dir, configFileName("")), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig(dir, "")
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.ActiveModel != "" {
+		t.Errorf("ActiveModel = %q, want it cleared", config.ActiveModel)
+	}
+	if _, ok := config.Models["mine"]; !ok {
+		t.Error("the configured providers were lost")
+	}
+
+	// The TUI asks for a model rather than sending to an empty provider
+	m := initialModel(fileConfigStore{dir: dir})
+	if m.currentMode != modelSelectMode {
+		t.Errorf("started in %s, want %s", m.currentMode.name(), modelSelectMode.name())
+	}
+	m.width, m.height = 100, 40
+	m.currentForm = formType{name: "Bug", prompt: "Summarize.", questions: []string{"What broke?"}}
+	m.answers = []string{"Login"}
+	if m, _ = handleFormCompletion(m); m.currentMode != modelSelectMode || m.generating {
+		t.Errorf("finishing the form went to %s, generating %t; want model selection", m.currentMode.name(), m.generating)
+	}
+}
+
+func TestNextProfileFromUnsavedProfile(t *testing.T) {
+	tests := []struct {
+		saved []string // Named profiles with a config file
+		want  []string // Profiles visited by pressing p repeatedly from the unsaved "work"
+	}{
+		{nil, []string{"", ""}},
+		{[]string{"home"}, []string{"", "home", ""}},
+	}
+	for _, tt := range tests {
+		m := testModel(t)
+		for _, profile := range tt.saved {
+			if err := os.WriteFile(filepath.Join(m.config.dir, configFileName(profile)), []byte("{}"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m.config.profile = "work" // Started with --profile work, not saved yet
+
+		var visited []string
+		for range tt.want {
+			m = m.nextProfile()
+			visited = append(visited, m.config.profile)
+		}
+		if fmt.Sprint(visited) != fmt.Sprint(tt.want) {
+			t.Errorf("saved %q: p went through %q, want %q", tt.saved, visited, tt.want)
+		}
+	}
+}
+
+func TestStatusBarShowsWrapState(t *testing.T) {
+	m := testModel(t)
+	m.width = 200 // Wide enough that the bar isn't cut short
+	if bar := stripansi.Strip(m.renderStatusBar()); strings.Contains(bar, "Wrap:") {
+		t.Errorf("status bar outside display mode shows the wrap state: %q", bar)
+	}
+
+	m.currentMode = displayMode
+	for _, noWrap := range []bool{false, true} {
+		m.config.NoWrap = noWrap
+		want := " Wrap: on"
+		if noWrap {
+			want = " Wrap: off"
+		}
+		if bar := stripansi.Strip(m.renderStatusBar()); !strings.Contains(bar, want) {
+			t.Errorf("NoWrap %t: status bar %q doesn't contain %q", noWrap, bar, want)
+		}
+	}
+}
+
+func TestSaveBacksUpNewerConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, configFileName(""))
+	newer := fmt.Sprintf(`{"version": %d, "onboarded": true, "future_setting": "keep me"}`, currentConfigVersion+1)
+	if err := os.WriteFile(configFile, []byte(newer), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tuiStarted = true // Collect the warning instead of printing it
+	t.Cleanup(func() { tuiStarted = false })
+	config, err := loadConfig(dir, "")
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if warnings := takeWarnings(); len(warnings) != 1 || !strings.Contains(warnings[0], "newer than this build supports") {
+		t.Errorf("warnings = %q, want one about the newer version", warnings)
+	}
+
+	if err := saveConfig(config); err != nil {
+		t.Fatalf("saveConfig() error = %v", err)
+	}
+	if backup, _ := os.ReadFile(configFile + ".bak"); string(backup) != newer {
+		t.Errorf("backup = %q, want the newer file as it was", backup)
+	}
+
+	// The saved file is now this version's, so saving again keeps the backup
+	config.Length = "short"
+	if err := saveConfig(config); err != nil {
+		t.Fatalf("second saveConfig() error = %v", err)
+	}
+	if backup, _ := os.ReadFile(configFile + ".bak"); string(backup) != newer {
+		t.Errorf("the second save replaced the backup with %q", backup)
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	tests := []struct {
+		action  keyAction
+		keys    []string
+		want    []string
+		wantErr bool
+	}{
+		{action: actionCopy, keys: []string{"alt+c", "ctrl+y"}, want: []string{"alt+c", "ctrl+y"}},
+		{action: actionCopy, keys: []string{"alt+c", "alt+c"}, want: []string{"alt+c"}},
+		{action: actionTop, keys: []string{"g  g"}, want: []string{"g g"}},
+		{action: actionScrollDown, keys: []string{"enter"}, want: []string{"enter"}},
+		{action: actionQuit, keys: []string{"ctrl+x", "f10"}, want: []string{"ctrl+x", "f10"}},
+		{action: actionCopy, keys: nil, wantErr: true},
+		{action: actionCopy, keys: []string{"~"}, wantErr: true},      // Reserved
+		{action: actionCopy, keys: []string{"ctrl+1"}, wantErr: true}, // Not a key
+		{action: actionCopy, keys: []string{"a b c"}, wantErr: true},
+		{action: actionQuit, keys: []string{"x"}, wantErr: true},
+		{action: actionQuit, keys: []string{"q q"}, wantErr: true},
+		{action: actionMoveDown, keys: []string{"g j"}, wantErr: true}, // Sequences are display mode only
+		{action: actionFilter, keys: []string{"enter"}, wantErr: true},
+		{action: actionDelete, keys: []string{"3"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := checkKeys(tt.action, tt.keys)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkKeys(%s, %q) error = %v, wantErr %t", tt.action, tt.keys, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("checkKeys(%s, %q) = %q, want %q", tt.action, tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestConflictingActions(t *testing.T) {
+	tests := []struct {
+		changes map[keyAction][]string
+		want    []keyAction
+	}{
+		{changes: nil, want: nil},
+		{changes: map[keyAction][]string{actionCopy: {"p"}}, want: []keyAction{actionCopy, actionPostSlack}},
+		// Edit-prompt is on the selection screen, post-slack in display mode
+		{changes: map[keyAction][]string{actionEditPrompt: {"n"}}, want: nil},
+		{changes: map[keyAction][]string{actionFilter: {"c"}}, want: []keyAction{actionConfigure, actionFilter}},
+		// Quit clashes with actions on any screen
+		{changes: map[keyAction][]string{actionQuit: {"ctrl+d"}}, want: []keyAction{actionHalfPageDown, actionQuit, actionSend}},
+		// g alone would never get to the second g of the top sequence
+		{changes: map[keyAction][]string{actionNote: {"g"}}, want: []keyAction{actionNote, actionTop}},
+	}
+	for _, tt := range tests {
+		got := conflictingActions(withKeyBindings(defaultKeyBindings, tt.changes))
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("conflictingActions with %v = %v, want %v", tt.changes, got, tt.want)
+		}
+	}
+}
+
+func TestNewKeymapFallback(t *testing.T) {
+	tests := []struct {
+		preset  string
+		keys    map[string][]string
+		action  keyAction
+		want    []string
+		warning string
+	}{
+		{preset: "", action: actionCopy, want: []string{"ctrl+y"}},
+		{preset: "vim", action: actionCopy, want: []string{"y"}},
+		{preset: "emacs", action: actionCopy, want: []string{"ctrl+y"}, warning: `unknown key preset "emacs"`},
+		{keys: map[string][]string{"teleport": {"t"}}, action: actionCopy, want: []string{"ctrl+y"}, warning: `unknown key action "teleport"`},
+		{keys: map[string][]string{"send": {"alt+s"}}, action: actionSend, want: []string{"alt+s"}},
+		{keys: map[string][]string{"copy": {"?"}}, action: actionCopy, want: []string{"ctrl+y"}, warning: "keys for copy: ? is reserved"},
+		{preset: "vim", keys: map[string][]string{"copy": {"esc"}}, action: actionCopy, want: []string{"y"}, warning: "keys for copy"},
+		{keys: map[string][]string{"copy": {"f"}}, action: actionCopy, want: []string{"ctrl+y"}, warning: "keys for copy conflict"},
+	}
+	for _, tt := range tests {
+		km, warnings := newKeymap(tt.preset, tt.keys)
+		if got := km.bindings[tt.action]; fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("preset %q, keys %v: %s bound to %q, want %q", tt.preset, tt.keys, tt.action, got, tt.want)
+		}
+		switch {
+		case tt.warning == "" && len(warnings) > 0:
+			t.Errorf("preset %q, keys %v: unexpected warnings %q", tt.preset, tt.keys, warnings)
+		case tt.warning != "" && (len(warnings) != 1 || !strings.Contains(warnings[0], tt.warning)):
+			t.Errorf("preset %q, keys %v: warnings = %q, want one containing %q", tt.preset, tt.keys, warnings, tt.warning)
+		}
+		for _, key := range km.bindings[tt.action] {
+			for _, scope := range scopesOf(tt.action) {
+				if got := km.action(scope, key); got != tt.action {
+					t.Errorf("preset %q, keys %v: %s on screen %d is %q, want %s", tt.preset, tt.keys, key, scope, got, tt.action)
+				}
+			}
+		}
+	}
+}
+
+func TestKeymapWarningsAreShown(t *testing.T) {
+	tuiStarted = true // Collect the warnings instead of printing them
+	t.Cleanup(func() { tuiStarted = false })
+
+	Config{KeyPreset: "emacs"}.keymap()
+	if warnings := takeWarnings(); len(warnings) != 1 || !strings.Contains(warnings[0], `keymap: unknown key preset "emacs"`) {
+		t.Errorf("warnings = %q, want the unknown preset", warnings)
+	}
+}
+
+func TestRemappedKeysOnOtherScreens(t *testing.T) {
+	m := testModel(t)
+	m.keys, _ = newKeymap("", map[string][]string{"move-down": {"alt+j"}, "history": {"H"}})
+	m.currentMode = selectionMode
+	m.cursor = 0
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j")})
+	if m = result.(model); m.cursor != 0 {
+		t.Errorf("j moved the cursor to %d after move-down was remapped", m.cursor)
+	}
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j"), Alt: true})
+	if m = result.(model); m.cursor != 1 {
+		t.Errorf("alt+j left the cursor at %d, want 1", m.cursor)
+	}
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("H")})
+	if m = result.(model); m.currentMode != historyMode {
+		t.Errorf("H went to %s, want history", m.currentMode.name())
+	}
+}
+
+func TestFrontMatterTokenCounts(t *testing.T) {
+	tests := []struct {
+		usage *llm.Usage
+		want  string
+	}{
+		{usage: &llm.Usage{PromptTokens: 120, CompletionTokens: 45}, want: "prompt_tokens: 120\ncompletion_tokens: 45\ntokens_estimated: false\n"},
+		// Without the provider's counts, "summary" is estimated at two tokens
+		{usage: nil, want: "completion_tokens: 2\ntokens_estimated: true\n"},
+	}
+	for _, tt := range tests {
+		useMockClients(t, map[string]*llm.MockClient{"local": {Response: "summary", Usage: tt.usage}})
+		m, cmd := startLLMRequest(testModel(t), "answers")
+		result, _ := m.Update(finishRequest(t, cmd))
+		m = result.(model)
+
+		if got := m.summaryFrontMatter(); !strings.Contains(got, tt.want) {
+			t.Errorf("usage %+v: front matter\n%s\nwant it to contain\n%s", tt.usage, got, tt.want)
+		}
+	}
+}
+
+func TestHyperlinks(t *testing.T) {
+	report := "https://status.example.com/incidents/2026-10-18"
+	logs := "https://logs.example.com/search?query=auth-service+status%3A500&from=2026-10-18T09%3A00%3A00Z"
+	md := "See [the incident report](" + report + "), the [runbook](runbook.md) and " + logs + "\n"
+
+	probe := viewport.New(0, 1000)
+	if err := renderMarkdownToViewportWidth(md, &probe, styleThemes[0], 40, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Collect the visible text of each link, checking that none is left open at the end of a line
+	linked := map[string]string{}
+	for _, line := range strings.Split(probe.View(), "\n") {
+		open := ""
+		last := 0
+		for _, match := range osc8Pattern.FindAllStringSubmatchIndex(line, -1) {
+			if open != "" {
+				linked[open] += xansi.Strip(line[last:match[0]])
+			}
+			open, last = line[match[2]:match[3]], match[1]
+		}
+		if open != "" {
+			t.Errorf("line %q leaves the link to %s open", xansi.Strip(line), open)
+		}
+	}
+
+	if !strings.Contains(linked[report], "the incident report") || !strings.Contains(linked[report], report) {
+		t.Errorf("the link to the report covers %q, want its text and its URL", linked[report])
+	}
+	// Glamour prints a bare URL twice, as the link's text and as its target
+	if linked[logs] != logs+logs {
+		t.Errorf("the link to the logs covers %q, want the whole URL although it's wrapped", linked[logs])
+	}
+	for u, text := range linked {
+		if u != report && u != logs {
+			t.Errorf("unexpected link to %q covering %q", u, text)
+		}
+	}
+}
+
+func TestHyperlinksSetting(t *testing.T) {
+	for _, name := range []string{"TERM_PROGRAM", "WT_SESSION", "KITTY_WINDOW_ID", "VTE_VERSION", "KONSOLE_VERSION"} {
+		t.Setenv(name, "")
+	}
+	tests := []struct {
+		setting string
+		env     map[string]string
+		theme   string
+		want    bool
+	}{
+		{setting: "", env: map[string]string{"TERM": "xterm-256color"}, want: false},
+		{setting: "", env: map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "iTerm.app"}, want: true},
+		{setting: "auto", env: map[string]string{"TERM": "xterm-256color", "VTE_VERSION": "7600"}, want: true},
+		{setting: "", env: map[string]string{"TERM": "xterm-256color", "VTE_VERSION": "4800"}, want: false},
+		{setting: "", env: map[string]string{"TERM": "xterm-kitty"}, want: true},
+		{setting: "", env: map[string]string{"TERM": "xterm-kitty"}, theme: monochromeThemeName, want: false},
+		{setting: "on", env: map[string]string{"TERM": "linux"}, want: true},
+		{setting: "off", env: map[string]string{"TERM": "xterm-256color", "WT_SESSION": "1"}, want: false},
+	}
+	for _, tt := range tests {
+		for name, value := range tt.env {
+			t.Setenv(name, value)
+		}
+		theme := styleThemes[0]
+		for _, builtin := range styleThemes {
+			if builtin.Name == tt.theme {
+				theme = builtin
+			}
+		}
+		if got := (Config{Hyperlinks: tt.setting}).hyperlinks(theme); got != tt.want {
+			t.Errorf("hyperlinks %q with %v and the %s theme = %t, want %t", tt.setting, tt.env, theme.Name, got, tt.want)
+		}
+		for name := range tt.env {
+			t.Setenv(name, "")
+		}
+	}
+}